@@ -0,0 +1,575 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/matthewdavidson09/dynamic-distro-groups/internal/active_directory"
+	"github.com/matthewdavidson09/dynamic-distro-groups/internal/config"
+	"github.com/matthewdavidson09/dynamic-distro-groups/internal/ldapclient"
+	"github.com/matthewdavidson09/dynamic-distro-groups/internal/plan"
+	"github.com/matthewdavidson09/dynamic-distro-groups/internal/state"
+	"github.com/matthewdavidson09/dynamic-distro-groups/internal/sync"
+	"github.com/matthewdavidson09/dynamic-distro-groups/tools"
+)
+
+// selectionFlags registers the --only and --targets flags shared by sync, plan and adopt.
+func selectionFlags(fs *flag.FlagSet) func() (sync.Options, error) {
+	only := fs.String("only", "", "Comma-separated rule IDs, group mails or CNs to include")
+	targets := fs.String("targets", "", "Comma-separated targets to sync: ad, google (default: each rule's own)")
+
+	return func() (sync.Options, error) {
+		opts := sync.Options{Only: splitList(*only), Targets: splitList(strings.ToLower(*targets))}
+		for _, t := range opts.Targets {
+			if t != config.TargetAD && t != config.TargetGoogle {
+				return opts, fmt.Errorf("unknown target %q", t)
+			}
+		}
+		return opts, nil
+	}
+}
+
+func runSync(a *app, args []string) error {
+	fs := flag.NewFlagSet("sync", flag.ExitOnError)
+	dryRun := fs.Bool("dry-run", false, "Log changes without modifying AD or Google")
+	force := fs.Bool("force", false, "Sync even when removals exceed the safety limits")
+	options := selectionFlags(fs)
+	fs.Parse(args)
+
+	opts, err := options()
+	if err != nil {
+		return err
+	}
+	opts.DryRun = *dryRun
+	opts.Force = *force
+
+	return a.sync(opts)
+}
+
+func runPlan(a *app, args []string) error {
+	fs := flag.NewFlagSet("plan", flag.ExitOnError)
+	out := fs.String("out", "", "Write the plan as JSON to this file for a later apply")
+	options := selectionFlags(fs)
+	fs.Parse(args)
+
+	opts, err := options()
+	if err != nil {
+		return err
+	}
+	opts.DryRun = true
+
+	cfg, client, users, err := a.loadDirectory()
+	if err != nil {
+		return err
+	}
+	defer client.Close()
+
+	st, err := state.Open(cfg.StatePath)
+	if err != nil {
+		return err
+	}
+
+	gs, err := connectGoogle(sync.NeedsGoogle(cfg, opts))
+	if err != nil {
+		return err
+	}
+
+	p, err := sync.BuildPlan(client, gs, cfg, st, users, opts)
+	if err != nil {
+		// A partial plan must not be applied as if it covered everything.
+		return err
+	}
+
+	printPlan(p)
+	if err := sync.CheckSafety(p, cfg.Safety); err != nil {
+		fmt.Printf("\nWARNING: apply will refuse this plan without --force: %v\n", err)
+	}
+	if *out != "" {
+		if err := p.Write(*out); err != nil {
+			return err
+		}
+		fmt.Printf("\nPlan written to %s. Apply it with: apply --plan %s\n", *out, *out)
+	}
+	return nil
+}
+
+func (a *app) sync(opts sync.Options) error {
+	cfg, client, users, err := a.loadDirectory()
+	if err != nil {
+		return err
+	}
+	defer client.Close()
+
+	st, err := state.Open(cfg.StatePath)
+	if err != nil {
+		return err
+	}
+
+	gs, err := connectGoogle(sync.NeedsGoogle(cfg, opts))
+	if err != nil {
+		return err
+	}
+
+	start := time.Now()
+	err = sync.RunAllGroupSyncs(client, gs, cfg, st, users, opts)
+	tools.Log.Infof("Finished syncing all groups in %s", time.Since(start))
+	return err
+}
+
+func runApply(a *app, args []string) error {
+	fs := flag.NewFlagSet("apply", flag.ExitOnError)
+	planPath := fs.String("plan", "", "Plan file written by plan --out (required)")
+	force := fs.Bool("force", false, "Apply even when removals exceed the safety limits")
+	fs.Parse(args)
+	if *planPath == "" {
+		return fmt.Errorf("usage: apply --plan <file.json>")
+	}
+
+	cfg, err := a.loadConfig()
+	if err != nil {
+		return fmt.Errorf("failed to load rules: %w", err)
+	}
+
+	p, err := plan.Read(*planPath)
+	if err != nil {
+		return err
+	}
+	st, err := state.Open(cfg.StatePath)
+	if err != nil {
+		return err
+	}
+	run := sync.StartRun("apply")
+	run.Forced = *force
+	if err := sync.CheckSafety(p, cfg.Safety); err != nil {
+		if !*force {
+			return errors.Join(err, sync.FinishRun(st, run, p, err))
+		}
+		tools.Log.Warnf("Applying despite safety limits (--force): %v", err)
+	}
+
+	client, err := ldapclient.Connect()
+	if err != nil {
+		return fmt.Errorf("failed to connect to LDAP: %w", err)
+	}
+	defer client.Close()
+
+	gs, err := connectGoogle(p.TouchesGoogle())
+	if err != nil {
+		return err
+	}
+
+	start := time.Now()
+	tools.Log.Infof("Applying plan %s from %s (%d groups with changes)", *planPath, p.CreatedAt.Format(time.RFC3339), len(p.Changes())+len(p.Orphans))
+	err = sync.ApplyPlan(client, gs, p, st, run, true)
+	tools.Log.Infof("Finished applying plan in %s", time.Since(start))
+	return errors.Join(err, sync.FinishRun(st, run, p, err))
+}
+
+func runAdopt(a *app, args []string) error {
+	fs := flag.NewFlagSet("adopt", flag.ExitOnError)
+	dryRun := fs.Bool("dry-run", false, "Log the groups that would be adopted without changing them")
+	options := selectionFlags(fs)
+	fs.Parse(args)
+
+	opts, err := options()
+	if err != nil {
+		return err
+	}
+	opts.DryRun = *dryRun
+
+	cfg, client, users, err := a.loadDirectory()
+	if err != nil {
+		return err
+	}
+	defer client.Close()
+
+	gs, err := connectGoogle(sync.NeedsGoogle(cfg, opts))
+	if err != nil {
+		return err
+	}
+
+	adopted, err := sync.AdoptGroups(client, gs, cfg, users, opts)
+	tools.Log.Infof("Adopted %d groups", adopted)
+	return err
+}
+
+func runListGroups(a *app, args []string) error {
+	fs := flag.NewFlagSet("list-groups", flag.ExitOnError)
+	only := fs.String("only", "", "Comma-separated rule IDs, group mails or CNs to include")
+	fs.Parse(args)
+
+	cfg, client, users, err := a.loadDirectory()
+	if err != nil {
+		return err
+	}
+	defer client.Close()
+
+	opts := sync.Options{Only: splitList(*only)}
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "RULE\tMAIL\tCN\tMEMBERS\tTARGETS")
+	for _, rule := range cfg.Rules {
+		groups, err := sync.ExpandRule(rule, users)
+		if err != nil {
+			return fmt.Errorf("rule %s: %w", rule.ID, err)
+		}
+		for _, g := range groups {
+			if opts.Selects(g) {
+				fmt.Fprintf(w, "%s\t%s\t%s\t%d\t%s\n", rule.ID, g.Names.Mail, g.Names.CN, len(g.Members), strings.Join(rule.Targets, ","))
+			}
+		}
+	}
+	return w.Flush()
+}
+
+func runShowGroup(a *app, args []string) error {
+	fs := flag.NewFlagSet("show-group", flag.ExitOnError)
+	fs.Parse(args)
+	if fs.NArg() != 1 {
+		return fmt.Errorf("usage: show-group <email>")
+	}
+	email := strings.ToLower(strings.TrimSpace(fs.Arg(0)))
+
+	cfg, client, users, err := a.loadDirectory()
+	if err != nil {
+		return err
+	}
+	defer client.Close()
+
+	// Find the rule that produces the group.
+	var target *sync.GroupTarget
+	for _, rule := range cfg.Rules {
+		groups, err := sync.ExpandRule(rule, users)
+		if err != nil {
+			return fmt.Errorf("rule %s: %w", rule.ID, err)
+		}
+		for i := range groups {
+			if groups[i].Names.Mail == email {
+				target = &groups[i]
+			}
+		}
+	}
+
+	desiredDNs := make(map[string]string) // normalized DN -> email
+	desiredMails := make(map[string]bool) // normalized emails
+	if target == nil {
+		fmt.Printf("%s is not produced by any rule\n", email)
+	} else {
+		fmt.Printf("Group:        %s\n", target.Names.Mail)
+		fmt.Printf("Rule:         %s\n", target.Rule.ID)
+		fmt.Printf("CN:           %s\n", target.Names.CN)
+		fmt.Printf("Display name: %s\n", target.Names.DisplayName)
+		fmt.Printf("Google name:  %s\n", target.Names.GoogleName)
+		fmt.Printf("Targets:      %s\n", strings.Join(target.Rule.Targets, ", "))
+		fmt.Printf("Members:      %d\n", len(target.Members))
+		for _, u := range target.Members {
+			desiredDNs[active_directory.NormalizeDN(u.DN)] = u.Email
+			desiredMails[strings.ToLower(strings.TrimSpace(u.Email))] = true
+		}
+		printList("Desired members", mapValues(desiredDNs))
+	}
+
+	// Live Active Directory state
+	adGroup, err := active_directory.GetGroupByEmail(client, email, os.Getenv("GROUP_OU"))
+	if err != nil {
+		fmt.Printf("\nAD: %v\n", err)
+	} else {
+		current := make(map[string]bool)
+		var missing, extra []string
+		for _, dn := range adGroup.Members {
+			current[active_directory.NormalizeDN(dn)] = true
+			if _, ok := desiredDNs[active_directory.NormalizeDN(dn)]; !ok {
+				extra = append(extra, dn)
+			}
+		}
+		for dn := range desiredDNs {
+			if !current[dn] {
+				missing = append(missing, dn)
+			}
+		}
+		fmt.Printf("\nAD: %s (%d members, objectGUID %s)\n", adGroup.DN, len(adGroup.Members), adGroup.ObjectGUID)
+		printList("Missing from AD", missing)
+		printList("Not expected in AD", extra)
+	}
+
+	// Live Google state, if the group's rule syncs there. A group no rule produces is looked up when any
+	// rule syncs to Google.
+	needGoogle := sync.NeedsGoogle(cfg, sync.Options{})
+	if target != nil {
+		needGoogle = target.Rule.HasTarget(config.TargetGoogle)
+	}
+	if !needGoogle {
+		return nil
+	}
+	gs, err := connectGoogle(true)
+	if err != nil {
+		return err
+	}
+	members, err := sync.ListGoogleGroupMembers(context.Background(), gs.Directory, email)
+	if err != nil {
+		fmt.Printf("\nGoogle: %v\n", err)
+		return nil
+	}
+
+	var missing, extra, managers []string
+	for m, role := range members {
+		if !desiredMails[m] {
+			extra = append(extra, m)
+		}
+		if role != "MEMBER" {
+			managers = append(managers, fmt.Sprintf("%s (%s)", m, role))
+		}
+	}
+	for m := range desiredMails {
+		if _, ok := members[m]; !ok && m != "" {
+			missing = append(missing, m)
+		}
+	}
+	fmt.Printf("\nGoogle: %d members\n", len(members))
+	printList("Owners/managers", managers)
+	printList("Missing from Google (includes users without a mailbox)", missing)
+	printList("Not expected in Google", extra)
+	return nil
+}
+
+func runExplainUser(a *app, args []string) error {
+	fs := flag.NewFlagSet("explain-user", flag.ExitOnError)
+	fs.Parse(args)
+	if fs.NArg() != 1 {
+		return fmt.Errorf("usage: explain-user <sam|email>")
+	}
+
+	cfg, client, users, err := a.loadDirectory()
+	if err != nil {
+		return err
+	}
+	defer client.Close()
+
+	user, ok := active_directory.FindUser(users, fs.Arg(0))
+	if !ok {
+		return fmt.Errorf("%s is not an enabled user with a mail address outside the excluded OUs", fs.Arg(0))
+	}
+
+	fmt.Printf("User:       %s (%s)\n", user.SAMAccountName, user.Email)
+	fmt.Printf("DN:         %s\n", user.DN)
+	fmt.Printf("Department: %s\n", user.Department)
+	fmt.Printf("Title:      %s\n", user.Title)
+	fmt.Printf("State:      %s\n", user.State)
+	fmt.Printf("Manager:    %s\n", user.ManagerDN)
+	fmt.Printf("Reports:    %d\n\n", len(user.DirectReports))
+
+	explanations, err := sync.ExplainUser(cfg, users, user)
+	if err != nil {
+		return err
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "RULE\tRESULT")
+	for _, exp := range explanations {
+		if len(exp.Groups) > 0 {
+			fmt.Fprintf(w, "%s\tmember of %s\n", exp.RuleID, strings.Join(exp.Groups, ", "))
+		} else {
+			fmt.Fprintf(w, "%s\texcluded: %s\n", exp.RuleID, exp.Reason)
+		}
+	}
+	return w.Flush()
+}
+
+func runStatus(a *app, args []string) error {
+	fs := flag.NewFlagSet("status", flag.ExitOnError)
+	history := fs.Int("history", 5, "Number of recent runs to list")
+	fs.Parse(args)
+
+	cfg, err := a.loadConfig()
+	if err != nil {
+		return fmt.Errorf("failed to load rules: %w", err)
+	}
+	st, err := state.Open(cfg.StatePath)
+	if err != nil {
+		return err
+	}
+
+	return printStatus(os.Stdout, st, *history)
+}
+
+// printStatus prints the last run, up to history recent runs and every managed group.
+func printStatus(out io.Writer, st *state.Store, history int) error {
+	// 1. Last run
+	last := st.LastRun()
+	if last == nil {
+		fmt.Fprintf(out, "No runs recorded in %s\n", st.Path())
+		return nil
+	}
+	fmt.Fprintf(out, "Last run: %s %s at %s (%s)\n", last.Command, last.Outcome, formatTime(last.StartedAt), last.FinishedAt.Sub(last.StartedAt).Round(time.Second))
+	fmt.Fprintf(out, "  Users: %d  Groups: %d (%d changed, %d failed)  Members: +%d -%d  Orphans: %d\n",
+		last.SourceUsers, last.Groups, last.Changed, last.Failed, last.Added, last.Removed, last.Orphans)
+	if last.Forced {
+		fmt.Fprintln(out, "  Safety limits overridden (--force)")
+	}
+	if last.Error != "" {
+		fmt.Fprintf(out, "  Error: %s\n", last.Error)
+	}
+
+	// 2. Recent runs, newest first
+	if history > 1 && len(st.Runs) > 1 {
+		fmt.Fprintln(out)
+		w := tabwriter.NewWriter(out, 0, 0, 2, ' ', 0)
+		fmt.Fprintln(w, "STARTED\tCOMMAND\tOUTCOME\tCHANGED\tFAILED\tMEMBERS")
+		for i := len(st.Runs) - 1; i >= 0 && i >= len(st.Runs)-history; i-- {
+			r := st.Runs[i]
+			fmt.Fprintf(w, "%s\t%s\t%s\t%d\t%d\t+%d -%d\n", formatTime(r.StartedAt), r.Command, r.Outcome, r.Changed, r.Failed, r.Added, r.Removed)
+		}
+		w.Flush()
+	}
+
+	// 3. Managed groups
+	fmt.Fprintln(out)
+	groups := make([]*state.Group, 0, len(st.Groups))
+	for _, g := range st.Groups {
+		groups = append(groups, g)
+	}
+	sort.Slice(groups, func(i, j int) bool {
+		if groups[i].RuleID != groups[j].RuleID {
+			return groups[i].RuleID < groups[j].RuleID
+		}
+		return groups[i].Mail < groups[j].Mail
+	})
+
+	w := tabwriter.NewWriter(out, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "RULE\tMAIL\tLAST SYNC\tTARGETS\tERROR")
+	for _, g := range groups {
+		var targets []string
+		if g.ADObjectGUID != "" {
+			targets = append(targets, config.TargetAD)
+		}
+		if g.GoogleID != "" {
+			targets = append(targets, config.TargetGoogle)
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\n", g.RuleID, g.Mail, formatTime(g.LastSyncAt), strings.Join(targets, ","), g.LastError)
+	}
+	return w.Flush()
+}
+
+// formatTime prints a recorded time in local time, or "never" for the zero time.
+func formatTime(t time.Time) string {
+	if t.IsZero() {
+		return "never"
+	}
+	return t.Local().Format("2006-01-02 15:04")
+}
+
+// printPlan prints one line per group with changes.
+func printPlan(p *plan.Plan) {
+	if !p.HasChanges() {
+		fmt.Printf("No changes. %d groups are up to date.\n", len(p.Groups))
+		return
+	}
+
+	changes := p.Changes()
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	if len(changes) > 0 {
+		fmt.Fprintln(w, "GROUP\tRULE\tAD\tGOOGLE")
+		for _, g := range changes {
+			fmt.Fprintf(w, "%s\t%s\t%s\t%s\n", g.Mail, g.RuleID, summarizeAD(g.AD), summarizeGoogle(g.Google))
+		}
+		fmt.Fprintln(w)
+	}
+	if len(p.Orphans) > 0 {
+		fmt.Fprintln(w, "ORPHANED GROUP\tTARGET\tSINCE\tACTION")
+		for _, o := range p.Orphans {
+			fmt.Fprintf(w, "%s\t%s\t%s\t%s\n", o.Mail, o.Target, o.OrphanedSince, summarizeOrphan(o))
+		}
+		fmt.Fprintln(w)
+	}
+	w.Flush()
+	fmt.Printf("%d of %d groups have changes, %d orphaned groups need action.\n", len(changes), len(p.Groups), len(p.Orphans))
+}
+
+func summarizeOrphan(o plan.OrphanPlan) string {
+	if o.Action == plan.OrphanDelete {
+		return fmt.Sprintf("delete (%d members)", o.MemberCount)
+	}
+	parts := []string{fmt.Sprintf("retire -%d", len(o.RemoveMembers))}
+	if o.Stamp {
+		parts = append(parts, "stamp")
+	}
+	if o.Hide {
+		parts = append(parts, "hide")
+	}
+	if o.MoveTo != "" {
+		parts = append(parts, "archive")
+	}
+	return strings.Join(parts, " ")
+}
+
+func summarizeAD(p *plan.ADPlan) string {
+	if p.Empty() {
+		return "-"
+	}
+	var parts []string
+	if p.Create != nil {
+		parts = append(parts, "create")
+	}
+	if p.Rename != "" {
+		parts = append(parts, "rename to "+p.Rename)
+	}
+	if p.MoveTo != "" {
+		parts = append(parts, "restore")
+	}
+	if len(p.SetAttributes) > 0 {
+		attrs := tools.MapKeys(p.SetAttributes)
+		sort.Strings(attrs)
+		parts = append(parts, "set "+strings.Join(attrs, ","))
+	}
+	return strings.Join(append(parts, fmt.Sprintf("+%d -%d", len(p.AddMembers), len(p.RemoveMembers))), " ")
+}
+
+func summarizeGoogle(p *plan.GooglePlan) string {
+	if p.Empty() {
+		return "-"
+	}
+	var parts []string
+	if p.Create != nil {
+		parts = append(parts, "create")
+	}
+	if p.RenameFrom != "" {
+		parts = append(parts, "rename from "+p.RenameFrom)
+	}
+	if p.Description != "" {
+		parts = append(parts, "restore")
+	}
+	parts = append(parts, fmt.Sprintf("+%d -%d", len(p.AddMembers), len(p.RemoveMembers)))
+	if len(p.UpdateRoles) > 0 {
+		parts = append(parts, fmt.Sprintf("~%d roles", len(p.UpdateRoles)))
+	}
+	if len(p.Settings) > 0 {
+		parts = append(parts, fmt.Sprintf("%d settings", len(p.Settings)))
+	}
+	return strings.Join(parts, " ")
+}
+
+func printList(title string, items []string) {
+	if len(items) == 0 {
+		return
+	}
+	sort.Strings(items)
+	fmt.Printf("  %s (%d):\n", title, len(items))
+	for _, item := range items {
+		fmt.Printf("    %s\n", item)
+	}
+}
+
+func mapValues(m map[string]string) []string {
+	values := make([]string, 0, len(m))
+	for _, v := range m {
+		values = append(values, v)
+	}
+	return values
+}
@@ -0,0 +1,109 @@
+package main
+
+import (
+	"bytes"
+	"flag"
+	"path/filepath"
+	"reflect"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/matthewdavidson09/dynamic-distro-groups/internal/config"
+	"github.com/matthewdavidson09/dynamic-distro-groups/internal/state"
+)
+
+func TestSelectionFlags(t *testing.T) {
+	tests := []struct {
+		args        []string
+		wantTargets []string
+		wantErr     string
+	}{
+		{args: nil},
+		{args: []string{"--targets", "ad"}, wantTargets: []string{config.TargetAD}},
+		{args: []string{"--targets", "AD, Google"}, wantTargets: []string{config.TargetAD, config.TargetGoogle}},
+		{args: []string{"--targets", "ad,exchange"}, wantErr: `unknown target "exchange"`},
+	}
+	for _, tt := range tests {
+		fs := flag.NewFlagSet("sync", flag.ContinueOnError)
+		options := selectionFlags(fs)
+		if err := fs.Parse(tt.args); err != nil {
+			t.Fatal(err)
+		}
+
+		opts, err := options()
+		if tt.wantErr != "" {
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("%q: err = %v, want error containing %q", tt.args, err, tt.wantErr)
+			}
+			continue
+		}
+		if err != nil || !reflect.DeepEqual(opts.Targets, tt.wantTargets) {
+			t.Errorf("%q: Targets = %q, %v; want %q", tt.args, opts.Targets, err, tt.wantTargets)
+		}
+	}
+}
+
+func TestPrintStatus(t *testing.T) {
+	st, err := state.Open(filepath.Join(t.TempDir(), "state.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var out bytes.Buffer
+	if err := printStatus(&out, st, 5); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(out.String(), "No runs recorded in ") {
+		t.Errorf("status of an empty store = %q", out.String())
+	}
+
+	at := time.Date(2025, 3, 1, 12, 0, 0, 0, time.UTC)
+	st.AddRun(state.Run{Command: "sync", StartedAt: at, FinishedAt: at.Add(time.Minute), Outcome: state.OutcomeSuccess, Added: 4})
+	st.AddRun(state.Run{
+		Command: "apply", StartedAt: at.Add(time.Hour), FinishedAt: at.Add(time.Hour + 90*time.Second), Outcome: state.OutcomeFailed,
+		Error: "1 of 3 groups failed", Forced: true, SourceUsers: 120, Groups: 3, Changed: 1, Failed: 1, Added: 2, Removed: 1, Orphans: 1,
+	})
+	st.Groups["states/TX"] = &state.Group{RuleID: "states", Mail: "list-state-tx@example.com", GoogleID: "03abc", LastError: "Google: 1 member changes failed"}
+	st.Groups["departments/Sales"] = &state.Group{RuleID: "departments", Mail: "list-dept-sales@example.com", ADObjectGUID: "0f3c", GoogleID: "03abd", LastSyncAt: at}
+
+	out.Reset()
+	if err := printStatus(&out, st, 5); err != nil {
+		t.Fatal(err)
+	}
+	lines := strings.Split(strings.TrimSpace(out.String()), "\n")
+	want := []string{
+		"Last run: apply failed at",
+		"Users: 120 Groups: 3 (1 changed, 1 failed) Members: +2 -1 Orphans: 1",
+		"Safety limits overridden (--force)",
+		"Error: 1 of 3 groups failed",
+		"",
+		"STARTED COMMAND OUTCOME CHANGED FAILED MEMBERS",
+		"apply failed 1 1 +2 -1",
+		"sync success 0 0 +4 -0",
+		"",
+		"RULE MAIL LAST SYNC TARGETS ERROR",
+		"departments list-dept-sales@example.com",
+		"states list-state-tx@example.com never google Google: 1 member changes failed",
+	}
+	if len(lines) != len(want) {
+		t.Fatalf("status printed %d lines, want %d:\n%s", len(lines), len(want), out.String())
+	}
+	for i, w := range want {
+		// Columns are padded and times are local, so words are compared without them.
+		got := strings.Join(strings.Fields(timestamp.ReplaceAllString(lines[i], "")), " ")
+		if !strings.HasPrefix(got, w) {
+			t.Errorf("line %d = %q, want it to start with %q", i, got, w)
+		}
+	}
+	if !strings.HasSuffix(lines[0], "(1m30s)") {
+		t.Errorf("last run line %q, want the duration 1m30s", lines[0])
+	}
+	if !strings.HasSuffix(strings.TrimSpace(lines[10]), "ad,google") {
+		t.Errorf("group line %q, want targets ad,google", lines[10])
+	}
+}
+
+// timestamp matches the times formatTime prints.
+var timestamp = regexp.MustCompile(`\d{4}-\d{2}-\d{2} \d{2}:\d{2}`)
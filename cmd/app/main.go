@@ -1,22 +1,53 @@
 package main
 
 import (
+	"context"
+	"errors"
 	"flag"
 	"fmt"
+	"io/fs"
 	"os"
-	"time"
+	"sort"
+	"strings"
 
 	"github.com/joho/godotenv"
 	"github.com/matthewdavidson09/dynamic-distro-groups/internal/active_directory"
+	"github.com/matthewdavidson09/dynamic-distro-groups/internal/config"
+	"github.com/matthewdavidson09/dynamic-distro-groups/internal/googleclient"
 	"github.com/matthewdavidson09/dynamic-distro-groups/internal/ldapclient"
-	"github.com/matthewdavidson09/dynamic-distro-groups/internal/sync"
 	"github.com/matthewdavidson09/dynamic-distro-groups/tools"
+	"github.com/sirupsen/logrus"
 )
 
-func main() {
-	var version = "development"
+var version = "development"
+
+// command is a CLI subcommand. run receives the arguments after the command name.
+type command struct {
+	summary string
+	run     func(a *app, args []string) error
+}
 
+var commands = map[string]command{
+	"sync":         {"Sync groups to AD and Google (default command)", runSync},
+	"plan":         {"Show what sync would change without writing anything; --out saves it", runPlan},
+	"apply":        {"Apply a saved plan, refusing if AD or Google changed since: apply --plan <file> [--force]", runApply},
+	"adopt":        {"Mark existing groups the rules produce as managed so sync may change them", runAdopt},
+	"list-groups":  {"List the groups the rules produce", runListGroups},
+	"show-group":   {"Show a group's desired and live membership: show-group <email>", runShowGroup},
+	"explain-user": {"Show which rules and groups a user falls into: explain-user <sam|email>", runExplainUser},
+	"status":       {"Show the last runs and when each managed group was last synced", runStatus},
+}
+
+// app holds the global options every command shares.
+type app struct {
+	rulesPath string
+}
+
+func main() {
 	flagVersion := flag.Bool("version", false, "Print version and exit")
+	flagRules := flag.String("rules", "", "Path to the rules file (default $RULES_FILE or groups.yaml)")
+	flagDebug := flag.Bool("debug", false, "Enable debug logging")
+	flag.Usage = usage
 	flag.Parse()
 
 	if *flagVersion {
@@ -29,30 +60,120 @@ func main() {
 		tools.Log.Fatalf("Failed to load .env file: %v", err)
 	}
 	tools.InitLogger()
+	if *flagDebug {
+		tools.Log.SetLevel(logrus.DebugLevel)
+	}
+
+	name, args := "sync", flag.Args()
+	if len(args) > 0 {
+		name, args = args[0], args[1:]
+	}
+
+	cmd, ok := commands[name]
+	if !ok {
+		fmt.Fprintf(os.Stderr, "Unknown command %q\n\n", name)
+		usage()
+		os.Exit(2)
+	}
+
+	a := &app{rulesPath: *flagRules}
+	if err := cmd.run(a, args); err != nil {
+		tools.Log.Errorf("%s failed: %v", name, err)
+		os.Exit(1)
+	}
+}
+
+func usage() {
+	fmt.Fprintf(os.Stderr, "Usage: %s [flags] <command> [command flags]\n\nCommands:\n", os.Args[0])
+
+	names := make([]string, 0, len(commands))
+	for name := range commands {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		fmt.Fprintf(os.Stderr, "  %-14s %s\n", name, commands[name].summary)
+	}
+
+	fmt.Fprintf(os.Stderr, "\nFlags:\n")
+	flag.PrintDefaults()
+}
 
-	dryRun := false // Set to true to skip modifying LDAP
+// loadConfig reads the rules file (-rules, else RULES_FILE, else groups.yaml), falling back to the
+// built-in rules when it does not exist. SYNC_TARGETS, if set, limits which rules run.
+func (a *app) loadConfig() (*config.Config, error) {
+	path := a.rulesPath
+	if path == "" {
+		path = os.Getenv("RULES_FILE")
+	}
+	if path == "" {
+		path = "groups.yaml"
+	}
+
+	cfg, err := config.Load(path)
+	if errors.Is(err, fs.ErrNotExist) && a.rulesPath == "" {
+		tools.Log.Warnf("Rules file %s not found, using built-in rules", path)
+		cfg, err = config.Default(), nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if targets := os.Getenv("SYNC_TARGETS"); targets != "" {
+		cfg.FilterRules(strings.Split(targets, ","))
+	}
+	return cfg, nil
+}
+
+// loadDirectory loads the rules, connects to LDAP and fetches every eligible user once.
+// The caller must close the returned client.
+func (a *app) loadDirectory() (*config.Config, *ldapclient.LDAPClient, []active_directory.ADUser, error) {
+	cfg, err := a.loadConfig()
+	if err != nil {
+		return nil, nil, nil, fmt.Errorf("failed to load rules: %w", err)
+	}
 
-	// Connect to LDAP
 	client, err := ldapclient.Connect()
 	if err != nil {
-		tools.Log.Fatalf("Failed to connect to LDAP: %v", err)
+		return nil, nil, nil, fmt.Errorf("failed to connect to LDAP: %w", err)
 	}
-	defer client.Close()
 
-	// Load all eligible users once
-	allUsers, err := active_directory.GetUsersByFilter(
+	users, err := active_directory.GetUsersByFilter(
 		client,
 		nil,  // No custom filter map
 		true, // Only enabled users
 		true, // Require mail attribute
 		[]string{"OU=External Users", "OU=Archived Users"}, // Excluded OUs
+		cfg.Attributes(), // Attributes referenced by rules
 	)
 	if err != nil {
-		tools.Log.Fatalf("Failed to fetch users: %v", err)
+		client.Close()
+		return nil, nil, nil, fmt.Errorf("failed to fetch users: %w", err)
+	}
+
+	return cfg, client, users, nil
+}
+
+// connectGoogle creates the Google clients a run shares, or returns nil when need is false so runs that
+// only touch AD need no Google credentials.
+func connectGoogle(need bool) (*googleclient.Clients, error) {
+	if !need {
+		return nil, nil
+	}
+	gs, err := googleclient.New(context.Background())
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect to Google: %w", err)
 	}
+	return gs, nil
+}
 
-	// Sync by department
-	start := time.Now()
-	sync.RunAllGroupSyncs(client, allUsers, dryRun)
-	tools.Log.Infof("Finished syncing all groups in %s", time.Since(start))
+// splitList splits a comma-separated flag value, dropping empty entries.
+func splitList(s string) []string {
+	var out []string
+	for _, part := range strings.Split(s, ",") {
+		if part = strings.TrimSpace(part); part != "" {
+			out = append(out, part)
+		}
+	}
+	return out
 }
@@ -4,59 +4,45 @@ import (
 	"errors"
 	"fmt"
 
-	"github.com/go-ldap/ldap/v3"
 	"github.com/matthewdavidson09/dynamic-distro-groups/internal/ldapclient"
+	"github.com/matthewdavidson09/dynamic-distro-groups/internal/plan"
 	"github.com/matthewdavidson09/dynamic-distro-groups/tools"
 )
 
 var ErrGroupNotFound = errors.New("group not found")
 
-func EnsureGroupExists(client *ldapclient.LDAPClient, cn, email, ou, label string) (*ADGroup, error) {
-	tools.Log.WithFields(map[string]interface{}{
-		"cn":    cn,
-		"email": email,
-	}).Debug("Ensuring group exists")
+// ErrNotManaged is returned for a group that matches a rule but lacks that rule's ownership marker.
+var ErrNotManaged = errors.New("group is not managed by this rule")
 
+// CheckOwner returns an error wrapping ErrNotManaged unless the group carries the ownership marker of ruleID.
+func CheckOwner(group *ADGroup, ruleID string) error {
+	owner, ok := plan.ParseOwnerMarker(group.AdminDescription)
+	if !ok {
+		return fmt.Errorf("%s has no ownership marker, run adopt to take it over: %w", group.DN, ErrNotManaged)
+	}
+	if owner != ruleID {
+		return fmt.Errorf("%s is owned by rule %q, run adopt to move it to %q: %w", group.DN, owner, ruleID, ErrNotManaged)
+	}
+	return nil
+}
+
+// FindGroup looks the group up in ou by mail, then by CN. It returns ErrGroupNotFound when neither matches.
+func FindGroup(client *ldapclient.LDAPClient, id GroupIdentity, ou string) (*ADGroup, error) {
 	// 1. Try to fetch by email
-	group, err := GetGroupByEmail(client, email, ou)
+	group, err := GetGroupByEmail(client, id.Email, ou)
 	if err == nil {
-		tools.Log.WithField("cn", cn).Debug("Group found by email")
+		tools.Log.WithField("cn", id.CN).Debug("Group found by email")
 		return group, nil
 	}
+	if !errors.Is(err, ErrGroupNotFound) {
+		return nil, err
+	}
 
 	// 2. If not found, try by CN
-	group, errCN := GetGroupByCN(client, cn, ou)
-	if errCN == nil {
-		tools.Log.WithField("cn", cn).Debug("Group found by CN (mail may be missing)")
-		if mailErr := EnsureGroupMailAttribute(client, group.DN, email); mailErr != nil {
-			tools.Log.WithFields(map[string]interface{}{
-				"cn":    cn,
-				"error": mailErr,
-			}).Warn("Failed to update mail attribute")
-		}
+	group, err = GetGroupByCN(client, id.CN, ou)
+	if err == nil {
+		tools.Log.WithField("cn", id.CN).Debug("Group found by CN (mail may be missing)")
 		return group, nil
 	}
-
-	// 3. Otherwise, create it
-	tools.Log.WithFields(map[string]interface{}{
-		"cn":    cn,
-		"email": email,
-	}).Info("Group not found, creating new group")
-
-	if err := CreateGroup(client, cn, email, ou, label); err != nil {
-		if ldapErr, ok := err.(*ldap.Error); ok && ldapErr.ResultCode == ldap.LDAPResultEntryAlreadyExists {
-			tools.Log.WithField("cn", cn).Warn("Group already created by another process. Retrying fetch...")
-		} else {
-			return nil, fmt.Errorf("failed to create group: %w", err)
-		}
-	}
-
-	// 4. Fetch again
-	group, err = GetGroupByEmail(client, email, ou)
-	if err != nil {
-		return nil, fmt.Errorf("group created but cannot be fetched: %w", err)
-	}
-
-	tools.Log.WithField("cn", cn).Info("Group creation confirmed")
-	return group, nil
+	return nil, err
 }
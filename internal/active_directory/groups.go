@@ -2,98 +2,191 @@ package active_directory
 
 import (
 	"fmt"
+	"sort"
+	"strings"
 
 	"github.com/go-ldap/ldap/v3"
 	"github.com/matthewdavidson09/dynamic-distro-groups/internal/ldapclient"
+	"github.com/matthewdavidson09/dynamic-distro-groups/internal/plan"
 	"github.com/matthewdavidson09/dynamic-distro-groups/tools"
 )
 
+// GroupIdentity is the naming of a managed group, rendered from its rule.
+type GroupIdentity struct {
+	CN             string
+	SAMAccountName string
+	Email          string
+	DisplayName    string
+	Description    string
+	Owner          string // ID of the rule that owns the group, recorded as its ownership marker
+	ObjectGUID     string // GUID recorded for the group by an earlier run, if any
+}
+
 type ADGroup struct {
-	CN         string
-	DN         string
-	Email      string
-	Members    []string
-	ObjectGUID string
+	CN             string
+	SAMAccountName string
+	DN             string
+	Email          string
+	DisplayName    string
+	Description    string
+	Info           string // notes; holds the orphan stamp of retired groups
+	// AdminDescription holds the ownership marker of managed groups.
+	AdminDescription string
+	Hidden           bool // hidden from Exchange address lists
+	Members          []string
+	ObjectGUID       string
 }
 
+// HideAttribute hides a group from Exchange address lists.
+const HideAttribute = "msExchHideFromAddressLists"
+
+var groupAttributes = []string{"cn", "sAMAccountName", "distinguishedName", "mail", "displayName", "description", "info", "adminDescription", HideAttribute, "member", "objectGUID"}
+
 func GetGroupByEmail(client *ldapclient.LDAPClient, email, baseDN string) (*ADGroup, error) {
 	filter := fmt.Sprintf("(mail=%s)", ldap.EscapeFilter(email))
-	attributes := []string{"cn", "distinguishedName", "mail", "member", "objectGUID"}
+	group, err := searchGroup(client, baseDN, ldap.ScopeSingleLevel, filter)
+	if err == ErrGroupNotFound {
+		return nil, fmt.Errorf("group not found with email: %s: %w", email, err)
+	}
+	return group, err
+}
+
+func GetGroupByCN(client *ldapclient.LDAPClient, cn, baseDN string) (*ADGroup, error) {
+	filter := fmt.Sprintf("(cn=%s)", ldap.EscapeFilter(cn))
+	group, err := searchGroup(client, baseDN, ldap.ScopeSingleLevel, filter)
+	if err == ErrGroupNotFound {
+		return nil, fmt.Errorf("group not found with CN: %s: %w", cn, err)
+	}
+	return group, err
+}
+
+// GetGroupByGUID finds the group with the given objectGUID (as formatted by tools.FormatGUID) in baseDN.
+func GetGroupByGUID(client *ldapclient.LDAPClient, guid, baseDN string) (*ADGroup, error) {
+	raw, err := tools.ParseGUID(guid)
+	if err != nil {
+		return nil, err
+	}
+
+	var escaped strings.Builder
+	for _, b := range raw {
+		fmt.Fprintf(&escaped, "\\%02x", b)
+	}
+
+	group, err := searchGroup(client, baseDN, ldap.ScopeSingleLevel, fmt.Sprintf("(objectGUID=%s)", escaped.String()))
+	if err == ErrGroupNotFound {
+		return nil, fmt.Errorf("group not found with objectGUID: %s: %w", guid, err)
+	}
+	return group, err
+}
 
+// GetGroupByDN reads the group at dn.
+func GetGroupByDN(client *ldapclient.LDAPClient, dn string) (*ADGroup, error) {
+	group, err := searchGroup(client, dn, ldap.ScopeBaseObject, "(objectClass=group)")
+	if err != nil {
+		if ldap.IsErrorWithCode(err, ldap.LDAPResultNoSuchObject) {
+			err = ErrGroupNotFound
+		}
+		return nil, fmt.Errorf("group not found at DN: %s: %w", dn, err)
+	}
+	return group, nil
+}
+
+// searchGroup returns the first group matching filter under baseDN, or ErrGroupNotFound.
+func searchGroup(client *ldapclient.LDAPClient, baseDN string, scope int, filter string) (*ADGroup, error) {
 	searchReq := ldap.NewSearchRequest(
 		baseDN,
-		ldap.ScopeSingleLevel,
+		scope,
 		ldap.NeverDerefAliases,
 		1, 0, false,
 		filter,
-		attributes,
+		groupAttributes,
 		nil,
 	)
 
-	result, err := client.Conn.Search(searchReq)
+	result, err := client.Search(searchReq)
 	if err != nil {
 		return nil, fmt.Errorf("LDAP search error: %w", err)
 	}
 	if len(result.Entries) == 0 {
-		return nil, fmt.Errorf("group not found with email: %s", email)
+		return nil, ErrGroupNotFound
 	}
 
-	entry := result.Entries[0]
-	return &ADGroup{
-		CN:         entry.GetAttributeValue("cn"),
-		DN:         entry.DN,
-		Email:      entry.GetAttributeValue("mail"),
-		Members:    entry.GetAttributeValues("member"),
-		ObjectGUID: tools.FormatGUID(entry.GetRawAttributeValue("objectGUID")),
-	}, nil
+	group, err := groupFromEntry(client, result.Entries[0])
+	if err != nil {
+		return nil, err
+	}
+	return &group, nil
 }
 
-func GetGroupByCN(client *ldapclient.LDAPClient, cn, baseDN string) (*ADGroup, error) {
-	filter := fmt.Sprintf("(cn=%s)", ldap.EscapeFilter(cn))
-	attributes := []string{"cn", "distinguishedName", "mail", "member", "objectGUID"}
-
+// ListGroups returns every group directly in ou.
+func ListGroups(client *ldapclient.LDAPClient, ou string) ([]ADGroup, error) {
 	searchReq := ldap.NewSearchRequest(
-		baseDN,
+		ou,
 		ldap.ScopeSingleLevel,
 		ldap.NeverDerefAliases,
-		1, 0, false,
-		filter,
-		attributes,
+		0, 0, false,
+		"(objectClass=group)",
+		groupAttributes,
 		nil,
 	)
 
-	result, err := client.Conn.Search(searchReq)
+	result, err := client.SearchPaged(searchReq)
 	if err != nil {
-		return nil, fmt.Errorf("LDAP search error: %w", err)
+		return nil, fmt.Errorf("failed to list groups in %s: %w", ou, err)
 	}
-	if len(result.Entries) == 0 {
-		return nil, fmt.Errorf("group not found with CN: %s", cn)
+
+	groups := make([]ADGroup, 0, len(result.Entries))
+	for _, entry := range result.Entries {
+		group, err := groupFromEntry(client, entry)
+		if err != nil {
+			return nil, err
+		}
+		groups = append(groups, group)
 	}
+	return groups, nil
+}
 
-	entry := result.Entries[0]
-	return &ADGroup{
-		CN:         entry.GetAttributeValue("cn"),
-		DN:         entry.DN,
-		Email:      entry.GetAttributeValue("mail"),
-		Members:    entry.GetAttributeValues("member"),
-		ObjectGUID: tools.FormatGUID(entry.GetRawAttributeValue("objectGUID")),
+// groupFromEntry maps a group entry, reading the rest of its members if AD returned only the first range.
+func groupFromEntry(client *ldapclient.LDAPClient, entry *ldap.Entry) (ADGroup, error) {
+	members, err := rangedValues(client, entry, "member")
+	if err != nil {
+		return ADGroup{}, err
+	}
+
+	return ADGroup{
+		CN:               entry.GetAttributeValue("cn"),
+		SAMAccountName:   entry.GetAttributeValue("sAMAccountName"),
+		DN:               entry.DN,
+		Email:            entry.GetAttributeValue("mail"),
+		DisplayName:      entry.GetAttributeValue("displayName"),
+		Description:      entry.GetAttributeValue("description"),
+		Info:             entry.GetAttributeValue("info"),
+		AdminDescription: entry.GetAttributeValue("adminDescription"),
+		Hidden:           strings.EqualFold(entry.GetAttributeValue(HideAttribute), "TRUE"),
+		Members:          members,
+		ObjectGUID:       tools.FormatGUID(entry.GetRawAttributeValue("objectGUID")),
 	}, nil
 }
 
-func CreateGroup(client *ldapclient.LDAPClient, cn, email, ou, dept string) error {
-	groupDN := fmt.Sprintf("CN=%s,%s", cn, ou)
-	label := fmt.Sprintf("All %s Employees", dept)
+// GroupDN returns the DN of a group with the given CN in ou.
+func GroupDN(cn, ou string) string {
+	return fmt.Sprintf("CN=%s,%s", ldap.EscapeDN(cn), ou)
+}
 
+func CreateGroup(client *ldapclient.LDAPClient, groupDN string, id GroupIdentity) error {
 	addReq := ldap.NewAddRequest(groupDN, nil)
 	addReq.Attribute("objectClass", []string{"top", "group"})
-	addReq.Attribute("cn", []string{cn})
-	addReq.Attribute("sAMAccountName", []string{cn})
-	addReq.Attribute("mail", []string{email})
-	addReq.Attribute("displayName", []string{label})
-	addReq.Attribute("description", []string{label + " distro group"})
+	addReq.Attribute("cn", []string{id.CN})
+	addReq.Attribute("sAMAccountName", []string{id.SAMAccountName})
+	addReq.Attribute("mail", []string{id.Email})
+	addReq.Attribute("displayName", []string{id.DisplayName})
+	addReq.Attribute("description", []string{id.Description})
 	addReq.Attribute("groupType", []string{fmt.Sprint(0x00000008)})
+	if id.Owner != "" {
+		addReq.Attribute("adminDescription", []string{plan.OwnerMarker(id.Owner)})
+	}
 
-	err := client.Conn.Add(addReq)
+	err := client.Add(addReq)
 	if err != nil {
 		tools.Log.WithFields(map[string]interface{}{
 			"dn":    groupDN,
@@ -102,53 +195,89 @@ func CreateGroup(client *ldapclient.LDAPClient, cn, email, ou, dept string) erro
 		return fmt.Errorf("failed to create group: %w", err)
 	}
 
-	tools.Log.WithField("cn", cn).Info("Group created successfully")
+	tools.Log.WithField("cn", id.CN).Info("Group created successfully")
 	return nil
 }
 
-func EnsureGroupMailAttribute(client *ldapclient.LDAPClient, groupDN, expectedEmail string) error {
-	searchReq := ldap.NewSearchRequest(
-		groupDN,
-		ldap.ScopeBaseObject,
-		ldap.NeverDerefAliases,
-		1, 0, false,
-		"(objectClass=group)",
-		[]string{"mail"},
-		nil,
-	)
+// SetGroupAttributes replaces single-valued attributes on a group. An empty value clears the attribute.
+func SetGroupAttributes(client *ldapclient.LDAPClient, groupDN string, attrs map[string]string) error {
+	if len(attrs) == 0 {
+		return nil
+	}
 
-	result, err := client.Conn.Search(searchReq)
-	if err != nil {
-		return fmt.Errorf("failed to search for group mail attribute: %w", err)
+	names := make([]string, 0, len(attrs))
+	for name := range attrs {
+		names = append(names, name)
 	}
-	if len(result.Entries) == 0 {
-		return fmt.Errorf("group not found at DN: %s", groupDN)
+	sort.Strings(names)
+
+	modReq := ldap.NewModifyRequest(groupDN, nil)
+	for _, name := range names {
+		if attrs[name] == "" {
+			modReq.Replace(name, []string{})
+		} else {
+			modReq.Replace(name, []string{attrs[name]})
+		}
 	}
 
-	currentMail := result.Entries[0].GetAttributeValue("mail")
-	if currentMail == expectedEmail {
-		tools.Log.WithField("dn", groupDN).Debug("Mail attribute already correct")
-		return nil
+	if err := client.Modify(modReq); err != nil {
+		return fmt.Errorf("failed to update attributes %v on %s: %w", names, groupDN, err)
 	}
 
-	modReq := ldap.NewModifyRequest(groupDN, nil)
-	if currentMail != "" {
-		modReq.Replace("mail", []string{expectedEmail})
-		tools.Log.WithFields(map[string]interface{}{
-			"dn":    groupDN,
-			"email": expectedEmail,
-		}).Info("Replacing existing mail attribute")
-	} else {
-		modReq.Add("mail", []string{expectedEmail})
-		tools.Log.WithFields(map[string]interface{}{
-			"dn":    groupDN,
-			"email": expectedEmail,
-		}).Info("Adding mail attribute")
+	tools.Log.WithFields(map[string]interface{}{
+		"dn":    groupDN,
+		"attrs": attrs,
+	}).Info("Updated group attributes")
+	return nil
+}
+
+// MoveGroup moves a group into ou, keeping its CN, and returns its new DN.
+func MoveGroup(client *ldapclient.LDAPClient, groupDN, ou string) (string, error) {
+	dn, err := ldap.ParseDN(groupDN)
+	if err != nil || len(dn.RDNs) == 0 {
+		return "", fmt.Errorf("invalid group DN %s: %v", groupDN, err)
 	}
+	rdn := "CN=" + ldap.EscapeDN(dn.RDNs[0].Attributes[0].Value)
 
-	if err := client.Conn.Modify(modReq); err != nil {
-		return fmt.Errorf("failed to update mail attribute: %w", err)
+	modReq := ldap.NewModifyDNRequest(groupDN, rdn, true, ou)
+	if err := client.ModifyDN(modReq); err != nil {
+		return "", fmt.Errorf("failed to move %s to %s: %w", groupDN, ou, err)
 	}
 
+	newDN := rdn + "," + ou
+	tools.Log.WithFields(map[string]interface{}{
+		"from": groupDN,
+		"to":   newDN,
+	}).Info("Moved group")
+	return newDN, nil
+}
+
+// RenameGroup changes a group's CN in place and returns its new DN.
+func RenameGroup(client *ldapclient.LDAPClient, groupDN, cn string) (string, error) {
+	dn, err := ldap.ParseDN(groupDN)
+	if err != nil || len(dn.RDNs) < 2 {
+		return "", fmt.Errorf("invalid group DN %s: %v", groupDN, err)
+	}
+	rdn := "CN=" + ldap.EscapeDN(cn)
+
+	modReq := ldap.NewModifyDNRequest(groupDN, rdn, true, "")
+	if err := client.ModifyDN(modReq); err != nil {
+		return "", fmt.Errorf("failed to rename %s to %s: %w", groupDN, cn, err)
+	}
+
+	newDN := rdn + "," + (&ldap.DN{RDNs: dn.RDNs[1:]}).String()
+	tools.Log.WithFields(map[string]interface{}{
+		"from": groupDN,
+		"to":   newDN,
+	}).Info("Renamed group")
+	return newDN, nil
+}
+
+// DeleteGroup deletes a group.
+func DeleteGroup(client *ldapclient.LDAPClient, groupDN string) error {
+	if err := client.Del(ldap.NewDelRequest(groupDN, nil)); err != nil {
+		return fmt.Errorf("failed to delete group %s: %w", groupDN, err)
+	}
+	tools.Log.WithField("dn", groupDN).Info("Deleted group")
 	return nil
 }
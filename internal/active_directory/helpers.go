@@ -1,8 +1,10 @@
 package active_directory
 
 import (
+	"fmt"
 	"slices"
 	"strings"
+	"sync"
 
 	"golang.org/x/text/cases"
 	"golang.org/x/text/language"
@@ -14,29 +16,67 @@ func NormalizeDN(dn string) string {
 	return strings.ToLower(strings.TrimSpace(dn))
 }
 
+// NormalizeSteps are the value normalizations a rule can apply, in order, before grouping.
+var NormalizeSteps = []string{"trim", "lower", "upper", "title", "collapse-spaces"}
+
+// NewNormalizer builds a function applying the named normalization steps in order.
+func NewNormalizer(steps []string) (func(string) string, error) {
+	var fns []func(string) string
+	for _, step := range steps {
+		switch strings.ToLower(strings.TrimSpace(step)) {
+		case "trim":
+			fns = append(fns, strings.TrimSpace)
+		case "lower":
+			fns = append(fns, strings.ToLower)
+		case "upper":
+			fns = append(fns, strings.ToUpper)
+		case "title":
+			caser := cases.Title(language.English)
+			var mu sync.Mutex
+			fns = append(fns, func(s string) string {
+				mu.Lock()
+				defer mu.Unlock()
+				return caser.String(strings.ToLower(s))
+			})
+		case "collapse-spaces":
+			fns = append(fns, func(s string) string { return strings.Join(strings.Fields(s), " ") })
+		default:
+			return nil, fmt.Errorf("unknown normalization %q (valid: %s)", step, strings.Join(NormalizeSteps, ", "))
+		}
+	}
+
+	return func(s string) string {
+		for _, fn := range fns {
+			s = fn(s)
+		}
+		return s
+	}, nil
+}
+
 // ─── Grouping Utilities ───
 
-func GetUniqueStates(users []ADUser) []string {
-	stateMap := make(map[string]struct{})
+// UniqueAttributeValues returns the sorted, distinct normalized values of an attribute.
+func UniqueAttributeValues(users []ADUser, attr string, normalize func(string) string) []string {
+	values := make(map[string]struct{})
 	for _, user := range users {
-		state := strings.ToUpper(strings.TrimSpace(user.State))
-		if state != "" {
-			stateMap[state] = struct{}{}
+		if value := normalize(user.Attribute(attr)); value != "" {
+			values[value] = struct{}{}
 		}
 	}
-	return mapKeysSorted(stateMap)
+	return mapKeysSorted(values)
 }
 
-func GetUniqueDepartments(users []ADUser) []string {
-	caser := cases.Title(language.English)
-	deptMap := make(map[string]struct{})
+// GroupUsersByAttribute buckets users by the normalized value of an attribute.
+// Users with an empty value are left out.
+func GroupUsersByAttribute(users []ADUser, attr string, normalize func(string) string) map[string][]ADUser {
+	groups := make(map[string][]ADUser)
 	for _, user := range users {
-		dept := caser.String(strings.ToLower(strings.TrimSpace(user.Department)))
-		if dept != "" {
-			deptMap[dept] = struct{}{}
+		value := normalize(user.Attribute(attr))
+		if value != "" {
+			groups[value] = append(groups[value], user)
 		}
 	}
-	return mapKeysSorted(deptMap)
+	return groups
 }
 
 func GroupUsersByManager(users []ADUser) map[string][]ADUser {
@@ -49,6 +89,38 @@ func GroupUsersByManager(users []ADUser) map[string][]ADUser {
 	return managerMap
 }
 
+// FindUser returns the user whose sAMAccountName, mail or DN equals id (case-insensitive).
+func FindUser(users []ADUser, id string) (ADUser, bool) {
+	id = strings.TrimSpace(id)
+	for _, u := range users {
+		if strings.EqualFold(u.SAMAccountName, id) || strings.EqualFold(u.Email, id) || strings.EqualFold(u.DN, id) {
+			return u, true
+		}
+	}
+	return ADUser{}, false
+}
+
+// UserIndex looks users up by normalized DN.
+type UserIndex map[string]ADUser
+
+// NewUserIndex indexes users by DN.
+func NewUserIndex(users []ADUser) UserIndex {
+	idx := make(UserIndex, len(users))
+	for _, u := range users {
+		idx[NormalizeDN(u.DN)] = u
+	}
+	return idx
+}
+
+// Manager returns the user's manager if they are in the index.
+func (idx UserIndex) Manager(u ADUser) (ADUser, bool) {
+	if u.ManagerDN == "" {
+		return ADUser{}, false
+	}
+	mgr, ok := idx[NormalizeDN(u.ManagerDN)]
+	return mgr, ok
+}
+
 func mapKeysSorted(m map[string]struct{}) []string {
 	var keys []string
 	for k := range m {
@@ -0,0 +1,80 @@
+package active_directory
+
+import "testing"
+
+func TestNewNormalizer(t *testing.T) {
+	tests := []struct {
+		steps []string
+		in    string
+		want  string
+	}{
+		{nil, "  Sales ", "  Sales "},
+		{[]string{"trim"}, "  Sales ", "Sales"},
+		{[]string{"trim", "title"}, " customer SUCCESS ", "Customer Success"},
+		{[]string{"trim", "upper"}, " tx", "TX"},
+		{[]string{"lower", "collapse-spaces"}, "New   York  City", "new york city"},
+		{[]string{" Trim ", "LOWER"}, " HR ", "hr"},
+	}
+	for _, tt := range tests {
+		normalize, err := NewNormalizer(tt.steps)
+		if err != nil {
+			t.Fatalf("NewNormalizer(%q) = %v", tt.steps, err)
+		}
+		if got := normalize(tt.in); got != tt.want {
+			t.Errorf("NewNormalizer(%q)(%q) = %q, want %q", tt.steps, tt.in, got, tt.want)
+		}
+	}
+
+	if _, err := NewNormalizer([]string{"trim", "reverse"}); err == nil {
+		t.Error("NewNormalizer accepted unknown step \"reverse\"")
+	}
+}
+
+func TestAttribute(t *testing.T) {
+	u := ADUser{
+		State:      "TX",
+		Department: "Sales",
+		Attributes: map[string][]string{
+			"physicaldeliveryofficename": {"Austin"},
+			"extensionattribute1":        {"contractor", "remote"},
+		},
+	}
+
+	for name, want := range map[string]string{
+		"st":                  "TX",
+		"State":               "TX",
+		"department":          "Sales",
+		"office":              "Austin",
+		"extensionAttribute1": "contractor",
+		"missing":             "",
+	} {
+		if got := u.Attribute(name); got != want {
+			t.Errorf("Attribute(%q) = %q, want %q", name, got, want)
+		}
+	}
+
+	if got := u.AttributeValues("extensionAttribute1"); len(got) != 2 {
+		t.Errorf("AttributeValues(extensionAttribute1) = %q, want both values", got)
+	}
+	if got := u.AttributeValues("title"); got != nil {
+		t.Errorf("AttributeValues(title) = %q, want nil for an empty field", got)
+	}
+}
+
+func TestGroupUsersByAttribute(t *testing.T) {
+	users := []ADUser{
+		{SAMAccountName: "a", Department: "sales "},
+		{SAMAccountName: "b", Department: "Sales"},
+		{SAMAccountName: "c", Department: "Engineering"},
+		{SAMAccountName: "d"},
+	}
+	normalize, err := NewNormalizer([]string{"trim", "title"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	groups := GroupUsersByAttribute(users, "department", normalize)
+	if len(groups) != 2 || len(groups["Sales"]) != 2 || len(groups["Engineering"]) != 1 {
+		t.Errorf("GroupUsersByAttribute = %v, want Sales (2) and Engineering (1), users without a value skipped", groups)
+	}
+}
@@ -0,0 +1,145 @@
+package active_directory
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/go-ldap/ldap/v3"
+	"github.com/matthewdavidson09/dynamic-distro-groups/internal/ldapclient"
+	"github.com/matthewdavidson09/dynamic-distro-groups/tools"
+)
+
+// defaultMemberBatch is how many member values one Modify request changes unless AD_MEMBER_BATCH_SIZE
+// says otherwise.
+const defaultMemberBatch = 500
+
+// AddMembers adds users (by DN) to a group, many per Modify request, and returns how many were added and
+// the errors of those that were not.
+func AddMembers(client *ldapclient.LDAPClient, groupDN string, userDNs []string) (int, []error) {
+	return modifyMembers(client, groupDN, userDNs, true)
+}
+
+// RemoveMembers removes users (by DN) from a group, many per Modify request, and returns how many were
+// removed and the errors of those that were not.
+func RemoveMembers(client *ldapclient.LDAPClient, groupDN string, userDNs []string) (int, []error) {
+	return modifyMembers(client, groupDN, userDNs, false)
+}
+
+// memberModifier is the part of the LDAP client that changes members.
+type memberModifier interface {
+	Modify(req *ldap.ModifyRequest) error
+}
+
+// modifyMembers changes the member attribute in batches. A Modify is atomic, so one stale DN fails its
+// whole batch; such a batch is retried one value at a time so only the bad DN fails. A batch that failed
+// after a dropped connection made it run again may have been applied by the first attempt, so values
+// then found already added or removed count as changed.
+func modifyMembers(client memberModifier, groupDN string, userDNs []string, add bool) (int, []error) {
+	size := memberBatchSize()
+	done := 0
+	var errs []error
+
+	for start := 0; start < len(userDNs); start += size {
+		batch := userDNs[start:min(start+size, len(userDNs))]
+
+		// 1. The whole batch in one request
+		modReq := ldap.NewModifyRequest(groupDN, nil)
+		if add {
+			modReq.Add("member", batch)
+		} else {
+			modReq.Delete("member", batch)
+		}
+		err := client.Modify(modReq)
+		if err == nil {
+			done += len(batch)
+			tools.Log.WithFields(map[string]interface{}{
+				"group": groupDN,
+				"add":   add,
+				"count": len(batch),
+			}).Debug("Changed members")
+			continue
+		}
+		retried := errors.Is(err, ldapclient.ErrRetried)
+		if len(batch) == 1 && !retried {
+			errs = append(errs, memberError(groupDN, batch[0], add, err))
+			continue
+		}
+
+		// 2. One value at a time
+		tools.Log.WithFields(map[string]interface{}{
+			"group":   groupDN,
+			"count":   len(batch),
+			"retried": retried,
+			"error":   err,
+		}).Warn("Member batch failed, retrying one member at a time")
+		for _, dn := range batch {
+			err := modifyMember(client, groupDN, dn, add)
+			if err != nil && retried && memberApplied(err, add) {
+				err = nil
+			}
+			if err != nil {
+				errs = append(errs, err)
+				continue
+			}
+			done++
+		}
+	}
+	return done, errs
+}
+
+// memberApplied reports whether a member change failed only because the member was already added or
+// removed.
+func memberApplied(err error, add bool) bool {
+	if add {
+		return ldap.IsErrorWithCode(err, ldap.LDAPResultAttributeOrValueExists)
+	}
+	return ldap.IsErrorWithCode(err, ldap.LDAPResultNoSuchAttribute)
+}
+
+func memberError(groupDN, userDN string, add bool, err error) error {
+	if add {
+		return fmt.Errorf("failed to add user %s to group %s: %w", userDN, groupDN, err)
+	}
+	return fmt.Errorf("failed to remove user %s from group %s: %w", userDN, groupDN, err)
+}
+
+// memberBatchSize reads AD_MEMBER_BATCH_SIZE, falling back to the default if it is unset or invalid.
+func memberBatchSize() int {
+	v := strings.TrimSpace(os.Getenv("AD_MEMBER_BATCH_SIZE"))
+	if v == "" {
+		return defaultMemberBatch
+	}
+	n, err := strconv.Atoi(v)
+	if err != nil || n < 1 {
+		tools.Log.Warnf("Invalid AD_MEMBER_BATCH_SIZE %q, using %d", v, defaultMemberBatch)
+		return defaultMemberBatch
+	}
+	return n
+}
+
+// AddUserToGroup adds a user (by DN) to the group's "member" attribute.
+func AddUserToGroup(client *ldapclient.LDAPClient, groupDN, userDN string) error {
+	return modifyMember(client, groupDN, userDN, true)
+}
+
+// RemoveUserFromGroup removes a user (by DN) from the group's "member" attribute.
+func RemoveUserFromGroup(client *ldapclient.LDAPClient, groupDN, userDN string) error {
+	return modifyMember(client, groupDN, userDN, false)
+}
+
+func modifyMember(client memberModifier, groupDN, userDN string, add bool) error {
+	modReq := ldap.NewModifyRequest(groupDN, nil)
+	if add {
+		modReq.Add("member", []string{userDN})
+	} else {
+		modReq.Delete("member", []string{userDN})
+	}
+
+	if err := client.Modify(modReq); err != nil {
+		return memberError(groupDN, userDN, add, err)
+	}
+	return nil
+}
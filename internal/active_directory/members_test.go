@@ -0,0 +1,99 @@
+package active_directory
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/go-ldap/ldap/v3"
+	"github.com/matthewdavidson09/dynamic-distro-groups/internal/ldapclient"
+)
+
+// fakeModifier fails requests that change a value in bad, and the first batch with batchErr.
+type fakeModifier struct {
+	bad      map[string]error
+	batchErr error
+	requests int
+}
+
+func (f *fakeModifier) Modify(req *ldap.ModifyRequest) error {
+	f.requests++
+	vals := req.Changes[0].Modification.Vals
+	if len(vals) > 1 && f.batchErr != nil {
+		err := f.batchErr
+		f.batchErr = nil
+		return err
+	}
+	for _, v := range vals {
+		if err := f.bad[v]; err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func TestModifyMembersFallback(t *testing.T) {
+	t.Setenv("AD_MEMBER_BATCH_SIZE", "2")
+	exists := ldap.NewError(ldap.LDAPResultAttributeOrValueExists, fmt.Errorf("value exists"))
+	noSuch := ldap.NewError(ldap.LDAPResultNoSuchObject, fmt.Errorf("no such object"))
+	retried := fmt.Errorf("%w: %w", ldapclient.ErrRetried, exists)
+
+	tests := []struct {
+		name         string
+		fake         *fakeModifier
+		add          bool
+		wantDone     int
+		wantErrs     int
+		wantRequests int
+	}{
+		{
+			name:         "every batch applies",
+			fake:         &fakeModifier{},
+			add:          true,
+			wantDone:     3,
+			wantRequests: 2,
+		},
+		{
+			name:         "a bad DN fails only itself",
+			fake:         &fakeModifier{bad: map[string]error{"CN=b": noSuch}},
+			add:          true,
+			wantDone:     2,
+			wantErrs:     1,
+			wantRequests: 4, // a+b fails, a, b, then c
+		},
+		{
+			name:         "retried batch counts members already added",
+			fake:         &fakeModifier{bad: map[string]error{"CN=a": exists, "CN=b": exists}, batchErr: retried},
+			add:          true,
+			wantDone:     3,
+			wantRequests: 4,
+		},
+		{
+			name:         "members already added fail without a retry",
+			fake:         &fakeModifier{bad: map[string]error{"CN=a": exists}},
+			add:          true,
+			wantDone:     2,
+			wantErrs:     1,
+			wantRequests: 4,
+		},
+		{
+			name:         "retried removal does not count members already there",
+			fake:         &fakeModifier{bad: map[string]error{"CN=a": exists}, batchErr: retried},
+			add:          false,
+			wantDone:     2,
+			wantErrs:     1,
+			wantRequests: 4,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			done, errs := modifyMembers(tt.fake, "CN=group", []string{"CN=a", "CN=b", "CN=c"}, tt.add)
+			if done != tt.wantDone || len(errs) != tt.wantErrs {
+				t.Errorf("modifyMembers() = %d, %v; want %d done and %d errors", done, errs, tt.wantDone, tt.wantErrs)
+			}
+			if tt.fake.requests != tt.wantRequests {
+				t.Errorf("modifyMembers() sent %d requests, want %d", tt.fake.requests, tt.wantRequests)
+			}
+		})
+	}
+}
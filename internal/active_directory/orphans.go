@@ -0,0 +1,113 @@
+package active_directory
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/matthewdavidson09/dynamic-distro-groups/internal/ldapclient"
+	"github.com/matthewdavidson09/dynamic-distro-groups/internal/plan"
+	"github.com/matthewdavidson09/dynamic-distro-groups/tools"
+)
+
+// OrphanPolicy is the lifecycle applied to managed groups that no rule produces any more.
+type OrphanPolicy struct {
+	ArchiveOU       string // retired groups are moved here; empty leaves them in place
+	DeleteAfterDays int    // delete this many days after the group was first orphaned; 0 never deletes
+	Hide            bool   // set HideAttribute on retired groups
+}
+
+// PlanOrphan decides what to do with a managed group no rule produces. It returns nil when the group is
+// already retired and not yet due for deletion.
+func PlanOrphan(group ADGroup, archived bool, policy OrphanPolicy, now time.Time) *plan.OrphanPlan {
+	o := &plan.OrphanPlan{
+		Target:      "ad",
+		Mail:        group.Email,
+		DN:          group.DN,
+		MemberCount: len(group.Members),
+		MembersHash: hashGroupMembers(group.Members),
+	}
+
+	since, stamped := plan.ParseOrphanStamp(group.Info)
+	if !stamped {
+		since = now.UTC().Format(plan.DateLayout)
+		o.Stamp = true
+		o.Hide = policy.Hide
+	}
+	o.OrphanedSince = since
+
+	if plan.DeleteDue(since, policy.DeleteAfterDays, now) {
+		o.Action = plan.OrphanDelete
+		return o
+	}
+
+	o.Action = plan.OrphanRetire
+	o.RemoveMembers = group.Members
+	if policy.ArchiveOU != "" && !archived {
+		o.MoveTo = policy.ArchiveOU
+	}
+	if !o.Stamp && len(o.RemoveMembers) == 0 && o.MoveTo == "" {
+		return nil
+	}
+	return o
+}
+
+// VerifyOrphanPlan checks that the group is still in the state o was planned against.
+func VerifyOrphanPlan(client *ldapclient.LDAPClient, o *plan.OrphanPlan) error {
+	group, err := GetGroupByDN(client, o.DN)
+	if errors.Is(err, ErrGroupNotFound) {
+		return fmt.Errorf("%s no longer exists: %w", o.DN, plan.ErrDrift)
+	}
+	if err != nil {
+		return err
+	}
+	if hashGroupMembers(group.Members) != o.MembersHash {
+		return fmt.Errorf("members of %s changed since the plan was made: %w", o.DN, plan.ErrDrift)
+	}
+	return nil
+}
+
+// ApplyOrphanPlan retires or deletes an orphaned group and returns how many members were removed.
+func ApplyOrphanPlan(client *ldapclient.LDAPClient, o *plan.OrphanPlan) (int, error) {
+	if o.Action == plan.OrphanDelete {
+		if err := DeleteGroup(client, o.DN); err != nil {
+			return 0, err
+		}
+		return o.MemberCount, nil
+	}
+
+	// 1. Empty the group
+	removed, errs := RemoveMembers(client, o.DN, o.RemoveMembers)
+	if len(errs) > 0 {
+		return removed, errors.Join(errs...)
+	}
+
+	// 2. Record when it was orphaned, next to any notes kept in info, and hide it
+	if o.Stamp {
+		group, err := GetGroupByDN(client, o.DN)
+		if err != nil {
+			return removed, err
+		}
+		attrs := map[string]string{"info": plan.AddOrphanStamp(group.Info, o.OrphanedSince)}
+		if o.Hide {
+			attrs[HideAttribute] = "TRUE"
+		}
+		if err := SetGroupAttributes(client, o.DN, attrs); err != nil {
+			return removed, err
+		}
+	}
+
+	// 3. Archive it
+	if o.MoveTo != "" {
+		if _, err := MoveGroup(client, o.DN, o.MoveTo); err != nil {
+			return removed, err
+		}
+	}
+
+	tools.Log.WithFields(map[string]interface{}{
+		"dn":      o.DN,
+		"removed": removed,
+		"since":   o.OrphanedSince,
+	}).Info("Retired orphaned group")
+	return removed, nil
+}
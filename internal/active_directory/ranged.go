@@ -0,0 +1,71 @@
+package active_directory
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/go-ldap/ldap/v3"
+)
+
+// rangeSearcher is the part of the LDAP client that reads further ranges.
+type rangeSearcher interface {
+	Search(req *ldap.SearchRequest) (*ldap.SearchResult, error)
+}
+
+// rangedValues returns every value of a multi-valued attribute of entry. Active Directory returns at most
+// MaxValRange (usually 1500) values per search, named e.g. member;range=0-1499 instead of member; the
+// rest is read with further base searches for member;range=1500-* and so on until a range ends in "*".
+func rangedValues(client rangeSearcher, entry *ldap.Entry, attr string) ([]string, error) {
+	values, end, ranged := rangedAttribute(entry, attr)
+	if !ranged {
+		return entry.GetAttributeValues(attr), nil
+	}
+
+	for end != "*" {
+		last, err := strconv.Atoi(end)
+		if err != nil {
+			return nil, fmt.Errorf("invalid %s range end %q on %s", attr, end, entry.DN)
+		}
+
+		searchReq := ldap.NewSearchRequest(
+			entry.DN,
+			ldap.ScopeBaseObject,
+			ldap.NeverDerefAliases,
+			1, 0, false,
+			"(objectClass=*)",
+			[]string{fmt.Sprintf("%s;range=%d-*", attr, last+1)},
+			nil,
+		)
+		result, err := client.Search(searchReq)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read %s values from %d of %s: %w", attr, last+1, entry.DN, err)
+		}
+		if len(result.Entries) == 0 {
+			return nil, fmt.Errorf("failed to read %s values from %d of %s: entry is gone", attr, last+1, entry.DN)
+		}
+
+		var page []string
+		page, end, ranged = rangedAttribute(result.Entries[0], attr)
+		if !ranged {
+			return nil, fmt.Errorf("failed to read %s values from %d of %s: no range returned", attr, last+1, entry.DN)
+		}
+		values = append(values, page...)
+	}
+	return values, nil
+}
+
+// rangedAttribute finds attr;range=<start>-<end> on entry and returns its values and end.
+func rangedAttribute(entry *ldap.Entry, attr string) ([]string, string, bool) {
+	prefix := strings.ToLower(attr) + ";range="
+	for _, a := range entry.Attributes {
+		if !strings.HasPrefix(strings.ToLower(a.Name), prefix) {
+			continue
+		}
+		bounds := a.Name[len(prefix):]
+		if i := strings.IndexByte(bounds, '-'); i >= 0 {
+			return a.Values, bounds[i+1:], true
+		}
+	}
+	return nil, "", false
+}
@@ -0,0 +1,115 @@
+package active_directory
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/go-ldap/ldap/v3"
+)
+
+// fakeRanges answers base searches for attr;range=<start>-* from pages, keyed by the requested attribute.
+type fakeRanges struct {
+	pages    map[string]*ldap.Entry
+	requests []string
+}
+
+func (f *fakeRanges) Search(req *ldap.SearchRequest) (*ldap.SearchResult, error) {
+	f.requests = append(f.requests, req.Attributes[0])
+	if req.Scope != ldap.ScopeBaseObject {
+		return nil, fmt.Errorf("scope %d, want a base search", req.Scope)
+	}
+	entry, ok := f.pages[req.Attributes[0]]
+	if !ok {
+		return &ldap.SearchResult{}, nil
+	}
+	return &ldap.SearchResult{Entries: []*ldap.Entry{entry}}, nil
+}
+
+func TestRangedValues(t *testing.T) {
+	const dn = "CN=all-staff,OU=Groups,DC=example,DC=com"
+	page := func(attr string, values ...string) *ldap.Entry {
+		return ldap.NewEntry(dn, map[string][]string{attr: values})
+	}
+
+	tests := []struct {
+		name         string
+		entry        *ldap.Entry
+		pages        map[string]*ldap.Entry
+		want         string
+		wantRequests string
+		wantErr      string
+	}{
+		{
+			name:  "not ranged",
+			entry: page("member", "CN=a", "CN=b"),
+			want:  "CN=a,CN=b",
+		},
+		{
+			name:  "whole range in the first result",
+			entry: page("member;range=0-*", "CN=a", "CN=b"),
+			want:  "CN=a,CN=b",
+		},
+		{
+			name:  "continued over two more searches",
+			entry: page("member;range=0-1", "CN=a", "CN=b"),
+			pages: map[string]*ldap.Entry{
+				"member;range=2-*": page("member;range=2-3", "CN=c", "CN=d"),
+				"member;range=4-*": page("member;range=4-*", "CN=e"),
+			},
+			want:         "CN=a,CN=b,CN=c,CN=d,CN=e",
+			wantRequests: "member;range=2-*,member;range=4-*",
+		},
+		{
+			name:  "attribute name case",
+			entry: page("directReports;Range=0-0", "CN=a"),
+			pages: map[string]*ldap.Entry{
+				"directReports;range=1-*": page("directreports;range=1-*", "CN=b"),
+			},
+			want:         "CN=a,CN=b",
+			wantRequests: "directReports;range=1-*",
+		},
+		{
+			name:    "entry gone",
+			entry:   page("member;range=0-1", "CN=a", "CN=b"),
+			wantErr: "failed to read member values from 2 of " + dn + ": entry is gone",
+		},
+		{
+			name:  "no range returned",
+			entry: page("member;range=0-1", "CN=a", "CN=b"),
+			pages: map[string]*ldap.Entry{
+				"member;range=2-*": page("member", "CN=c"),
+			},
+			wantErr: "no range returned",
+		},
+		{
+			name:    "bad range end",
+			entry:   page("member;range=0-x", "CN=a"),
+			wantErr: `invalid member range end "x"`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			attr := strings.SplitN(tt.entry.Attributes[0].Name, ";", 2)[0]
+			client := &fakeRanges{pages: tt.pages}
+
+			got, err := rangedValues(client, tt.entry, attr)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("rangedValues() = %v, want error containing %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if s := strings.Join(got, ","); s != tt.want {
+				t.Errorf("rangedValues() = %s, want %s", s, tt.want)
+			}
+			if s := strings.Join(client.requests, ","); s != tt.wantRequests {
+				t.Errorf("searched for %s, want %s", s, tt.wantRequests)
+			}
+		})
+	}
+}
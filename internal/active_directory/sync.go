@@ -1,12 +1,15 @@
 package active_directory
 
 import (
+	"errors"
 	"fmt"
 	"os"
+	"sort"
+	"strings"
 	"time"
 
-	"github.com/go-ldap/ldap/v3"
 	"github.com/matthewdavidson09/dynamic-distro-groups/internal/ldapclient"
+	"github.com/matthewdavidson09/dynamic-distro-groups/internal/plan"
 	"github.com/matthewdavidson09/dynamic-distro-groups/tools"
 )
 
@@ -19,105 +22,253 @@ type SyncResult struct {
 	Error    error
 }
 
-// SyncGroupByCategory ensures a group exists and syncs its members.
-func SyncGroupByCategory(client *ldapclient.LDAPClient, category, value string, users []ADUser, dryRun bool) (int, int, error) {
-	slug := tools.Slugify(value)
-	groupCN := fmt.Sprintf("list-%s-%s", category, slug)
-	email := fmt.Sprintf("%s@%s", groupCN, os.Getenv("GROUP_EMAIL_DOMAIN"))
+// PlanGroup computes the changes that make the group with the given identity in GROUP_OU match users.
+// A group retired to archiveOU is moved back rather than recreated. It only reads from AD.
+func PlanGroup(client *ldapclient.LDAPClient, id GroupIdentity, users []ADUser, archiveOU string) (*plan.ADPlan, error) {
 	groupOU := os.Getenv("GROUP_OU")
 
-	group, err := EnsureGroupExists(client, groupCN, email, groupOU, value)
+	group, archived, err := locateGroup(client, id, groupOU, archiveOU)
 	if err != nil {
-		return 0, 0, fmt.Errorf("unable to ensure group: %w", err)
+		return nil, err
+	}
+	if group != nil {
+		if err := CheckOwner(group, id.Owner); err != nil {
+			return nil, err
+		}
 	}
 
-	// Always ensure the mail attribute is correct
-	if mailErr := EnsureGroupMailAttribute(client, group.DN, email); mailErr != nil {
-		tools.Log.WithError(mailErr).Warnf("Could not update mail attribute for %s", group.DN)
+	p := &plan.ADPlan{}
+	if group == nil {
+		// 1. A missing group is created with every desired member
+		p.DN = GroupDN(id.CN, groupOU)
+		p.Create = &plan.ADGroup{
+			CN:             id.CN,
+			SAMAccountName: id.SAMAccountName,
+			Mail:           id.Email,
+			DisplayName:    id.DisplayName,
+			Description:    id.Description,
+			Owner:          id.Owner,
+		}
+		group = &ADGroup{DN: p.DN}
+	} else {
+		// 2. An existing group is renamed and has the rendered attributes fixed in place
+		p.DN = group.DN
+		p.ObjectGUID = group.ObjectGUID
+		if group.CN != id.CN {
+			p.Rename = id.CN
+		}
+		p.SetAttributes = attributeFixes(group, id)
+		if archived {
+			p.MoveTo = groupOU
+		}
 	}
 
-	added, removed, err := syncGroupMembers(client, group, users, groupCN, dryRun)
-	return added, removed, err
-}
+	p.AddMembers, p.RemoveMembers = diffMembers(group.Members, users)
+	p.MemberCount = len(group.Members)
+	p.MembersHash = hashGroupMembers(group.Members)
+	desired := make([]string, len(users))
+	for i, u := range users {
+		desired[i] = u.DN
+	}
+	p.DesiredHash = hashGroupMembers(desired)
 
-// AddUserToGroup adds a user (by DN) to the group's "member" attribute.
-func AddUserToGroup(client *ldapclient.LDAPClient, groupDN, userDN string) error {
-	modReq := ldap.NewModifyRequest(groupDN, nil)
-	modReq.Add("member", []string{userDN})
+	tools.Log.WithFields(map[string]interface{}{
+		"group":  id.CN,
+		"create": p.Create != nil,
+		"attrs":  len(p.SetAttributes),
+		"add":    len(p.AddMembers),
+		"remove": len(p.RemoveMembers),
+	}).Debug("Sync plan")
+
+	return p, nil
+}
 
-	if err := client.Conn.Modify(modReq); err != nil {
-		return fmt.Errorf("failed to add user %s to group %s: %w", userDN, groupDN, err)
+// AdoptGroup records the ownership marker of id.Owner on an existing group that lacks it or belongs to
+// another rule. It reports whether the group needed adopting.
+func AdoptGroup(client *ldapclient.LDAPClient, id GroupIdentity, archiveOU string, dryRun bool) (bool, error) {
+	group, _, err := locateGroup(client, id, os.Getenv("GROUP_OU"), archiveOU)
+	if err != nil || group == nil {
+		return false, err
+	}
+	if CheckOwner(group, id.Owner) == nil {
+		return false, nil
 	}
 
-	return nil
+	log := tools.Log.WithFields(map[string]interface{}{
+		"dn":       group.DN,
+		"previous": group.AdminDescription,
+		"rule":     id.Owner,
+	})
+	if dryRun {
+		log.Info("[DRY RUN] Would adopt group")
+		return true, nil
+	}
+	if err := SetGroupAttributes(client, group.DN, map[string]string{"adminDescription": plan.OwnerMarker(id.Owner)}); err != nil {
+		return true, err
+	}
+	log.Info("Adopted group")
+	return true, nil
 }
 
-// RemoveUserFromGroup removes a user (by DN) from the group's "member" attribute.
-func RemoveUserFromGroup(client *ldapclient.LDAPClient, groupDN, userDN string) error {
-	modReq := ldap.NewModifyRequest(groupDN, nil)
-	modReq.Delete("member", []string{userDN})
+// locateGroup finds the group in groupOU, then in archiveOU, reporting whether it was archived. A known
+// objectGUID is tried first, so a renamed group is still found. It returns a nil group when neither has it.
+func locateGroup(client *ldapclient.LDAPClient, id GroupIdentity, groupOU, archiveOU string) (*ADGroup, bool, error) {
+	ous := []string{groupOU}
+	if archiveOU != "" {
+		ous = append(ous, archiveOU)
+	}
 
-	if err := client.Conn.Modify(modReq); err != nil {
-		return fmt.Errorf("failed to remove user %s from group %s: %w", userDN, groupDN, err)
+	find := func(ou string) (*ADGroup, error) {
+		if id.ObjectGUID != "" {
+			group, err := GetGroupByGUID(client, id.ObjectGUID, ou)
+			if !errors.Is(err, ErrGroupNotFound) {
+				return group, err
+			}
+		}
+		return FindGroup(client, id, ou)
 	}
 
-	return nil
+	for i, ou := range ous {
+		group, err := find(ou)
+		if err == nil {
+			return group, i > 0, nil
+		}
+		if !errors.Is(err, ErrGroupNotFound) {
+			return nil, false, fmt.Errorf("unable to look up group: %w", err)
+		}
+	}
+	return nil, false, nil
 }
 
-// syncGroupMembers calculates and applies the difference between desired and actual group members.
-func syncGroupMembers(client *ldapclient.LDAPClient, group *ADGroup, users []ADUser, label string, dryRun bool) (int, int, error) {
-	current := make(map[string]struct{})
-	for _, dn := range group.Members {
-		current[NormalizeDN(dn)] = struct{}{}
+// VerifyGroupPlan checks that the group is still in the state p was planned against.
+// It returns an error wrapping plan.ErrDrift when it is not.
+func VerifyGroupPlan(client *ldapclient.LDAPClient, p *plan.ADPlan) error {
+	group, err := GetGroupByDN(client, p.DN)
+	if err != nil && !errors.Is(err, ErrGroupNotFound) {
+		return err
 	}
 
-	desired := make(map[string]ADUser)
-	for _, u := range users {
-		desired[NormalizeDN(u.DN)] = u
+	switch {
+	case p.Create != nil && group != nil:
+		return fmt.Errorf("%s was created since the plan was made: %w", p.DN, plan.ErrDrift)
+	case p.Create == nil && group == nil:
+		return fmt.Errorf("%s no longer exists: %w", p.DN, plan.ErrDrift)
+	case group != nil && hashGroupMembers(group.Members) != p.MembersHash:
+		return fmt.Errorf("members of %s changed since the plan was made: %w", p.DN, plan.ErrDrift)
 	}
+	return nil
+}
 
-	var toAdd, toRemove []string
-	for dn := range desired {
-		if _, exists := current[dn]; !exists {
-			toAdd = append(toAdd, dn)
+// ApplyGroupPlan executes an AD plan and returns how many members were added and removed. It records the
+// objectGUID of a group it creates in p.
+func ApplyGroupPlan(client *ldapclient.LDAPClient, p *plan.ADPlan) (int, int, error) {
+	// 1. Create the group, or rename it, move it back from the archive and fix its attributes
+	groupDN := p.DN
+	if p.Rename != "" {
+		var err error
+		if groupDN, err = RenameGroup(client, groupDN, p.Rename); err != nil {
+			return 0, 0, err
 		}
 	}
-	for dn := range current {
-		if _, exists := desired[dn]; !exists {
-			toRemove = append(toRemove, dn)
+	if p.MoveTo != "" {
+		var err error
+		if groupDN, err = MoveGroup(client, groupDN, p.MoveTo); err != nil {
+			return 0, 0, err
 		}
 	}
-
-	tools.Log.WithFields(map[string]interface{}{
-		"group":  label,
-		"add":    len(toAdd),
-		"remove": len(toRemove),
-	}).Debug("Sync plan")
-
-	if dryRun {
-		tools.Log.Debug("Dry run enabled — no changes made.")
-		for _, dn := range toAdd {
-			tools.Log.Debugf("[DRY] Add %s → %s", dn, label)
+	if p.Create != nil {
+		id := GroupIdentity{
+			CN:             p.Create.CN,
+			SAMAccountName: p.Create.SAMAccountName,
+			Email:          p.Create.Mail,
+			DisplayName:    p.Create.DisplayName,
+			Description:    p.Create.Description,
+			Owner:          p.Create.Owner,
+		}
+		if err := CreateGroup(client, groupDN, id); err != nil {
+			return 0, 0, err
 		}
-		for _, dn := range toRemove {
-			tools.Log.Debugf("[DRY] Remove %s ← %s", dn, label)
+		if group, err := GetGroupByDN(client, groupDN); err == nil {
+			p.ObjectGUID = group.ObjectGUID
 		}
-		return len(toAdd), len(toRemove), nil
+	}
+	if err := SetGroupAttributes(client, groupDN, p.SetAttributes); err != nil {
+		tools.Log.WithError(err).Warnf("Could not update attributes for %s", groupDN)
+	}
+
+	// 2. Apply member changes in batches, continuing past individual failures
+	added, addErrs := AddMembers(client, groupDN, p.AddMembers)
+	removed, removeErrs := RemoveMembers(client, groupDN, p.RemoveMembers)
+	failed := len(addErrs) + len(removeErrs)
+	for _, err := range append(addErrs, removeErrs...) {
+		tools.Log.Error(err)
+	}
+
+	if failed > 0 {
+		return added, removed, fmt.Errorf("%d of %d member changes failed on %s", failed, len(p.AddMembers)+len(p.RemoveMembers), groupDN)
+	}
+	return added, removed, nil
+}
+
+// attributeFixes returns the rendered attributes that differ on an existing group.
+func attributeFixes(group *ADGroup, id GroupIdentity) map[string]string {
+	fixes := make(map[string]string)
+	if !strings.EqualFold(group.SAMAccountName, id.SAMAccountName) {
+		fixes["sAMAccountName"] = id.SAMAccountName
+	}
+	if !strings.EqualFold(group.Email, id.Email) {
+		fixes["mail"] = id.Email
+	}
+	if group.DisplayName != id.DisplayName {
+		fixes["displayName"] = id.DisplayName
+	}
+	if group.Description != id.Description {
+		fixes["description"] = id.Description
 	}
 
-	for _, dn := range toAdd {
-		tools.Log.Debugf("Adding %s → %s", dn, label)
-		if err := AddUserToGroup(client, group.DN, dn); err != nil {
-			tools.Log.WithError(err).Errorf("Failed to add %s", dn)
+	// A revived orphan loses its stamp, but keeps any other notes, and is visible again.
+	if _, stamped := plan.ParseOrphanStamp(group.Info); stamped {
+		fixes["info"] = plan.StripOrphanStamp(group.Info)
+		if group.Hidden {
+			fixes[HideAttribute] = ""
 		}
 	}
+	return fixes
+}
+
+// diffMembers returns the DNs to add and remove so that current matches users. Both lists are sorted.
+func diffMembers(current []string, users []ADUser) ([]string, []string) {
+	currentSet := make(map[string]string)
+	for _, dn := range current {
+		currentSet[NormalizeDN(dn)] = dn
+	}
 
-	for _, dn := range toRemove {
-		tools.Log.Debugf("Removing %s ← %s", dn, label)
-		if err := RemoveUserFromGroup(client, group.DN, dn); err != nil {
-			tools.Log.WithError(err).Errorf("Failed to remove %s", dn)
+	desired := make(map[string]string)
+	for _, u := range users {
+		desired[NormalizeDN(u.DN)] = u.DN
+	}
+
+	var toAdd, toRemove []string
+	for key, dn := range desired {
+		if _, exists := currentSet[key]; !exists {
+			toAdd = append(toAdd, dn)
+		}
+	}
+	for key, dn := range currentSet {
+		if _, exists := desired[key]; !exists {
+			toRemove = append(toRemove, dn)
 		}
 	}
 
-	return len(toAdd), len(toRemove), nil
+	sort.Strings(toAdd)
+	sort.Strings(toRemove)
+	return toAdd, toRemove
+}
+
+func hashGroupMembers(members []string) string {
+	normalized := make([]string, len(members))
+	for i, dn := range members {
+		normalized[i] = NormalizeDN(dn)
+	}
+	return plan.HashMembers(normalized)
 }
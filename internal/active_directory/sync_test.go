@@ -0,0 +1,56 @@
+package active_directory
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/go-ldap/ldap/v3"
+)
+
+func TestAttributeFixes(t *testing.T) {
+	id := GroupIdentity{
+		CN:             "list-dept-sales",
+		SAMAccountName: "list-dept-sales",
+		Email:          "list-dept-sales@example.com",
+		DisplayName:    "Sales",
+		Description:    "Sales distro group",
+	}
+	entry := ldap.NewEntry("CN=list-dept-sales,OU=Groups,DC=example,DC=com", map[string][]string{
+		"cn":             {"list-dept-sales"},
+		"sAMAccountName": {"List-Dept-Sales"},
+		"mail":           {"List-Dept-Sales@example.com"},
+		"displayName":    {"Sales"},
+		"description":    {"Sales distro group"},
+		"member":         {"CN=Ann,DC=example,DC=com"},
+	})
+	group, err := groupFromEntry(nil, entry)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if fixes := attributeFixes(&group, id); len(fixes) != 0 {
+		t.Errorf("attributeFixes() of an unchanged group = %v, want none", fixes)
+	}
+
+	id.SAMAccountName, id.DisplayName = "list-sales", "Sales Team"
+	want := map[string]string{"sAMAccountName": "list-sales", "displayName": "Sales Team"}
+	if fixes := attributeFixes(&group, id); !reflect.DeepEqual(fixes, want) {
+		t.Errorf("attributeFixes() = %v, want %v", fixes, want)
+	}
+}
+
+func TestAttributeFixesRevivedOrphan(t *testing.T) {
+	id := GroupIdentity{SAMAccountName: "list-dept-sales", Email: "list-dept-sales@example.com", DisplayName: "Sales"}
+	group := ADGroup{
+		SAMAccountName: id.SAMAccountName,
+		Email:          id.Email,
+		DisplayName:    id.DisplayName,
+		Info:           "Ask finance before changing [orphaned 2025-03-01]",
+		Hidden:         true,
+	}
+
+	want := map[string]string{"info": "Ask finance before changing", HideAttribute: ""}
+	if fixes := attributeFixes(&group, id); !reflect.DeepEqual(fixes, want) {
+		t.Errorf("attributeFixes() = %v, want %v", fixes, want)
+	}
+}
@@ -30,15 +30,93 @@ type ADUser struct {
 	Enabled        bool
 	UACFlags       []string
 	DirectReports  []string
+	Attributes     map[string][]string // extended and rule-requested attributes, keyed by lowercased LDAP name
 }
 
-// GetUsersByFilter returns a list of AD users based on the provided filter and criteria
+// baseAttributes are mapped onto ADUser fields.
+var baseAttributes = []string{
+	"cn", "mail", "department", "distinguishedName", "st", "userAccountControl",
+	"objectGUID", "givenName", "sn", "displayName", "employeeID", "title",
+	"streetAddress", "l", "postalCode", "manager", "sAMAccountName", "directReports",
+}
+
+// ExtendedAttributes are always fetched into ADUser.Attributes so they can be grouped on without extra config.
+var ExtendedAttributes = []string{
+	"company", "division", "employeeType", "physicalDeliveryOfficeName",
+	"extensionAttribute1", "extensionAttribute2", "extensionAttribute3", "extensionAttribute4",
+	"extensionAttribute5", "extensionAttribute6", "extensionAttribute7", "extensionAttribute8",
+	"extensionAttribute9", "extensionAttribute10", "extensionAttribute11", "extensionAttribute12",
+	"extensionAttribute13", "extensionAttribute14", "extensionAttribute15",
+}
+
+// Attribute returns the value of a user attribute by its LDAP or field name (case-insensitive).
+func (u ADUser) Attribute(name string) string {
+	switch strings.ToLower(strings.TrimSpace(name)) {
+	case "cn":
+		return u.CN
+	case "dn", "distinguishedname":
+		return u.DN
+	case "guid", "objectguid":
+		return u.GUID
+	case "displayname":
+		return u.DisplayName
+	case "givenname":
+		return u.GivenName
+	case "sn", "surname":
+		return u.Surname
+	case "mail", "email":
+		return u.Email
+	case "employeeid":
+		return u.EmployeeID
+	case "department":
+		return u.Department
+	case "title":
+		return u.Title
+	case "streetaddress":
+		return u.StreetAddress
+	case "l", "city":
+		return u.City
+	case "st", "state":
+		return u.State
+	case "postalcode":
+		return u.PostalCode
+	case "manager", "managerdn":
+		return u.ManagerDN
+	case "samaccountname":
+		return u.SAMAccountName
+	case "office":
+		return u.Attribute("physicalDeliveryOfficeName")
+	}
+	if values := u.Attributes[strings.ToLower(strings.TrimSpace(name))]; len(values) > 0 {
+		return values[0]
+	}
+	return ""
+}
+
+// AttributeValues returns every value of a user attribute; single-valued fields yield at most one entry.
+func (u ADUser) AttributeValues(name string) []string {
+	key := strings.ToLower(strings.TrimSpace(name))
+	if key == "directreports" {
+		return u.DirectReports
+	}
+	if values, ok := u.Attributes[key]; ok {
+		return values
+	}
+	if value := u.Attribute(name); value != "" {
+		return []string{value}
+	}
+	return nil
+}
+
+// GetUsersByFilter returns a list of AD users based on the provided filter and criteria.
+// extraAttributes are fetched in addition to the base and extended attributes and stored in ADUser.Attributes.
 func GetUsersByFilter(
 	client *ldapclient.LDAPClient,
 	filterMap map[string]string,
 	enabledOnly bool,
 	requireMail bool,
 	excludeOUs []string,
+	extraAttributes []string,
 ) ([]ADUser, error) {
 	var filterParts []string
 	filterParts = append(filterParts, "(objectClass=user)")
@@ -61,11 +139,8 @@ func GetUsersByFilter(
 
 	ldapFilter := fmt.Sprintf("(&%s)", strings.Join(filterParts, ""))
 
-	attributes := []string{
-		"cn", "mail", "department", "distinguishedName", "st", "userAccountControl",
-		"objectGUID", "givenName", "sn", "displayName", "employeeID", "title",
-		"streetAddress", "l", "postalCode", "manager", "sAMAccountName", "directReports",
-	}
+	mapped := append(append([]string{}, ExtendedAttributes...), extraAttributes...)
+	attributes := append(append([]string{}, baseAttributes...), mapped...)
 
 	searchReq := ldap.NewSearchRequest(
 		client.BaseDN,
@@ -77,7 +152,8 @@ func GetUsersByFilter(
 		nil,
 	)
 
-	result, err := client.Conn.Search(searchReq)
+	// Paged, as a truncated user list would empty every group.
+	result, err := client.SearchPaged(searchReq)
 	if err != nil {
 		return nil, fmt.Errorf("LDAP search failed: %w", err)
 	}
@@ -95,6 +171,11 @@ func GetUsersByFilter(
 			continue
 		}
 
+		directReports, err := rangedValues(client, entry, "directReports")
+		if err != nil {
+			return nil, err
+		}
+
 		users = append(users, ADUser{
 			CN:             entry.GetAttributeValue("cn"),
 			DN:             dn,
@@ -111,12 +192,24 @@ func GetUsersByFilter(
 			State:          entry.GetAttributeValue("st"),
 			PostalCode:     entry.GetAttributeValue("postalCode"),
 			ManagerDN:      entry.GetAttributeValue("manager"),
-			DirectReports:  entry.GetAttributeValues("directReports"),
+			DirectReports:  directReports,
 			SAMAccountName: entry.GetAttributeValue("sAMAccountName"),
 			Enabled:        !isUserDisabled(entry.GetAttributeValue("userAccountControl")),
 			UACFlags:       parseUACFlags(entry.GetAttributeValue("userAccountControl")),
+			Attributes:     collectAttributes(entry, mapped),
 		})
 	}
 
 	return users, nil
 }
+
+// collectAttributes copies the named attributes of an entry into a map keyed by lowercased name.
+func collectAttributes(entry *ldap.Entry, names []string) map[string][]string {
+	attrs := make(map[string][]string)
+	for _, name := range names {
+		if values := entry.GetEqualFoldAttributeValues(name); len(values) > 0 {
+			attrs[strings.ToLower(name)] = values
+		}
+	}
+	return attrs
+}
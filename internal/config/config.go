@@ -0,0 +1,402 @@
+package config
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"sort"
+	"strings"
+
+	"github.com/matthewdavidson09/dynamic-distro-groups/internal/active_directory"
+	"github.com/matthewdavidson09/dynamic-distro-groups/internal/expr"
+	"google.golang.org/api/groupssettings/v1"
+	"gopkg.in/yaml.v3"
+)
+
+// Supported sync targets for a rule.
+const (
+	TargetAD     = "ad"
+	TargetGoogle = "google"
+)
+
+// Google role policies for a rule.
+const (
+	RolesManagers = "managers" // users with direct reports are group MANAGERs
+	RolesMembers  = "members"  // everyone is a plain MEMBER
+)
+
+// DefaultSettingsProfile is the built-in Google group settings profile.
+const DefaultSettingsProfile = "default"
+
+// Config is the parsed rules file.
+type Config struct {
+	Rules            []Rule                       `yaml:"rules" json:"rules"`
+	SettingsProfiles map[string]map[string]string `yaml:"settings_profiles" json:"settings_profiles"`
+	Safety           *Safety                      `yaml:"safety" json:"safety"`
+	Orphans          Orphans                      `yaml:"orphans" json:"orphans"`
+	StatePath        string                       `yaml:"state_path" json:"state_path"` // state file remembering managed groups between runs
+
+	filtered bool // set when FilterRules dropped rules
+}
+
+// Orphans controls what happens to managed groups that no rule produces any more. They are emptied, hidden
+// and stamped with the date, moved to ArchiveOU if set, and deleted DeleteAfterDays later.
+type Orphans struct {
+	Enabled         bool   `yaml:"enabled" json:"enabled"`
+	ArchiveOU       string `yaml:"archive_ou" json:"archive_ou"`               // AD OU retired groups are moved to
+	DeleteAfterDays int    `yaml:"delete_after_days" json:"delete_after_days"` // 0 keeps retired groups forever
+	HideInAD        bool   `yaml:"hide_in_ad" json:"hide_in_ad"`               // set msExchHideFromAddressLists; needs the Exchange schema
+}
+
+// Safety bounds how much a single run may remove. A run that exceeds any limit changes nothing unless
+// forced. Zero disables a limit. Without a safety block in the rules file, DefaultSafety applies.
+type Safety struct {
+	MinSourceUsers         int     `yaml:"min_source_users" json:"min_source_users"`                   // fewer users from LDAP means a broken search
+	MaxGroupRemovals       int     `yaml:"max_group_removals" json:"max_group_removals"`               // per group and target
+	MaxGroupRemovalPercent float64 `yaml:"max_group_removal_percent" json:"max_group_removal_percent"` // of the group's current members
+	MaxRunRemovals         int     `yaml:"max_run_removals" json:"max_run_removals"`                   // across every group and target
+	MaxRunRemovalPercent   float64 `yaml:"max_run_removal_percent" json:"max_run_removal_percent"`     // of all current members
+}
+
+// Rule declares one family of distribution groups.
+type Rule struct {
+	ID           string            `yaml:"id" json:"id"`
+	GroupBy      string            `yaml:"group_by" json:"group_by"`           // attribute to bucket users by
+	CrossProduct []string          `yaml:"cross_product" json:"cross_product"` // attributes whose value combinations each get a group
+	Normalize    []string          `yaml:"normalize" json:"normalize"`         // normalization steps applied to grouping values
+	Value        string            `yaml:"value" json:"value"`                 // value of the single group when not grouping
+	Filter       map[string]string `yaml:"filter" json:"filter"`               // attribute equality filters applied before grouping
+	Where        *Predicate        `yaml:"where" json:"where"`                 // AND/OR/NOT condition applied before grouping
+	Match        string            `yaml:"match" json:"match"`                 // membership expression applied before grouping
+	Naming       Naming            `yaml:"naming" json:"naming"`
+	Targets      []string          `yaml:"targets" json:"targets"`
+	Google       GoogleOptions     `yaml:"google" json:"google"`
+	Workers      int               `yaml:"workers" json:"workers"`
+
+	program *expr.Program // compiled Match, set by Validate
+}
+
+// Naming controls how groups produced by a rule are named. Every field but Category is a text/template
+// over NameData; see the Default*Template constants for the defaults.
+type Naming struct {
+	Category       string `yaml:"category" json:"category"` // used by the default CN template: list-<category>-<value>
+	CN             string `yaml:"cn" json:"cn"`
+	SAMAccountName string `yaml:"sam_account_name" json:"sam_account_name"`
+	Mail           string `yaml:"mail" json:"mail"`
+	DisplayName    string `yaml:"display_name" json:"display_name"`
+	Description    string `yaml:"description" json:"description"`
+	GoogleName     string `yaml:"google_name" json:"google_name"`
+}
+
+// GoogleOptions controls the Google Workspace side of a rule.
+type GoogleOptions struct {
+	Roles    string `yaml:"roles" json:"roles"`
+	Settings string `yaml:"settings" json:"settings"`
+}
+
+// Load reads a rules file. Files ending in .json are parsed as JSON, everything else as YAML.
+func Load(path string) (*Config, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read rules file: %w", err)
+	}
+
+	var cfg Config
+	if strings.EqualFold(filepath.Ext(path), ".json") {
+		err = json.Unmarshal(data, &cfg)
+	} else {
+		err = yaml.Unmarshal(data, &cfg)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse rules file %s: %w", path, err)
+	}
+
+	cfg.applyDefaults()
+	if err := cfg.Validate(); err != nil {
+		return nil, fmt.Errorf("invalid rules file %s: %w", path, err)
+	}
+	return &cfg, nil
+}
+
+// Default returns the rules equivalent to the original built-in group families.
+func Default() *Config {
+	cfg := &Config{
+		Rules: []Rule{
+			{
+				ID:      "departments",
+				GroupBy: "department",
+				Naming:  Naming{Category: "dept", DisplayName: "Dept: {{ .Label }}"},
+			},
+			{
+				ID:      "states",
+				GroupBy: "state",
+				Naming:  Naming{Category: "state", DisplayName: "State: {{ .Label }}"},
+			},
+			{
+				// The original sync named the AD group list-manager-<sam> and the Google group list-reports-<sam>;
+				// keeping both finds existing groups by CN in AD and by mail in Google.
+				ID:      "managers",
+				GroupBy: "manager",
+				Naming: Naming{
+					Category:    "reports",
+					CN:          "list-manager-{{ .Slug }}",
+					Mail:        "list-reports-{{ .Slug }}@{{ .Domain }}",
+					DisplayName: "Manager: {{ .Label }}",
+				},
+				Google: GoogleOptions{Roles: RolesMembers},
+			},
+			{
+				ID:      "all-employees",
+				Value:   "employees",
+				Naming:  Naming{Category: "all", DisplayName: "All Employees"},
+				Workers: 1,
+			},
+		},
+	}
+	cfg.applyDefaults()
+	return cfg
+}
+
+// FilterRules keeps only the rules whose ID is listed. "all" keeps everything.
+func (c *Config) FilterRules(ids []string) {
+	keep := make(map[string]bool)
+	for _, id := range ids {
+		id = strings.ToLower(strings.TrimSpace(id))
+		if id == "all" {
+			return
+		}
+		if id != "" {
+			keep[id] = true
+		}
+	}
+
+	var rules []Rule
+	for _, r := range c.Rules {
+		if keep[strings.ToLower(r.ID)] {
+			rules = append(rules, r)
+		}
+	}
+	c.filtered = c.filtered || len(rules) != len(c.Rules)
+	c.Rules = rules
+}
+
+// Filtered reports whether FilterRules dropped any rules, so the config no longer describes every managed group.
+func (c *Config) Filtered() bool {
+	return c.filtered
+}
+
+// Attributes returns every user attribute referenced by the rules, so they can be fetched from LDAP.
+func (c *Config) Attributes() []string {
+	seen := make(map[string]bool)
+	var attrs []string
+	add := func(name string) {
+		key := strings.ToLower(strings.TrimSpace(name))
+		if key != "" && !seen[key] {
+			seen[key] = true
+			attrs = append(attrs, name)
+		}
+	}
+
+	for _, r := range c.Rules {
+		add(r.GroupBy)
+		for _, attr := range r.CrossProduct {
+			add(attr)
+		}
+		for attr := range r.Filter {
+			add(attr)
+		}
+		if r.Where != nil {
+			for _, attr := range r.Where.Attributes() {
+				add(attr)
+			}
+		}
+		if r.program != nil {
+			for _, attr := range r.program.Attributes() {
+				add(attr)
+			}
+		}
+	}
+	return attrs
+}
+
+// Program returns the compiled match expression, or nil if the rule has none.
+func (r Rule) Program() *expr.Program {
+	return r.program
+}
+
+// HasTarget reports whether the rule syncs to the given target.
+func (r Rule) HasTarget(target string) bool {
+	return slices.Contains(r.Targets, target)
+}
+
+// DefaultSafety refuses runs on an empty user list or that would remove more than a quarter of all
+// current members.
+func DefaultSafety() *Safety {
+	return &Safety{
+		MinSourceUsers:       1,
+		MaxRunRemovalPercent: 25,
+	}
+}
+
+// DefaultStatePath is the state file used when the rules file does not set state_path.
+const DefaultStatePath = "state.json"
+
+func (c *Config) applyDefaults() {
+	if c.Safety == nil {
+		c.Safety = DefaultSafety()
+	}
+	if c.StatePath == "" {
+		c.StatePath = DefaultStatePath
+	}
+	for i := range c.Rules {
+		r := &c.Rules[i]
+		if len(r.Targets) == 0 {
+			r.Targets = []string{TargetAD, TargetGoogle}
+		}
+		for j, t := range r.Targets {
+			r.Targets[j] = strings.ToLower(strings.TrimSpace(t))
+		}
+		if r.Google.Roles == "" {
+			r.Google.Roles = RolesManagers
+		}
+		if r.Google.Settings == "" {
+			r.Google.Settings = DefaultSettingsProfile
+		}
+		if r.Naming.Category == "" {
+			r.Naming.Category = r.ID
+		}
+		r.Naming.applyDefaults()
+		if r.Normalize == nil && r.GroupBy != "" {
+			r.Normalize = DefaultNormalize(r.GroupBy)
+		}
+		if r.Workers <= 0 {
+			r.Workers = 5
+		}
+	}
+}
+
+// ApplySettingsProfile overlays a settings profile onto settings. Every key must be a Groups Settings API
+// field name, e.g. "whoCanPostMessage", holding a value of that field's type.
+func ApplySettingsProfile(settings *groupssettings.Groups, profile map[string]string) error {
+	data, err := json.Marshal(profile)
+	if err != nil {
+		return err
+	}
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.DisallowUnknownFields()
+	return dec.Decode(settings)
+}
+
+// DefaultNormalize keeps the historical bucketing of departments (title case) and states (upper case).
+func DefaultNormalize(attr string) []string {
+	switch strings.ToLower(attr) {
+	case "department":
+		return []string{"trim", "title"}
+	case "state", "st":
+		return []string{"trim", "upper"}
+	}
+	return []string{"trim"}
+}
+
+// Validate checks the rules for mistakes that would only surface mid-sync and compiles match expressions.
+func (c *Config) Validate() error {
+	if len(c.Rules) == 0 {
+		return fmt.Errorf("no rules defined")
+	}
+
+	if s := c.Safety; s != nil {
+		if s.MinSourceUsers < 0 || s.MaxGroupRemovals < 0 || s.MaxRunRemovals < 0 {
+			return fmt.Errorf("safety: limits cannot be negative")
+		}
+		for _, pct := range []float64{s.MaxGroupRemovalPercent, s.MaxRunRemovalPercent} {
+			if pct < 0 || pct > 100 {
+				return fmt.Errorf("safety: percentages must be between 0 and 100, got %g", pct)
+			}
+		}
+	}
+
+	if c.Orphans.DeleteAfterDays < 0 {
+		return fmt.Errorf("orphans: delete_after_days cannot be negative")
+	}
+
+	names := make([]string, 0, len(c.SettingsProfiles))
+	for name := range c.SettingsProfiles {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		if err := ApplySettingsProfile(&groupssettings.Groups{}, c.SettingsProfiles[name]); err != nil {
+			return fmt.Errorf("settings_profiles.%s: %w", name, err)
+		}
+	}
+
+	seen := make(map[string]bool)
+	for i := range c.Rules {
+		r := &c.Rules[i]
+		if r.ID == "" {
+			return fmt.Errorf("rule #%d: id is required", i+1)
+		}
+		if strings.ContainsAny(r.ID, " \t\n]") {
+			return fmt.Errorf("rule %q: id cannot contain whitespace or ']', it is recorded in ownership markers", r.ID)
+		}
+		if seen[r.ID] {
+			return fmt.Errorf("rule %q: duplicate id", r.ID)
+		}
+		seen[r.ID] = true
+
+		modes := 0
+		for _, set := range []bool{r.GroupBy != "", len(r.CrossProduct) > 0, r.Value != ""} {
+			if set {
+				modes++
+			}
+		}
+		if modes != 1 {
+			return fmt.Errorf("rule %q: exactly one of group_by, cross_product or value is required", r.ID)
+		}
+		if len(r.CrossProduct) == 1 {
+			return fmt.Errorf("rule %q: cross_product needs at least two attributes, use group_by for one", r.ID)
+		}
+
+		if r.Where != nil {
+			if err := r.Where.Validate("where"); err != nil {
+				return fmt.Errorf("rule %q: %w", r.ID, err)
+			}
+		}
+		if r.Match != "" {
+			prog, err := expr.Compile(r.Match)
+			if err != nil {
+				return fmt.Errorf("rule %q: match: %w", r.ID, err)
+			}
+			r.program = prog
+		}
+
+		if _, err := active_directory.NewNormalizer(r.Normalize); err != nil {
+			return fmt.Errorf("rule %q: %w", r.ID, err)
+		}
+
+		for _, t := range r.Targets {
+			if t != TargetAD && t != TargetGoogle {
+				return fmt.Errorf("rule %q: unknown target %q", r.ID, t)
+			}
+		}
+
+		if r.Google.Roles != RolesManagers && r.Google.Roles != RolesMembers {
+			return fmt.Errorf("rule %q: unknown google.roles %q", r.ID, r.Google.Roles)
+		}
+		if r.Google.Settings != DefaultSettingsProfile {
+			if _, ok := c.SettingsProfiles[r.Google.Settings]; !ok {
+				return fmt.Errorf("rule %q: unknown settings profile %q", r.ID, r.Google.Settings)
+			}
+		}
+
+		for field, text := range r.Naming.templates() {
+			if _, err := parseTemplate(field, text); err != nil {
+				return fmt.Errorf("rule %q: bad naming.%s template: %w", r.ID, field, err)
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,221 @@
+package config
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestDefaultIsValid(t *testing.T) {
+	if err := Default().Validate(); err != nil {
+		t.Fatalf("Default().Validate() = %v", err)
+	}
+}
+
+func TestDefaultKeepsOriginalNames(t *testing.T) {
+	// The names the hardcoded syncs gave each family's AD group (CN) and Google group (mail).
+	want := map[string][2]string{
+		"departments":   {"list-dept-customer-success", "list-dept-customer-success@example.com"},
+		"states":        {"list-state-customer-success", "list-state-customer-success@example.com"},
+		"managers":      {"list-manager-customer-success", "list-reports-customer-success@example.com"},
+		"all-employees": {"list-all-customer-success", "list-all-customer-success@example.com"},
+	}
+	for _, rule := range Default().Rules {
+		names, err := rule.Naming.Render(NameData{
+			RuleID:   rule.ID,
+			Category: rule.Naming.Category,
+			Value:    "Customer Success",
+			Slug:     "customer-success",
+			Label:    "Customer Success",
+			Domain:   "example.com",
+		})
+		if err != nil {
+			t.Fatalf("rule %s: %v", rule.ID, err)
+		}
+		if got := [2]string{names.CN, names.Mail}; got != want[rule.ID] {
+			t.Errorf("rule %s renders CN and mail %q, want %q", rule.ID, got, want[rule.ID])
+		}
+	}
+}
+
+func TestValidate(t *testing.T) {
+	tests := []struct {
+		name    string
+		rules   []Rule
+		edit    func(*Config)
+		wantErr string // empty when valid
+	}{
+		{
+			name:  "group_by",
+			rules: []Rule{{ID: "departments", GroupBy: "department"}},
+		},
+		{
+			name:  "single value",
+			rules: []Rule{{ID: "everyone", Value: "employees"}},
+		},
+		{
+			name:    "no rules",
+			wantErr: "no rules defined",
+		},
+		{
+			name:    "missing id",
+			rules:   []Rule{{GroupBy: "department"}},
+			wantErr: "rule #1: id is required",
+		},
+		{
+			name:    "id with whitespace",
+			rules:   []Rule{{ID: "by dept", GroupBy: "department"}},
+			wantErr: "cannot contain whitespace",
+		},
+		{
+			name:    "duplicate id",
+			rules:   []Rule{{ID: "a", GroupBy: "department"}, {ID: "a", GroupBy: "state"}},
+			wantErr: `rule "a": duplicate id`,
+		},
+		{
+			name:    "no grouping",
+			rules:   []Rule{{ID: "a"}},
+			wantErr: "exactly one of group_by, cross_product or value",
+		},
+		{
+			name:    "two groupings",
+			rules:   []Rule{{ID: "a", GroupBy: "department", Value: "x"}},
+			wantErr: "exactly one of group_by, cross_product or value",
+		},
+		{
+			name:    "unknown target",
+			rules:   []Rule{{ID: "a", GroupBy: "department", Targets: []string{"exchange"}}},
+			wantErr: `unknown target "exchange"`,
+		},
+		{
+			name:    "unknown roles",
+			rules:   []Rule{{ID: "a", GroupBy: "department", Google: GoogleOptions{Roles: "owners"}}},
+			wantErr: `unknown google.roles "owners"`,
+		},
+		{
+			name:    "unknown settings profile",
+			rules:   []Rule{{ID: "a", GroupBy: "department", Google: GoogleOptions{Settings: "locked"}}},
+			wantErr: `unknown settings profile "locked"`,
+		},
+		{
+			name:  "known settings profile",
+			rules: []Rule{{ID: "a", GroupBy: "department", Google: GoogleOptions{Settings: "locked"}}},
+			edit: func(c *Config) {
+				c.SettingsProfiles = map[string]map[string]string{"locked": {"whoCanPostMessage": "ALL_MANAGERS_CAN_POST"}}
+			},
+		},
+		{
+			name:  "misspelled settings field",
+			rules: []Rule{{ID: "a", GroupBy: "department"}},
+			edit: func(c *Config) {
+				c.SettingsProfiles = map[string]map[string]string{"open": {"whoCanPostMesage": "ANYONE_CAN_POST"}}
+			},
+			wantErr: `settings_profiles.open: json: unknown field "whoCanPostMesage"`,
+		},
+		{
+			name:  "settings value of the wrong type",
+			rules: []Rule{{ID: "a", GroupBy: "department"}},
+			edit: func(c *Config) {
+				c.SettingsProfiles = map[string]map[string]string{"small": {"maxMessageBytes": "1024"}}
+			},
+			wantErr: "settings_profiles.small:",
+		},
+		{
+			name:    "bad normalize step",
+			rules:   []Rule{{ID: "a", GroupBy: "department", Normalize: []string{"reverse"}}},
+			wantErr: `rule "a"`,
+		},
+		{
+			name:    "bad naming template",
+			rules:   []Rule{{ID: "a", GroupBy: "department", Naming: Naming{CN: "{{ .Slug"}}},
+			wantErr: "bad naming.cn template",
+		},
+		{
+			name:    "negative safety limit",
+			rules:   []Rule{{ID: "a", GroupBy: "department"}},
+			edit:    func(c *Config) { c.Safety.MaxGroupRemovals = -1 },
+			wantErr: "limits cannot be negative",
+		},
+		{
+			name:    "safety percentage above 100",
+			rules:   []Rule{{ID: "a", GroupBy: "department"}},
+			edit:    func(c *Config) { c.Safety.MaxRunRemovalPercent = 150 },
+			wantErr: "percentages must be between 0 and 100",
+		},
+		{
+			name:    "negative orphan delay",
+			rules:   []Rule{{ID: "a", GroupBy: "department"}},
+			edit:    func(c *Config) { c.Orphans.DeleteAfterDays = -1 },
+			wantErr: "delete_after_days cannot be negative",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := &Config{Rules: tt.rules}
+			cfg.applyDefaults()
+			if tt.edit != nil {
+				tt.edit(cfg)
+			}
+
+			err := cfg.Validate()
+			switch {
+			case tt.wantErr == "" && err != nil:
+				t.Fatalf("Validate() = %v, want nil", err)
+			case tt.wantErr != "" && err == nil:
+				t.Fatalf("Validate() = nil, want error containing %q", tt.wantErr)
+			case tt.wantErr != "" && !strings.Contains(err.Error(), tt.wantErr):
+				t.Fatalf("Validate() = %v, want error containing %q", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestApplyDefaults(t *testing.T) {
+	cfg := &Config{Rules: []Rule{{ID: "departments", GroupBy: "department"}}}
+	cfg.applyDefaults()
+
+	r := cfg.Rules[0]
+	if !r.HasTarget(TargetAD) || !r.HasTarget(TargetGoogle) {
+		t.Errorf("Targets = %v, want both ad and google", r.Targets)
+	}
+	if r.Google.Roles != RolesManagers || r.Google.Settings != DefaultSettingsProfile {
+		t.Errorf("Google = %+v, want managers roles and default settings", r.Google)
+	}
+	if r.Naming.Category != "departments" || r.Naming.CN != DefaultCNTemplate {
+		t.Errorf("Naming = %+v, want category from id and default templates", r.Naming)
+	}
+	if cfg.Safety == nil || cfg.Safety.MinSourceUsers != 1 {
+		t.Errorf("Safety = %+v, want DefaultSafety", cfg.Safety)
+	}
+	if cfg.StatePath != DefaultStatePath {
+		t.Errorf("StatePath = %q, want %q", cfg.StatePath, DefaultStatePath)
+	}
+}
+
+func TestApplyDefaultsLowercasesTargets(t *testing.T) {
+	cfg := &Config{Rules: []Rule{{ID: "departments", GroupBy: "department", Targets: []string{"AD", " Google "}}}}
+	cfg.applyDefaults()
+	if err := cfg.Validate(); err != nil {
+		t.Fatalf("Validate() = %v", err)
+	}
+	if r := cfg.Rules[0]; !r.HasTarget(TargetAD) || !r.HasTarget(TargetGoogle) {
+		t.Errorf("Targets = %q, want ad and google", r.Targets)
+	}
+}
+
+func TestFilterRules(t *testing.T) {
+	cfg := Default()
+	cfg.FilterRules([]string{"States", " managers "})
+	if len(cfg.Rules) != 2 || cfg.Rules[0].ID != "states" || cfg.Rules[1].ID != "managers" {
+		t.Fatalf("FilterRules kept %v", cfg.Rules)
+	}
+	if !cfg.Filtered() {
+		t.Error("Filtered() = false after dropping rules")
+	}
+
+	cfg = Default()
+	cfg.FilterRules([]string{"all"})
+	if len(cfg.Rules) != 4 || cfg.Filtered() {
+		t.Errorf("FilterRules(all) kept %d rules, filtered %v", len(cfg.Rules), cfg.Filtered())
+	}
+}
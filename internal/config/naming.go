@@ -0,0 +1,152 @@
+package config
+
+import (
+	"bytes"
+	"fmt"
+	"net/mail"
+	"strings"
+	"text/template"
+
+	"github.com/matthewdavidson09/dynamic-distro-groups/tools"
+)
+
+// maxCNLength is the AD upper bound for the cn attribute.
+const maxCNLength = 64
+
+// Default naming templates. Each template can use the fields of NameData, including names rendered before it
+// (CN, then SAMAccountName, Mail, DisplayName, Description, GoogleName).
+const (
+	DefaultCNTemplate          = "list-{{ .Category }}-{{ .Slug }}"
+	DefaultSAMTemplate         = "{{ .CN }}"
+	DefaultMailTemplate        = "{{ .CN }}@{{ .Domain }}"
+	DefaultDisplayNameTemplate = "{{ .Label }}"
+	DefaultDescriptionTemplate = "{{ .DisplayName }} distro group"
+	DefaultGoogleNameTemplate  = "{{ .DisplayName }}"
+)
+
+// TemplateFuncs are available in naming templates.
+var TemplateFuncs = template.FuncMap{
+	"slug":  tools.Slugify,
+	"lower": strings.ToLower,
+	"upper": strings.ToUpper,
+	"trim":  strings.TrimSpace,
+}
+
+// NameData is the input to naming templates.
+type NameData struct {
+	RuleID   string
+	Category string
+	Value    string            // grouping value
+	Slug     string            // slugified Value
+	Label    string            // human-readable value
+	Values   map[string]string // grouping attribute -> value
+	Domain   string            // GROUP_EMAIL_DOMAIN
+
+	// Filled in as each name is rendered.
+	CN             string
+	SAMAccountName string
+	Mail           string
+	DisplayName    string
+	Description    string
+}
+
+// GroupNames are the rendered names of one group. Mail is the only source of the group's email address,
+// in both AD and Google.
+type GroupNames struct {
+	CN             string
+	SAMAccountName string
+	Mail           string
+	DisplayName    string
+	Description    string
+	GoogleName     string
+}
+
+// Render executes the naming templates in order and validates the result.
+func (n Naming) Render(data NameData) (GroupNames, error) {
+	var names GroupNames
+	steps := []struct {
+		field string
+		tmpl  string
+		out   *string
+		feed  *string
+	}{
+		{"cn", n.CN, &names.CN, &data.CN},
+		{"sam_account_name", n.SAMAccountName, &names.SAMAccountName, &data.SAMAccountName},
+		{"mail", n.Mail, &names.Mail, &data.Mail},
+		{"display_name", n.DisplayName, &names.DisplayName, &data.DisplayName},
+		{"description", n.Description, &names.Description, &data.Description},
+		{"google_name", n.GoogleName, &names.GoogleName, nil},
+	}
+
+	for _, step := range steps {
+		value, err := execTemplate(step.field, step.tmpl, data)
+		if err != nil {
+			return GroupNames{}, err
+		}
+		if step.field == "mail" {
+			value = strings.ToLower(value)
+		}
+		*step.out = value
+		if step.feed != nil {
+			*step.feed = value
+		}
+	}
+
+	if names.CN == "" {
+		return GroupNames{}, fmt.Errorf("naming.cn rendered empty")
+	}
+	if len(names.CN) > maxCNLength {
+		return GroupNames{}, fmt.Errorf("naming.cn %q is longer than %d characters", names.CN, maxCNLength)
+	}
+	if addr, err := mail.ParseAddress(names.Mail); err != nil || addr.Address != names.Mail {
+		return GroupNames{}, fmt.Errorf("naming.mail %q is not a valid email address", names.Mail)
+	}
+	return names, nil
+}
+
+func (n Naming) templates() map[string]string {
+	return map[string]string{
+		"cn":               n.CN,
+		"sam_account_name": n.SAMAccountName,
+		"mail":             n.Mail,
+		"display_name":     n.DisplayName,
+		"description":      n.Description,
+		"google_name":      n.GoogleName,
+	}
+}
+
+func (n *Naming) applyDefaults() {
+	defaults := []struct {
+		field *string
+		tmpl  string
+	}{
+		{&n.CN, DefaultCNTemplate},
+		{&n.SAMAccountName, DefaultSAMTemplate},
+		{&n.Mail, DefaultMailTemplate},
+		{&n.DisplayName, DefaultDisplayNameTemplate},
+		{&n.Description, DefaultDescriptionTemplate},
+		{&n.GoogleName, DefaultGoogleNameTemplate},
+	}
+	for _, d := range defaults {
+		if strings.TrimSpace(*d.field) == "" {
+			*d.field = d.tmpl
+		}
+	}
+}
+
+func parseTemplate(field, text string) (*template.Template, error) {
+	return template.New(field).Funcs(TemplateFuncs).Option("missingkey=zero").Parse(text)
+}
+
+func execTemplate(field, text string, data NameData) (string, error) {
+	tmpl, err := parseTemplate(field, text)
+	if err != nil {
+		return "", fmt.Errorf("naming.%s: %w", field, err)
+	}
+
+	var buf bytes.Buffer
+	if err := tmpl.Execute(&buf, data); err != nil {
+		return "", fmt.Errorf("naming.%s: %w", field, err)
+	}
+	return strings.TrimSpace(buf.String()), nil
+}
@@ -0,0 +1,121 @@
+package config
+
+import (
+	"strings"
+	"testing"
+)
+
+func defaultNaming(category string) Naming {
+	n := Naming{Category: category}
+	n.applyDefaults()
+	return n
+}
+
+func TestRenderDefaults(t *testing.T) {
+	names, err := defaultNaming("dept").Render(NameData{
+		RuleID:   "departments",
+		Category: "dept",
+		Value:    "Customer Success",
+		Slug:     "customer-success",
+		Label:    "Customer Success",
+		Domain:   "example.com",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := GroupNames{
+		CN:             "list-dept-customer-success",
+		SAMAccountName: "list-dept-customer-success",
+		Mail:           "list-dept-customer-success@example.com",
+		DisplayName:    "Customer Success",
+		Description:    "Customer Success distro group",
+		GoogleName:     "Customer Success",
+	}
+	if names != want {
+		t.Errorf("Render() = %+v, want %+v", names, want)
+	}
+}
+
+func TestRenderChainsNames(t *testing.T) {
+	n := Naming{
+		CN:             "{{ .Category }}-{{ .Values.department | slug }}-{{ .Values.state | lower }}",
+		SAMAccountName: "{{ .CN | upper }}",
+		Mail:           "  {{ .SAMAccountName }}@Example.COM ",
+		DisplayName:    "{{ .Values.department }} ({{ .Values.state }})",
+		Description:    "Members of {{ .DisplayName }}, mailed at {{ .Mail }}",
+		GoogleName:     "{{ .DisplayName }} [{{ .RuleID }}]",
+	}
+
+	names, err := n.Render(NameData{
+		RuleID:   "dept-state",
+		Category: "ds",
+		Values:   map[string]string{"department": "R&D Labs", "state": "TX"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := GroupNames{
+		CN:             "ds-rd-labs-tx",
+		SAMAccountName: "DS-RD-LABS-TX",
+		Mail:           "ds-rd-labs-tx@example.com",
+		DisplayName:    "R&D Labs (TX)",
+		Description:    "Members of R&D Labs (TX), mailed at ds-rd-labs-tx@example.com",
+		GoogleName:     "R&D Labs (TX) [dept-state]",
+	}
+	if names != want {
+		t.Errorf("Render() = %+v, want %+v", names, want)
+	}
+}
+
+func TestRenderErrors(t *testing.T) {
+	tests := []struct {
+		name    string
+		naming  Naming
+		data    NameData
+		wantErr string
+	}{
+		{
+			name:    "empty cn",
+			naming:  Naming{CN: "{{ .Values.missing }}", Mail: "x@example.com"},
+			wantErr: "naming.cn rendered empty",
+		},
+		{
+			name:    "cn too long",
+			naming:  Naming{CN: "{{ .Value }}", Mail: "x@example.com"},
+			data:    NameData{Value: strings.Repeat("a", maxCNLength+1)},
+			wantErr: "longer than 64 characters",
+		},
+		{
+			name:    "mail without domain",
+			naming:  defaultNaming("dept"),
+			data:    NameData{Category: "dept", Slug: "sales"},
+			wantErr: `naming.mail "list-dept-sales@" is not a valid email address`,
+		},
+		{
+			name:    "mail with a display name",
+			naming:  Naming{CN: "sales", Mail: "Sales <sales@example.com>"},
+			wantErr: "is not a valid email address",
+		},
+		{
+			name:    "template error",
+			naming:  Naming{CN: "{{ .Value | nosuchfunc }}"},
+			wantErr: "naming.cn:",
+		},
+		{
+			name:    "execution error",
+			naming:  Naming{CN: "sales", Mail: "{{ .CN.Foo }}@example.com"},
+			wantErr: "naming.mail:",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := tt.naming.Render(tt.data)
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Fatalf("Render() = %v, want error containing %q", err, tt.wantErr)
+			}
+		})
+	}
+}
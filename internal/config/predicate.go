@@ -0,0 +1,140 @@
+package config
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/matthewdavidson09/dynamic-distro-groups/internal/active_directory"
+)
+
+// Predicate is a boolean condition over a user's attributes. Exactly one of All, Any, Not or Attr is set;
+// a leaf (Attr) takes exactly one comparison. String comparisons are case-insensitive and trimmed.
+//
+//	where:
+//	  all:
+//	    - { attr: department, equals: Sales }
+//	    - { attr: state, in: [CA, NV] }
+//	    - not: { attr: title, contains: Intern }
+type Predicate struct {
+	All []Predicate `yaml:"all" json:"all"`
+	Any []Predicate `yaml:"any" json:"any"`
+	Not *Predicate  `yaml:"not" json:"not"`
+
+	Attr     string   `yaml:"attr" json:"attr"`
+	Equals   *string  `yaml:"equals" json:"equals"`
+	In       []string `yaml:"in" json:"in"`
+	Contains *string  `yaml:"contains" json:"contains"`
+	Present  *bool    `yaml:"present" json:"present"` // true: attribute has a value; false: it is empty
+}
+
+// Matches evaluates the predicate against a user.
+func (p *Predicate) Matches(u active_directory.ADUser) bool {
+	switch {
+	case len(p.All) > 0:
+		for i := range p.All {
+			if !p.All[i].Matches(u) {
+				return false
+			}
+		}
+		return true
+
+	case len(p.Any) > 0:
+		for i := range p.Any {
+			if p.Any[i].Matches(u) {
+				return true
+			}
+		}
+		return false
+
+	case p.Not != nil:
+		return !p.Not.Matches(u)
+	}
+
+	values := u.AttributeValues(p.Attr)
+	switch {
+	case p.Present != nil:
+		return (len(values) > 0) == *p.Present
+	case p.Equals != nil:
+		return anyValue(values, func(v string) bool { return strings.EqualFold(v, strings.TrimSpace(*p.Equals)) })
+	case p.Contains != nil:
+		needle := strings.ToLower(strings.TrimSpace(*p.Contains))
+		return anyValue(values, func(v string) bool { return strings.Contains(strings.ToLower(v), needle) })
+	case p.In != nil:
+		return anyValue(values, func(v string) bool {
+			for _, want := range p.In {
+				if strings.EqualFold(v, strings.TrimSpace(want)) {
+					return true
+				}
+			}
+			return false
+		})
+	}
+	return false
+}
+
+// Attributes returns every attribute the predicate reads.
+func (p *Predicate) Attributes() []string {
+	var attrs []string
+	if p.Attr != "" {
+		attrs = append(attrs, p.Attr)
+	}
+	for i := range p.All {
+		attrs = append(attrs, p.All[i].Attributes()...)
+	}
+	for i := range p.Any {
+		attrs = append(attrs, p.Any[i].Attributes()...)
+	}
+	if p.Not != nil {
+		attrs = append(attrs, p.Not.Attributes()...)
+	}
+	return attrs
+}
+
+// Validate checks the predicate is well-formed. path locates it in error messages, e.g. "where.all[1]".
+func (p *Predicate) Validate(path string) error {
+	nodes := 0
+	for _, set := range []bool{len(p.All) > 0, len(p.Any) > 0, p.Not != nil, p.Attr != ""} {
+		if set {
+			nodes++
+		}
+	}
+	if nodes != 1 {
+		return fmt.Errorf("%s: exactly one of all, any, not or attr is required", path)
+	}
+
+	for i := range p.All {
+		if err := p.All[i].Validate(fmt.Sprintf("%s.all[%d]", path, i)); err != nil {
+			return err
+		}
+	}
+	for i := range p.Any {
+		if err := p.Any[i].Validate(fmt.Sprintf("%s.any[%d]", path, i)); err != nil {
+			return err
+		}
+	}
+	if p.Not != nil {
+		return p.Not.Validate(path + ".not")
+	}
+
+	if p.Attr != "" {
+		ops := 0
+		for _, set := range []bool{p.Equals != nil, p.In != nil, p.Contains != nil, p.Present != nil} {
+			if set {
+				ops++
+			}
+		}
+		if ops != 1 {
+			return fmt.Errorf("%s: attr %q needs exactly one of equals, in, contains or present", path, p.Attr)
+		}
+	}
+	return nil
+}
+
+func anyValue(values []string, match func(string) bool) bool {
+	for _, v := range values {
+		if match(strings.TrimSpace(v)) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,81 @@
+package config
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/matthewdavidson09/dynamic-distro-groups/internal/active_directory"
+	"gopkg.in/yaml.v3"
+)
+
+func parsePredicate(t *testing.T, text string) *Predicate {
+	t.Helper()
+	var p Predicate
+	if err := yaml.Unmarshal([]byte(text), &p); err != nil {
+		t.Fatal(err)
+	}
+	return &p
+}
+
+func TestPredicateMatches(t *testing.T) {
+	user := active_directory.ADUser{
+		Department: "Sales",
+		State:      " tx ",
+		Title:      "Senior Account Executive",
+		Attributes: map[string][]string{"memberof": {"CN=VPN,DC=example,DC=com", "CN=Staff,DC=example,DC=com"}},
+	}
+
+	tests := []struct {
+		where string
+		want  bool
+	}{
+		{`{attr: department, equals: sales}`, true},
+		{`{attr: department, equals: Engineering}`, false},
+		{`{attr: state, in: [CA, TX]}`, true},
+		{`{attr: title, contains: account}`, true},
+		{`{attr: memberOf, contains: cn=vpn}`, true},
+		{`{attr: employeeID, present: false}`, true},
+		{`{attr: department, present: true}`, true},
+		{`{not: {attr: title, contains: Intern}}`, true},
+		{`{all: [{attr: department, equals: Sales}, {attr: state, equals: CA}]}`, false},
+		{`{any: [{attr: department, equals: HR}, {attr: state, equals: TX}]}`, true},
+		{`{all: [{attr: department, equals: Sales}, {not: {any: [{attr: state, equals: CA}, {attr: title, contains: Intern}]}}]}`, true},
+	}
+	for _, tt := range tests {
+		if got := parsePredicate(t, tt.where).Matches(user); got != tt.want {
+			t.Errorf("%s matched %v, want %v", tt.where, got, tt.want)
+		}
+	}
+}
+
+func TestPredicateValidate(t *testing.T) {
+	tests := []struct {
+		where   string
+		wantErr string // empty when valid
+	}{
+		{`{attr: department, equals: Sales}`, ""},
+		{`{any: [{attr: state, in: [TX]}, {not: {attr: title, present: true}}]}`, ""},
+		{`{}`, "where: exactly one of all, any, not or attr"},
+		{`{attr: department, all: [{attr: state, equals: TX}]}`, "where: exactly one of all, any, not or attr"},
+		{`{attr: department}`, `where: attr "department" needs exactly one of`},
+		{`{attr: department, equals: Sales, contains: S}`, `where: attr "department" needs exactly one of`},
+		{`{all: [{attr: state, equals: TX}, {attr: title}]}`, `where.all[1]: attr "title"`},
+		{`{not: {any: [{}]}}`, "where.not.any[0]: exactly one of"},
+	}
+	for _, tt := range tests {
+		err := parsePredicate(t, tt.where).Validate("where")
+		switch {
+		case tt.wantErr == "" && err != nil:
+			t.Errorf("%s: Validate() = %v, want nil", tt.where, err)
+		case tt.wantErr != "" && (err == nil || !strings.Contains(err.Error(), tt.wantErr)):
+			t.Errorf("%s: Validate() = %v, want error containing %q", tt.where, err, tt.wantErr)
+		}
+	}
+}
+
+func TestPredicateAttributes(t *testing.T) {
+	p := parsePredicate(t, `{all: [{attr: department, equals: Sales}, {not: {any: [{attr: state, in: [CA]}, {attr: title, present: true}]}}]}`)
+	if got := strings.Join(p.Attributes(), ","); got != "department,state,title" {
+		t.Errorf("Attributes() = %s, want department,state,title", got)
+	}
+}
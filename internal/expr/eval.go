@@ -0,0 +1,339 @@
+package expr
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+// maxManagerDepth bounds manager-chain walks so a cycle in AD cannot hang evaluation.
+const maxManagerDepth = 50
+
+// eval returns one of: bool, int, string or []string.
+func eval(n node, env Env) (any, error) {
+	switch v := n.(type) {
+	case *stringNode:
+		return v.val, nil
+	case *numberNode:
+		return v.val, nil
+	case *boolNode:
+		return v.val, nil
+
+	case *listNode:
+		var items []string
+		for _, item := range v.items {
+			val, err := eval(item, env)
+			if err != nil {
+				return nil, err
+			}
+			strs, ok := toStrings(val)
+			if !ok {
+				return nil, &Error{Pos: item.pos(), Msg: "list items must be strings"}
+			}
+			items = append(items, strs...)
+		}
+		return items, nil
+
+	case *identNode:
+		cur := env
+		// The parser guarantees every segment before the attribute is "manager".
+		for range v.path[:len(v.path)-1] {
+			mgr, ok := cur.Manager()
+			if !ok {
+				return []string(nil), nil
+			}
+			cur = mgr
+		}
+		return cur.AttributeValues(v.path[len(v.path)-1]), nil
+
+	case *unaryNode:
+		b, err := evalBool(v.arg, env)
+		if err != nil {
+			return nil, err
+		}
+		return !b, nil
+
+	case *binaryNode:
+		return evalBinary(v, env)
+
+	case *callNode:
+		return evalCall(v, env)
+	}
+
+	return nil, &Error{Pos: n.pos(), Msg: fmt.Sprintf("cannot evaluate %T", n)}
+}
+
+func evalBool(n node, env Env) (bool, error) {
+	v, err := eval(n, env)
+	if err != nil {
+		return false, err
+	}
+	b, ok := v.(bool)
+	if !ok {
+		return false, &Error{Pos: n.pos(), Msg: "expected a condition"}
+	}
+	return b, nil
+}
+
+func evalBinary(n *binaryNode, env Env) (any, error) {
+	switch n.op {
+	case "&&", "||":
+		left, err := evalBool(n.left, env)
+		if err != nil {
+			return nil, err
+		}
+		if (n.op == "&&" && !left) || (n.op == "||" && left) {
+			return left, nil
+		}
+		return evalBool(n.right, env)
+	}
+
+	left, err := eval(n.left, env)
+	if err != nil {
+		return nil, err
+	}
+	right, err := eval(n.right, env)
+	if err != nil {
+		return nil, err
+	}
+
+	switch n.op {
+	case "==", "!=":
+		eq, err := equal(n, left, right)
+		if err != nil {
+			return nil, err
+		}
+		return eq == (n.op == "=="), nil
+
+	case "in":
+		needles, ok1 := toStrings(left)
+		haystack, ok2 := toStrings(right)
+		if !ok1 || !ok2 {
+			return nil, &Error{Pos: n.p, Msg: "in needs strings or lists on both sides"}
+		}
+		for _, a := range needles {
+			for _, b := range haystack {
+				if strings.EqualFold(strings.TrimSpace(a), strings.TrimSpace(b)) {
+					return true, nil
+				}
+			}
+		}
+		return false, nil
+
+	case "=~", "!~":
+		re := n.re
+		if re == nil {
+			pattern, ok := right.(string)
+			if !ok {
+				return nil, &Error{Pos: n.right.pos(), Msg: "regular expression must be a string"}
+			}
+			if re, err = regexp.Compile(pattern); err != nil {
+				return nil, &Error{Pos: n.right.pos(), Msg: fmt.Sprintf("invalid regular expression: %v", err)}
+			}
+		}
+		matched, err := anyString(n.left, left, re.MatchString)
+		if err != nil {
+			return nil, err
+		}
+		return matched == (n.op == "=~"), nil
+
+	case "<", "<=", ">", ">=":
+		a, ok1 := toInt(left)
+		b, ok2 := toInt(right)
+		if !ok1 || !ok2 {
+			return nil, &Error{Pos: n.p, Msg: fmt.Sprintf("%s needs numbers on both sides", n.op)}
+		}
+		switch n.op {
+		case "<":
+			return a < b, nil
+		case "<=":
+			return a <= b, nil
+		case ">":
+			return a > b, nil
+		}
+		return a >= b, nil
+	}
+
+	return nil, &Error{Pos: n.p, Msg: fmt.Sprintf("unknown operator %q", n.op)}
+}
+
+func equal(n *binaryNode, left, right any) (bool, error) {
+	lb, lIsBool := left.(bool)
+	rb, rIsBool := right.(bool)
+	if lIsBool || rIsBool {
+		if !lIsBool || !rIsBool {
+			return false, &Error{Pos: n.p, Msg: "cannot compare a condition with a value"}
+		}
+		return lb == rb, nil
+	}
+
+	_, lIsInt := left.(int)
+	_, rIsInt := right.(int)
+	if lIsInt || rIsInt {
+		a, ok1 := toInt(left)
+		b, ok2 := toInt(right)
+		return ok1 && ok2 && a == b, nil
+	}
+
+	as, _ := toStrings(left)
+	bs, _ := toStrings(right)
+	// An empty attribute equals "".
+	if len(as) == 0 {
+		as = []string{""}
+	}
+	if len(bs) == 0 {
+		bs = []string{""}
+	}
+	for _, a := range as {
+		for _, b := range bs {
+			if strings.EqualFold(strings.TrimSpace(a), strings.TrimSpace(b)) {
+				return true, nil
+			}
+		}
+	}
+	return false, nil
+}
+
+func evalCall(n *callNode, env Env) (any, error) {
+	if n.name == "has" {
+		values, err := eval(n.args[0], env)
+		if err != nil {
+			return nil, err
+		}
+		strs, _ := toStrings(values)
+		for _, v := range strs {
+			if strings.TrimSpace(v) != "" {
+				return true, nil
+			}
+		}
+		return false, nil
+	}
+
+	args := make([]any, len(n.args))
+	for i, arg := range n.args {
+		v, err := eval(arg, env)
+		if err != nil {
+			return nil, err
+		}
+		args[i] = v
+	}
+
+	switch n.name {
+	case "lower", "upper", "trim":
+		fn := map[string]func(string) string{"lower": strings.ToLower, "upper": strings.ToUpper, "trim": strings.TrimSpace}[n.name]
+		switch v := args[0].(type) {
+		case string:
+			return fn(v), nil
+		case []string:
+			out := make([]string, len(v))
+			for i, s := range v {
+				out[i] = fn(s)
+			}
+			return out, nil
+		}
+		return nil, &Error{Pos: n.args[0].pos(), Msg: fmt.Sprintf("%s() needs a string", n.name)}
+
+	case "contains", "startsWith", "endsWith":
+		sub, ok := args[1].(string)
+		if !ok {
+			return nil, &Error{Pos: n.args[1].pos(), Msg: fmt.Sprintf("%s() needs a string as its second argument", n.name)}
+		}
+		sub = strings.ToLower(sub)
+		test := map[string]func(string, string) bool{"contains": strings.Contains, "startsWith": strings.HasPrefix, "endsWith": strings.HasSuffix}[n.name]
+		return anyString(n.args[0], args[0], func(s string) bool { return test(strings.ToLower(strings.TrimSpace(s)), sub) })
+
+	case "matches":
+		re := n.re
+		if re == nil {
+			pattern, ok := args[1].(string)
+			if !ok {
+				return nil, &Error{Pos: n.args[1].pos(), Msg: "matches() needs a string pattern"}
+			}
+			var err error
+			if re, err = regexp.Compile(pattern); err != nil {
+				return nil, &Error{Pos: n.args[1].pos(), Msg: fmt.Sprintf("invalid regular expression: %v", err)}
+			}
+		}
+		return anyString(n.args[0], args[0], re.MatchString)
+
+	case "len":
+		switch v := args[0].(type) {
+		case string:
+			return utf8.RuneCountInString(v), nil
+		case []string:
+			return len(v), nil
+		}
+		return nil, &Error{Pos: n.args[0].pos(), Msg: "len() needs a string or list"}
+
+	case "reportsTo":
+		id, ok := args[0].(string)
+		if !ok {
+			return nil, &Error{Pos: n.args[0].pos(), Msg: "reportsTo() needs a string"}
+		}
+		return reportsTo(env, strings.TrimSpace(id)), nil
+	}
+
+	return nil, &Error{Pos: n.p, Msg: fmt.Sprintf("unknown function %q", n.name)}
+}
+
+// reportsTo walks up the manager chain looking for a manager identified by id.
+func reportsTo(env Env, id string) bool {
+	cur := env
+	for depth := 0; depth < maxManagerDepth; depth++ {
+		mgr, ok := cur.Manager()
+		if !ok {
+			return false
+		}
+		for _, attr := range []string{"sAMAccountName", "mail", "distinguishedName"} {
+			for _, v := range mgr.AttributeValues(attr) {
+				if strings.EqualFold(strings.TrimSpace(v), id) {
+					return true
+				}
+			}
+		}
+		cur = mgr
+	}
+	return false
+}
+
+func anyString(n node, v any, match func(string) bool) (bool, error) {
+	strs, ok := toStrings(v)
+	if !ok {
+		return false, &Error{Pos: n.pos(), Msg: "expected a string or attribute"}
+	}
+	for _, s := range strs {
+		if match(s) {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+func toStrings(v any) ([]string, bool) {
+	switch v := v.(type) {
+	case string:
+		return []string{v}, true
+	case []string:
+		return v, true
+	case int:
+		return []string{strconv.Itoa(v)}, true
+	}
+	return nil, false
+}
+
+func toInt(v any) (int, bool) {
+	switch v := v.(type) {
+	case int:
+		return v, true
+	case string:
+		n, err := strconv.Atoi(strings.TrimSpace(v))
+		return n, err == nil
+	case []string:
+		if len(v) == 1 {
+			return toInt(v[0])
+		}
+	}
+	return 0, false
+}
@@ -0,0 +1,127 @@
+// Package expr implements the membership expression language used by group rules, e.g.
+//
+//	department == "Sales" && state in ["CA", "NV"] && !("CONTRACTOR" in employeeType)
+//
+// Identifiers name user attributes and always evaluate to the list of the attribute's values;
+// `manager.<attr>` follows the manager chain. Comparisons against lists are true when any value
+// matches, and string equality is case-insensitive. Supported operators are ==, !=, in, =~, !~
+// (regex), <, <=, >, >= (numbers), !, && and ||. Functions:
+//
+//	lower(s) upper(s) trim(s)                   string transforms
+//	contains(s, sub) startsWith(s, p) endsWith(s, p)  case-insensitive string tests
+//	matches(s, re)                              regex match, same as s =~ re
+//	has(attr)                                   attribute has a non-empty value
+//	len(x)                                      number of values of a list, or length of a string
+//	reportsTo(id)                               id (sAMAccountName, mail or DN) is anywhere in the manager chain
+package expr
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Pos is a 1-based position in the expression source.
+type Pos struct {
+	Line int
+	Col  int
+}
+
+func (p Pos) String() string {
+	return fmt.Sprintf("line %d, col %d", p.Line, p.Col)
+}
+
+// Error is a compile or evaluation error at a position in the source.
+type Error struct {
+	Pos Pos
+	Msg string
+	Src string // full source, used to point at the failing position
+}
+
+func (e *Error) Error() string {
+	if e.Src == "" {
+		return fmt.Sprintf("%s: %s", e.Pos, e.Msg)
+	}
+
+	lines := strings.Split(e.Src, "\n")
+	if e.Pos.Line < 1 || e.Pos.Line > len(lines) {
+		return fmt.Sprintf("%s: %s", e.Pos, e.Msg)
+	}
+	return fmt.Sprintf("%s: %s\n    %s\n    %s^", e.Pos, e.Msg, lines[e.Pos.Line-1], strings.Repeat(" ", e.Pos.Col-1))
+}
+
+// Env exposes the user an expression is evaluated against.
+type Env interface {
+	// AttributeValues returns every value of the named attribute.
+	AttributeValues(name string) []string
+	// Manager returns the user's manager, if known.
+	Manager() (Env, bool)
+}
+
+// Program is a compiled expression.
+type Program struct {
+	src  string
+	root node
+}
+
+// Compile parses and checks an expression. The result must be boolean.
+func Compile(src string) (*Program, error) {
+	tokens, err := lex(src)
+	if err != nil {
+		return nil, withSource(err, src)
+	}
+
+	p := &parser{tokens: tokens}
+	root, err := p.parseExpr()
+	if err == nil && p.peek().kind != tokEOF {
+		err = &Error{Pos: p.peek().pos, Msg: fmt.Sprintf("unexpected %s", p.peek())}
+	}
+	if err == nil {
+		err = check(root)
+	}
+	if err != nil {
+		return nil, withSource(err, src)
+	}
+
+	return &Program{src: src, root: root}, nil
+}
+
+// String returns the expression source.
+func (p *Program) String() string {
+	return p.src
+}
+
+// Eval evaluates the expression against env.
+func (p *Program) Eval(env Env) (bool, error) {
+	v, err := eval(p.root, env)
+	if err != nil {
+		return false, withSource(err, p.src)
+	}
+	b, ok := v.(bool)
+	if !ok {
+		return false, withSource(&Error{Pos: p.root.pos(), Msg: "expression does not evaluate to true/false"}, p.src)
+	}
+	return b, nil
+}
+
+// Attributes returns the attribute names the expression reads, including those read through manager.<attr>.
+func (p *Program) Attributes() []string {
+	seen := make(map[string]bool)
+	var attrs []string
+	walk(p.root, func(n node) {
+		if id, ok := n.(*identNode); ok {
+			name := id.path[len(id.path)-1]
+			if !seen[strings.ToLower(name)] {
+				seen[strings.ToLower(name)] = true
+				attrs = append(attrs, name)
+			}
+		}
+	})
+	return attrs
+}
+
+func withSource(err error, src string) error {
+	if e, ok := err.(*Error); ok && e.Src == "" {
+		e.Src = src
+	}
+	return err
+}
@@ -0,0 +1,235 @@
+package expr
+
+import (
+	"strings"
+	"testing"
+)
+
+// testEnv is a user given as attribute values, with an optional manager.
+type testEnv struct {
+	attrs   map[string][]string
+	manager *testEnv
+}
+
+func (e *testEnv) AttributeValues(name string) []string {
+	return e.attrs[strings.ToLower(name)]
+}
+
+func (e *testEnv) Manager() (Env, bool) {
+	if e.manager == nil {
+		return nil, false
+	}
+	return e.manager, true
+}
+
+func testUser() *testEnv {
+	ceo := &testEnv{attrs: map[string][]string{
+		"samaccountname": {"ceo"},
+		"mail":           {"ceo@example.com"},
+		"department":     {"Executive"},
+	}}
+	vp := &testEnv{manager: ceo, attrs: map[string][]string{
+		"samaccountname": {"vp"},
+		"department":     {"Sales"},
+	}}
+	return &testEnv{manager: vp, attrs: map[string][]string{
+		"samaccountname": {"jdoe"},
+		"department":     {"Sales"},
+		"state":          {"TX"},
+		"title":          {"Senior Account Executive"},
+		"employeetype":   {"FTE", "Remote"},
+		"employeeid":     {"1042"},
+		"memberof":       {"CN=VPN,OU=Groups", "CN=All Staff,OU=Groups"},
+		"description":    {`say "hi" it's me`},
+	}}
+}
+
+func TestEval(t *testing.T) {
+	tests := []struct {
+		src  string
+		want bool
+	}{
+		// equality is case-insensitive and any value of a list matches
+		{`department == "sales"`, true},
+		{`department != "Sales"`, false},
+		{`employeeType == "remote"`, true},
+		{`extensionAttribute1 == ""`, true},
+
+		// precedence: comparisons bind tighter than !, then &&, then ||
+		{`department == "HR" && state == "TX" || title == "Senior Account Executive"`, true},
+		{`department == "HR" && (state == "TX" || title == "Senior Account Executive")`, false},
+		{`department == "Sales" || state == "CA" && title == "Nobody"`, true},
+		{`!department == "HR"`, true},
+		{`!(department == "HR")`, true},
+		{`!!(state == "TX")`, true},
+
+		// quoting
+		{`state == 'TX'`, true},
+		{`description == "say \"hi\" it's me"`, true},
+		{`description == 'say "hi" it\'s me'`, true},
+
+		// in
+		{`state in ["CA", "tx"]`, true},
+		{`state in ["CA", "NV"]`, false},
+		{`"REMOTE" in employeeType`, true},
+		{`employeeType in ["contractor", "fte"]`, true},
+		{`!("CONTRACTOR" in employeeType)`, true},
+		{`state in []`, false},
+
+		// regular expressions
+		{`title =~ "^Senior "`, true},
+		{`title =~ "^senior "`, false},
+		{`title =~ "(?i)^senior "`, true},
+		{`title !~ "Intern"`, true},
+		{`memberOf =~ "^CN=VPN,"`, true},
+		{`matches(title, "Account\\s+Exec")`, true},
+		{`matches(state, "^(CA|NV)$")`, false},
+		{`matches(state, trim(" ^T "))`, true},
+		{`matches(state, lower("^T"))`, false},
+
+		// numbers
+		{`employeeID >= 1000 && employeeID < 2000`, true},
+		{`len(employeeType) == 2`, true},
+		{`len(state) > 2`, false},
+
+		// functions
+		{`contains(title, "account")`, true},
+		{`startsWith(title, "SENIOR")`, true},
+		{`endsWith(memberOf, "ou=groups")`, true},
+		{`has(state) && !has(extensionAttribute1)`, true},
+		{`lower(state) == "tx" && upper(department) == "SALES"`, true},
+
+		// manager chain
+		{`manager.department == "Sales"`, true},
+		{`manager.manager.department == "Executive"`, true},
+		{`manager.manager.manager.department == ""`, true},
+		{`reportsTo("ceo@example.com")`, true},
+		{`reportsTo("vp") && !reportsTo("jdoe")`, true},
+	}
+
+	env := testUser()
+	for _, tt := range tests {
+		prog, err := Compile(tt.src)
+		if err != nil {
+			t.Errorf("Compile(%s) = %v", tt.src, err)
+			continue
+		}
+		got, err := prog.Eval(env)
+		if err != nil {
+			t.Errorf("Eval(%s) = %v", tt.src, err)
+			continue
+		}
+		if got != tt.want {
+			t.Errorf("Eval(%s) = %v, want %v", tt.src, got, tt.want)
+		}
+	}
+}
+
+func TestEvalErrors(t *testing.T) {
+	tests := []struct {
+		src     string
+		wantErr string
+	}{
+		{`matches(title, state)`, "matches() needs a string pattern"},
+		{`title =~ employeeType`, "regular expression must be a string"},
+		{`matches(title, trim("("))`, "invalid regular expression"},
+		{`title =~ upper("[z-a]")`, "invalid regular expression"},
+		{`state < 10`, "< needs numbers on both sides"},
+		{`lower(has(state)) == "true"`, "lower() needs a string"},
+	}
+
+	env := testUser()
+	for _, tt := range tests {
+		prog, err := Compile(tt.src)
+		if err == nil {
+			_, err = prog.Eval(env)
+		}
+		if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+			t.Errorf("%s: %v, want error containing %q", tt.src, err, tt.wantErr)
+		}
+	}
+}
+
+func TestCompileErrors(t *testing.T) {
+	tests := []struct {
+		src     string
+		wantPos Pos
+		wantMsg string
+	}{
+		{`department ==`, Pos{1, 14}, "unexpected end of expression"},
+		{`department == "Sales`, Pos{1, 15}, "unterminated string literal"},
+		{`department == "Sales" &&`, Pos{1, 25}, "unexpected end of expression"},
+		{`(state == "TX"`, Pos{1, 15}, `expected ")"`},
+		{`state in ["TX" "CA"]`, Pos{1, 16}, `expected "]"`},
+		{`state == "TX" state`, Pos{1, 15}, `unexpected "state"`},
+		{`department`, Pos{1, 1}, "expression must be a condition"},
+		{`department && state == "TX"`, Pos{1, 1}, "operand of && must be a condition"},
+		{`!state`, Pos{1, 2}, "operand of ! must be a condition"},
+		{`state == "TX" @`, Pos{1, 15}, `unexpected character '@'`},
+		{`title =~ "(unclosed"`, Pos{1, 10}, "invalid regular expression"},
+		{`matches(title, "[z-a]")`, Pos{1, 16}, "invalid regular expression"},
+		{`shout(title)`, Pos{1, 1}, `unknown function "shout"`},
+		{`contains(title)`, Pos{1, 1}, "contains() takes 2 argument(s), got 1"},
+		{`has("state")`, Pos{1, 5}, "has() takes an attribute name"},
+		{`department.name == "x"`, Pos{1, 1}, `only manager can be followed by "."`},
+		{"state == \"TX\" &&\n  in", Pos{2, 3}, `unexpected "in"`},
+	}
+
+	for _, tt := range tests {
+		_, err := Compile(tt.src)
+		e, ok := err.(*Error)
+		if !ok {
+			t.Errorf("Compile(%q) = %v, want *Error", tt.src, err)
+			continue
+		}
+		if e.Pos != tt.wantPos || !strings.Contains(e.Msg, tt.wantMsg) {
+			t.Errorf("Compile(%q) = %s: %s, want %s: %s", tt.src, e.Pos, e.Msg, tt.wantPos, tt.wantMsg)
+		}
+	}
+}
+
+func TestErrorPointsAtSource(t *testing.T) {
+	_, err := Compile("department == \"Sales\" &&\n  state ==")
+	want := "line 2, col 11: unexpected end of expression\n      state ==\n              ^"
+	if err == nil || err.Error() != want {
+		t.Errorf("Compile() error =\n%v\nwant\n%s", err, want)
+	}
+}
+
+func TestRegexpsCompiledOnce(t *testing.T) {
+	prog, err := Compile(`title =~ "^Senior" && matches(state, "^T") && matches(title, lower("^S"))`)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var literal, dynamic int
+	walk(prog.root, func(n node) {
+		switch v := n.(type) {
+		case *binaryNode:
+			if v.op == "=~" && v.re != nil {
+				literal++
+			}
+		case *callNode:
+			if v.name == "matches" {
+				if v.re != nil {
+					literal++
+				} else {
+					dynamic++
+				}
+			}
+		}
+	})
+	if literal != 2 || dynamic != 1 {
+		t.Errorf("precompiled %d literal patterns and left %d dynamic, want 2 and 1", literal, dynamic)
+	}
+}
+
+func TestAttributes(t *testing.T) {
+	prog, err := Compile(`department == "Sales" && (manager.Department == "Exec" || has(state)) && matches(title, "x")`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := strings.Join(prog.Attributes(), ","); got != "department,state,title" {
+		t.Errorf("Attributes() = %s, want department,state,title", got)
+	}
+}
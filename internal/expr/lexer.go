@@ -0,0 +1,125 @@
+package expr
+
+import (
+	"fmt"
+	"strings"
+	"unicode"
+)
+
+type tokenKind int
+
+const (
+	tokEOF tokenKind = iota
+	tokIdent
+	tokString
+	tokNumber
+	tokOp // operators and punctuation
+)
+
+type token struct {
+	kind tokenKind
+	text string // identifier/operator text, or the unquoted string literal
+	pos  Pos
+}
+
+func (t token) String() string {
+	switch t.kind {
+	case tokEOF:
+		return "end of expression"
+	case tokString:
+		return fmt.Sprintf("string %q", t.text)
+	}
+	return fmt.Sprintf("%q", t.text)
+}
+
+// operators are matched longest first.
+var operators = []string{"&&", "||", "==", "!=", "=~", "!~", "<=", ">=", "<", ">", "!", "(", ")", "[", "]", ",", "."}
+
+// lex splits the source into tokens, ending with a tokEOF.
+func lex(src string) ([]token, error) {
+	var tokens []token
+	line, col := 1, 1
+	runes := []rune(src)
+
+	advance := func(n int) {
+		for i := 0; i < n; i++ {
+			if runes[0] == '\n' {
+				line++
+				col = 1
+			} else {
+				col++
+			}
+			runes = runes[1:]
+		}
+	}
+
+	for len(runes) > 0 {
+		r := runes[0]
+		pos := Pos{Line: line, Col: col}
+
+		switch {
+		case unicode.IsSpace(r):
+			advance(1)
+
+		case r == '"' || r == '\'':
+			var sb strings.Builder
+			i := 1
+			for ; i < len(runes) && runes[i] != r; i++ {
+				if runes[i] == '\\' && i+1 < len(runes) {
+					i++
+					switch runes[i] {
+					case 'n':
+						sb.WriteRune('\n')
+					case 't':
+						sb.WriteRune('\t')
+					default:
+						sb.WriteRune(runes[i])
+					}
+					continue
+				}
+				if runes[i] == '\n' {
+					break
+				}
+				sb.WriteRune(runes[i])
+			}
+			if i >= len(runes) || runes[i] != r {
+				return nil, &Error{Pos: pos, Msg: "unterminated string literal"}
+			}
+			tokens = append(tokens, token{kind: tokString, text: sb.String(), pos: pos})
+			advance(i + 1)
+
+		case unicode.IsDigit(r):
+			i := 0
+			for i < len(runes) && unicode.IsDigit(runes[i]) {
+				i++
+			}
+			tokens = append(tokens, token{kind: tokNumber, text: string(runes[:i]), pos: pos})
+			advance(i)
+
+		case unicode.IsLetter(r) || r == '_':
+			i := 0
+			for i < len(runes) && (unicode.IsLetter(runes[i]) || unicode.IsDigit(runes[i]) || runes[i] == '_' || runes[i] == '-') {
+				i++
+			}
+			tokens = append(tokens, token{kind: tokIdent, text: string(runes[:i]), pos: pos})
+			advance(i)
+
+		default:
+			matched := ""
+			for _, op := range operators {
+				if strings.HasPrefix(string(runes[:min(len(runes), 2)]), op) {
+					matched = op
+					break
+				}
+			}
+			if matched == "" {
+				return nil, &Error{Pos: pos, Msg: fmt.Sprintf("unexpected character %q", r)}
+			}
+			tokens = append(tokens, token{kind: tokOp, text: matched, pos: pos})
+			advance(len(matched))
+		}
+	}
+
+	tokens = append(tokens, token{kind: tokEOF, pos: Pos{Line: line, Col: col}})
+	return tokens, nil
+}
@@ -0,0 +1,377 @@
+package expr
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+type node interface {
+	pos() Pos
+}
+
+type (
+	stringNode struct {
+		p   Pos
+		val string
+	}
+	numberNode struct {
+		p   Pos
+		val int
+	}
+	boolNode struct {
+		p   Pos
+		val bool
+	}
+	listNode struct {
+		p     Pos
+		items []node
+	}
+	identNode struct {
+		p    Pos
+		path []string // e.g. ["manager", "department"]
+	}
+	callNode struct {
+		p    Pos
+		name string
+		args []node
+		re   *regexp.Regexp // precompiled when the pattern of matches() is a literal
+	}
+	unaryNode struct {
+		p   Pos
+		op  string
+		arg node
+	}
+	binaryNode struct {
+		p     Pos
+		op    string
+		left  node
+		right node
+		re    *regexp.Regexp // precompiled when the right side of =~/!~ is a literal
+	}
+)
+
+func (n *stringNode) pos() Pos { return n.p }
+func (n *numberNode) pos() Pos { return n.p }
+func (n *boolNode) pos() Pos   { return n.p }
+func (n *listNode) pos() Pos   { return n.p }
+func (n *identNode) pos() Pos  { return n.p }
+func (n *callNode) pos() Pos   { return n.p }
+func (n *unaryNode) pos() Pos  { return n.p }
+func (n *binaryNode) pos() Pos { return n.p }
+
+// functions maps each builtin to its argument count.
+var functions = map[string]int{
+	"lower":      1,
+	"upper":      1,
+	"trim":       1,
+	"contains":   2,
+	"startsWith": 2,
+	"endsWith":   2,
+	"matches":    2,
+	"has":        1,
+	"len":        1,
+	"reportsTo":  1,
+}
+
+var comparisons = map[string]bool{
+	"==": true, "!=": true, "=~": true, "!~": true, "in": true,
+	"<": true, "<=": true, ">": true, ">=": true,
+}
+
+type parser struct {
+	tokens []token
+	i      int
+}
+
+func (p *parser) peek() token {
+	return p.tokens[p.i]
+}
+
+func (p *parser) next() token {
+	t := p.tokens[p.i]
+	if t.kind != tokEOF {
+		p.i++
+	}
+	return t
+}
+
+func (p *parser) isOp(text string) bool {
+	t := p.peek()
+	return t.kind == tokOp && t.text == text
+}
+
+func (p *parser) expect(text string) (token, error) {
+	t := p.next()
+	if t.kind != tokOp || t.text != text {
+		return t, &Error{Pos: t.pos, Msg: fmt.Sprintf("expected %q, found %s", text, t)}
+	}
+	return t, nil
+}
+
+// parseExpr parses: or := and ("||" and)*
+func (p *parser) parseExpr() (node, error) {
+	left, err := p.parseAnd()
+	if err != nil {
+		return nil, err
+	}
+	for p.isOp("||") {
+		op := p.next()
+		right, err := p.parseAnd()
+		if err != nil {
+			return nil, err
+		}
+		left = &binaryNode{p: op.pos, op: "||", left: left, right: right}
+	}
+	return left, nil
+}
+
+// parseAnd parses: and := not ("&&" not)*
+func (p *parser) parseAnd() (node, error) {
+	left, err := p.parseNot()
+	if err != nil {
+		return nil, err
+	}
+	for p.isOp("&&") {
+		op := p.next()
+		right, err := p.parseNot()
+		if err != nil {
+			return nil, err
+		}
+		left = &binaryNode{p: op.pos, op: "&&", left: left, right: right}
+	}
+	return left, nil
+}
+
+// parseNot parses: not := "!" not | cmp
+func (p *parser) parseNot() (node, error) {
+	if p.isOp("!") {
+		op := p.next()
+		arg, err := p.parseNot()
+		if err != nil {
+			return nil, err
+		}
+		return &unaryNode{p: op.pos, op: "!", arg: arg}, nil
+	}
+	return p.parseComparison()
+}
+
+// parseComparison parses: cmp := primary (cmpOp primary)?
+func (p *parser) parseComparison() (node, error) {
+	left, err := p.parsePrimary()
+	if err != nil {
+		return nil, err
+	}
+
+	t := p.peek()
+	isCmp := (t.kind == tokOp && comparisons[t.text]) || (t.kind == tokIdent && t.text == "in")
+	if !isCmp {
+		return left, nil
+	}
+	p.next()
+
+	right, err := p.parsePrimary()
+	if err != nil {
+		return nil, err
+	}
+
+	bin := &binaryNode{p: t.pos, op: t.text, left: left, right: right}
+	if bin.op == "=~" || bin.op == "!~" {
+		if lit, ok := right.(*stringNode); ok {
+			re, err := regexp.Compile(lit.val)
+			if err != nil {
+				return nil, &Error{Pos: lit.p, Msg: fmt.Sprintf("invalid regular expression: %v", err)}
+			}
+			bin.re = re
+		}
+	}
+	return bin, nil
+}
+
+// parsePrimary parses literals, lists, calls, attribute paths and parenthesized expressions.
+func (p *parser) parsePrimary() (node, error) {
+	t := p.next()
+
+	switch t.kind {
+	case tokString:
+		return &stringNode{p: t.pos, val: t.text}, nil
+
+	case tokNumber:
+		n, err := strconv.Atoi(t.text)
+		if err != nil {
+			return nil, &Error{Pos: t.pos, Msg: fmt.Sprintf("invalid number %q", t.text)}
+		}
+		return &numberNode{p: t.pos, val: n}, nil
+
+	case tokIdent:
+		switch t.text {
+		case "true", "false":
+			return &boolNode{p: t.pos, val: t.text == "true"}, nil
+		case "in":
+			return nil, &Error{Pos: t.pos, Msg: `unexpected "in"`}
+		}
+
+		if p.isOp("(") {
+			return p.parseCall(t)
+		}
+
+		path := []string{t.text}
+		for p.isOp(".") {
+			p.next()
+			seg := p.next()
+			if seg.kind != tokIdent {
+				return nil, &Error{Pos: seg.pos, Msg: fmt.Sprintf("expected attribute name after \".\", found %s", seg)}
+			}
+			path = append(path, seg.text)
+		}
+		for i, seg := range path[:len(path)-1] {
+			if !strings.EqualFold(seg, "manager") {
+				return nil, &Error{Pos: t.pos, Msg: fmt.Sprintf("only manager can be followed by \".\", found %q", strings.Join(path[:i+1], "."))}
+			}
+		}
+		return &identNode{p: t.pos, path: path}, nil
+
+	case tokOp:
+		switch t.text {
+		case "(":
+			inner, err := p.parseExpr()
+			if err != nil {
+				return nil, err
+			}
+			if _, err := p.expect(")"); err != nil {
+				return nil, err
+			}
+			return inner, nil
+
+		case "[":
+			list := &listNode{p: t.pos}
+			for !p.isOp("]") {
+				item, err := p.parseExpr()
+				if err != nil {
+					return nil, err
+				}
+				list.items = append(list.items, item)
+				if !p.isOp(",") {
+					break
+				}
+				p.next()
+			}
+			if _, err := p.expect("]"); err != nil {
+				return nil, err
+			}
+			return list, nil
+		}
+	}
+
+	return nil, &Error{Pos: t.pos, Msg: fmt.Sprintf("unexpected %s", t)}
+}
+
+func (p *parser) parseCall(name token) (node, error) {
+	arity, ok := functions[name.text]
+	if !ok {
+		return nil, &Error{Pos: name.pos, Msg: fmt.Sprintf("unknown function %q", name.text)}
+	}
+
+	p.next() // (
+	call := &callNode{p: name.pos, name: name.text}
+	for !p.isOp(")") {
+		arg, err := p.parseExpr()
+		if err != nil {
+			return nil, err
+		}
+		call.args = append(call.args, arg)
+		if !p.isOp(",") {
+			break
+		}
+		p.next()
+	}
+	if _, err := p.expect(")"); err != nil {
+		return nil, err
+	}
+
+	if len(call.args) != arity {
+		return nil, &Error{Pos: name.pos, Msg: fmt.Sprintf("%s() takes %d argument(s), got %d", name.text, arity, len(call.args))}
+	}
+	if name.text == "matches" {
+		if lit, ok := call.args[1].(*stringNode); ok {
+			re, err := regexp.Compile(lit.val)
+			if err != nil {
+				return nil, &Error{Pos: lit.p, Msg: fmt.Sprintf("invalid regular expression: %v", err)}
+			}
+			call.re = re
+		}
+	}
+	if name.text == "has" {
+		if _, ok := call.args[0].(*identNode); !ok {
+			return nil, &Error{Pos: call.args[0].pos(), Msg: "has() takes an attribute name"}
+		}
+	}
+	return call, nil
+}
+
+// check rejects expressions whose boolean structure is wrong regardless of the data,
+// e.g. `department && state` or a top-level string.
+func check(n node) error {
+	if !isBoolean(n) {
+		return &Error{Pos: n.pos(), Msg: "expression must be a condition (comparison, function test or true/false)"}
+	}
+
+	var err error
+	walk(n, func(n node) {
+		if err != nil {
+			return
+		}
+		switch v := n.(type) {
+		case *binaryNode:
+			if v.op == "&&" || v.op == "||" {
+				for _, side := range []node{v.left, v.right} {
+					if !isBoolean(side) {
+						err = &Error{Pos: side.pos(), Msg: fmt.Sprintf("operand of %s must be a condition", v.op)}
+						return
+					}
+				}
+			}
+		case *unaryNode:
+			if !isBoolean(v.arg) {
+				err = &Error{Pos: v.arg.pos(), Msg: "operand of ! must be a condition"}
+			}
+		}
+	})
+	return err
+}
+
+func isBoolean(n node) bool {
+	switch v := n.(type) {
+	case *boolNode, *unaryNode:
+		return true
+	case *binaryNode:
+		return true
+	case *callNode:
+		switch v.name {
+		case "contains", "startsWith", "endsWith", "matches", "has", "reportsTo":
+			return true
+		}
+	}
+	return false
+}
+
+// walk visits n and all of its descendants.
+func walk(n node, fn func(node)) {
+	fn(n)
+	switch v := n.(type) {
+	case *listNode:
+		for _, item := range v.items {
+			walk(item, fn)
+		}
+	case *callNode:
+		for _, arg := range v.args {
+			walk(arg, fn)
+		}
+	case *unaryNode:
+		walk(v.arg, fn)
+	case *binaryNode:
+		walk(v.left, fn)
+		walk(v.right, fn)
+	}
+}
@@ -0,0 +1,182 @@
+package googleclient
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"mime/multipart"
+	"net/http"
+	"net/textproto"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"github.com/matthewdavidson09/dynamic-distro-groups/tools"
+	"google.golang.org/api/googleapi"
+)
+
+const (
+	defaultBatchSize = 50
+	maxBatchSize     = 1000 // the Directory API's limit per batch request
+)
+
+// ErrBatchFailed wraps the error of a batch request that failed as a whole, so none of its calls ran.
+var ErrBatchFailed = errors.New("batch request failed")
+
+// BatchCall is one Directory API request sent as part of a batch.
+type BatchCall struct {
+	Method string
+	Path   string      // below the API's base path, e.g. "admin/directory/v1/groups/<key>/members"
+	Body   interface{} // encoded as JSON; nil for none
+}
+
+// Batch sends calls to the Directory API as multipart batch requests of GOOGLE_BATCH_SIZE calls and
+// returns each call's error, nil when it succeeded. A call fails with a *googleapi.Error when Google
+// rejected it, or with the batch's own error when the whole request failed.
+func (c *Clients) Batch(ctx context.Context, calls []BatchCall) []error {
+	errs := make([]error, len(calls))
+	size := c.batchSize
+
+	for start := 0; start < len(calls); start += size {
+		chunk := calls[start:min(start+size, len(calls))]
+		results, err := c.sendBatch(ctx, chunk)
+		for i := range chunk {
+			if err != nil {
+				errs[start+i] = err
+			} else {
+				errs[start+i] = results[i]
+			}
+		}
+	}
+	return errs
+}
+
+// sendBatch sends one batch request and returns the result of each call.
+func (c *Clients) sendBatch(ctx context.Context, calls []BatchCall) ([]error, error) {
+	base, err := url.Parse(c.Directory.BasePath)
+	if err != nil {
+		return nil, fmt.Errorf("invalid Directory API base path: %w", err)
+	}
+
+	// 1. One application/http part per call
+	var buf bytes.Buffer
+	w := multipart.NewWriter(&buf)
+	for i, call := range calls {
+		part, err := w.CreatePart(textproto.MIMEHeader{
+			"Content-Type": {"application/http"},
+			"Content-ID":   {fmt.Sprintf("<item-%d>", i)},
+		})
+		if err != nil {
+			return nil, err
+		}
+		fmt.Fprintf(part, "%s %s%s HTTP/1.1\r\n", call.Method, base.Path, call.Path)
+		if call.Body == nil {
+			fmt.Fprint(part, "\r\n")
+			continue
+		}
+		body, err := json.Marshal(call.Body)
+		if err != nil {
+			return nil, fmt.Errorf("failed to encode batch call: %w", err)
+		}
+		fmt.Fprintf(part, "Content-Type: application/json\r\nContent-Length: %d\r\n\r\n%s", len(body), body)
+	}
+	if err := w.Close(); err != nil {
+		return nil, err
+	}
+
+	// 2. Every call counts against the quota, not just the batch request the transport sees
+	if c.limiter != nil {
+		for range len(calls) - 1 {
+			if err := c.limiter.wait(ctx); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, c.Directory.BasePath+"batch/admin/directory_v1", bytes.NewReader(buf.Bytes()))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "multipart/mixed; boundary="+w.Boundary())
+
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrBatchFailed, err)
+	}
+	defer resp.Body.Close()
+	if err := googleapi.CheckResponse(resp); err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrBatchFailed, err)
+	}
+
+	// 3. Match each response part to its call
+	results, err := parseBatchResponse(resp, len(calls))
+	if err != nil {
+		return nil, fmt.Errorf("%w: failed to read response: %w", ErrBatchFailed, err)
+	}
+	tools.Log.WithField("calls", len(calls)).Debug("Sent Google batch request")
+	return results, nil
+}
+
+// parseBatchResponse reads the result of each of n calls from a multipart batch response. Calls missing
+// from the response fail.
+func parseBatchResponse(resp *http.Response, n int) ([]error, error) {
+	_, params, err := mime.ParseMediaType(resp.Header.Get("Content-Type"))
+	if err != nil || params["boundary"] == "" {
+		return nil, fmt.Errorf("response is not multipart: %q", resp.Header.Get("Content-Type"))
+	}
+
+	results := make([]error, n)
+	seen := make([]bool, n)
+	r := multipart.NewReader(resp.Body, params["boundary"])
+	for {
+		part, err := r.NextPart()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		id := strings.Trim(part.Header.Get("Content-ID"), "<>")
+		i, err := strconv.Atoi(strings.TrimPrefix(id, "response-item-"))
+		if err != nil || i < 0 || i >= n {
+			return nil, fmt.Errorf("unexpected part %q", id)
+		}
+
+		inner, err := http.ReadResponse(bufio.NewReader(part), nil)
+		if err != nil {
+			return nil, fmt.Errorf("part %q: %w", id, err)
+		}
+		body, err := io.ReadAll(inner.Body)
+		inner.Body.Close()
+		if err != nil {
+			return nil, fmt.Errorf("part %q: %w", id, err)
+		}
+		results[i], seen[i] = googleapi.CheckResponseWithBody(inner, body), true
+	}
+
+	for i := range results {
+		if !seen[i] {
+			results[i] = errors.New("no response in batch")
+		}
+	}
+	return results, nil
+}
+
+// Retryable reports whether a failed call may succeed if sent again: it was throttled, hit a server
+// error, or its batch as a whole failed.
+func Retryable(err error) bool {
+	if err == nil {
+		return false
+	}
+	var apiErr *googleapi.Error
+	if errors.Is(err, ErrBatchFailed) || !errors.As(err, &apiErr) {
+		return true
+	}
+	return retryableError(apiErr)
+}
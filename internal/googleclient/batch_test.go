@@ -0,0 +1,202 @@
+package googleclient
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"net/textproto"
+	"strings"
+	"sync/atomic"
+	"testing"
+
+	admin "google.golang.org/api/admin/directory/v1"
+	"google.golang.org/api/googleapi"
+)
+
+const (
+	partOK       = "HTTP/1.1 200 OK\r\nContent-Type: application/json\r\n\r\n{}"
+	partConflict = "HTTP/1.1 409 Conflict\r\nContent-Type: application/json\r\n\r\n" +
+		`{"error": {"code": 409, "message": "Member already exists.", "errors": [{"reason": "duplicate"}]}}`
+)
+
+// batchPart is one part of a batch response: its Content-ID and the HTTP response it holds.
+type batchPart struct {
+	id, raw string
+}
+
+// batchBody encodes parts as a multipart body and returns it with its Content-Type.
+func batchBody(parts ...batchPart) (string, string) {
+	var buf bytes.Buffer
+	w := multipart.NewWriter(&buf)
+	for _, p := range parts {
+		pw, _ := w.CreatePart(textproto.MIMEHeader{"Content-Type": {"application/http"}, "Content-ID": {"<" + p.id + ">"}})
+		io.WriteString(pw, p.raw)
+	}
+	w.Close()
+	return buf.String(), "multipart/mixed; boundary=" + w.Boundary()
+}
+
+// wantCode checks a call's result: 0 for success, -1 for an error without a status code.
+func wantCode(t *testing.T, i int, err error, code int) {
+	t.Helper()
+	var apiErr *googleapi.Error
+	switch {
+	case code == 0 && err != nil:
+		t.Errorf("call %d = %v, want success", i, err)
+	case code > 0 && (!errors.As(err, &apiErr) || apiErr.Code != code):
+		t.Errorf("call %d = %v, want status %d", i, err, code)
+	case code < 0 && (err == nil || errors.As(err, &apiErr)):
+		t.Errorf("call %d = %v, want an error without a status", i, err)
+	}
+}
+
+func TestParseBatchResponse(t *testing.T) {
+	tests := []struct {
+		name      string
+		parts     []batchPart
+		noMIME    bool
+		wantCodes []int
+		wantErr   string
+	}{
+		{
+			name:      "in order",
+			parts:     []batchPart{{"response-item-0", partOK}, {"response-item-1", partConflict}},
+			wantCodes: []int{0, 409},
+		},
+		{
+			name:      "reordered",
+			parts:     []batchPart{{"response-item-1", partOK}, {"response-item-0", partConflict}},
+			wantCodes: []int{409, 0},
+		},
+		{
+			name:      "missing part",
+			parts:     []batchPart{{"response-item-1", partConflict}},
+			wantCodes: []int{-1, 409},
+		},
+		{
+			name:    "unknown part",
+			parts:   []batchPart{{"response-item-2", partOK}},
+			wantErr: `unexpected part "response-item-2"`,
+		},
+		{
+			name:    "malformed part",
+			parts:   []batchPart{{"response-item-0", "not http at all"}},
+			wantErr: `part "response-item-0"`,
+		},
+		{
+			name:    "not multipart",
+			noMIME:  true,
+			wantErr: "response is not multipart",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			body, contentType := batchBody(tt.parts...)
+			if tt.noMIME {
+				contentType = "application/json"
+			}
+			resp := &http.Response{
+				Header: http.Header{"Content-Type": {contentType}},
+				Body:   io.NopCloser(strings.NewReader(body)),
+			}
+
+			results, err := parseBatchResponse(resp, 2)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("parseBatchResponse() = %v, want error containing %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			for i, code := range tt.wantCodes {
+				wantCode(t, i, results[i], code)
+			}
+		})
+	}
+}
+
+func TestBatch(t *testing.T) {
+	tests := []struct {
+		name         string
+		handler      func(w http.ResponseWriter, r *http.Request)
+		wantCodes    []int
+		wantFailed   bool // every call fails with ErrBatchFailed
+		wantRequests int32
+	}{
+		{
+			name: "answers matched by Content-ID",
+			handler: func(w http.ResponseWriter, r *http.Request) {
+				// Each batch of 2 answers its second call first and rejects it.
+				body, contentType := batchBody(batchPart{"response-item-1", partConflict}, batchPart{"response-item-0", partOK})
+				w.Header().Set("Content-Type", contentType)
+				io.WriteString(w, body)
+			},
+			wantCodes:    []int{0, 409, 0, 409},
+			wantRequests: 2,
+		},
+		{
+			name: "non-2xx outer response",
+			handler: func(w http.ResponseWriter, r *http.Request) {
+				w.Header().Set("Content-Type", "application/json")
+				w.WriteHeader(http.StatusBadRequest)
+				io.WriteString(w, `{"error": {"code": 400, "message": "bad batch"}}`)
+			},
+			wantFailed:   true,
+			wantRequests: 2,
+		},
+		{
+			name: "malformed response",
+			handler: func(w http.ResponseWriter, r *http.Request) {
+				body, contentType := batchBody(batchPart{"response-item-0", "garbage"})
+				w.Header().Set("Content-Type", contentType)
+				io.WriteString(w, body)
+			},
+			wantFailed:   true,
+			wantRequests: 2,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var requests atomic.Int32
+			srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				requests.Add(1)
+				if r.URL.Path != "/batch/admin/directory_v1" || !strings.HasPrefix(r.Header.Get("Content-Type"), "multipart/mixed") {
+					t.Errorf("unexpected request %s %s (%s)", r.Method, r.URL.Path, r.Header.Get("Content-Type"))
+				}
+				tt.handler(w, r)
+			}))
+			defer srv.Close()
+
+			c := &Clients{Directory: &admin.Service{BasePath: srv.URL + "/"}, http: srv.Client(), batchSize: 2}
+			calls := make([]BatchCall, 4)
+			for i := range calls {
+				calls[i] = BatchCall{Method: http.MethodPost, Path: "admin/directory/v1/groups/1/members", Body: map[string]string{"email": "ann@example.com"}}
+			}
+
+			errs := c.Batch(context.Background(), calls)
+			if len(errs) != len(calls) {
+				t.Fatalf("Batch() returned %d results for %d calls", len(errs), len(calls))
+			}
+			if got := requests.Load(); got != tt.wantRequests {
+				t.Errorf("Batch() sent %d requests, want %d", got, tt.wantRequests)
+			}
+			for i, err := range errs {
+				if tt.wantFailed {
+					if !errors.Is(err, ErrBatchFailed) || !Retryable(err) {
+						t.Errorf("call %d = %v, want a retryable ErrBatchFailed", i, err)
+					}
+					continue
+				}
+				wantCode(t, i, err, tt.wantCodes[i])
+			}
+		})
+	}
+}
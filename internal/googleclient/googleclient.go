@@ -2,35 +2,117 @@ package googleclient
 
 import (
 	"context"
+	"encoding/json"
 	"fmt"
 	"net/http"
 	"os"
+	"strings"
 
+	"github.com/matthewdavidson09/dynamic-distro-groups/internal/secrets"
+	"github.com/matthewdavidson09/dynamic-distro-groups/tools"
+	"golang.org/x/oauth2"
 	"golang.org/x/oauth2/google"
 	admin "google.golang.org/api/admin/directory/v1"
+	"google.golang.org/api/groupssettings/v1"
+	"google.golang.org/api/impersonate"
 	"google.golang.org/api/option"
 )
 
-func NewDirectoryService(ctx context.Context) (*admin.Service, error) {
-	credsPath := os.Getenv("GOOGLE_APPLICATION_CREDENTIALS")
-	if credsPath == "" {
-		return nil, fmt.Errorf("GOOGLE_APPLICATION_CREDENTIALS env var not set")
+// scopes are the Workspace scopes the impersonated admin is granted through domain-wide delegation.
+var scopes = []string{
+	admin.AdminDirectoryGroupScope,
+	admin.AdminDirectoryUserScope,
+	"https://www.googleapis.com/auth/apps.groups.settings",
+}
+
+// Clients are the Google API clients of one run. They share one HTTP client, and so one cached token,
+// rate limit and retry policy.
+type Clients struct {
+	Directory *admin.Service
+	Settings  *groupssettings.Service
+
+	http      *http.Client // for batch requests
+	limiter   *tokenBucket // nil when rate limiting is off
+	batchSize int
+}
+
+// New connects to the Directory and Groups Settings APIs as GOOGLE_IMPERSONATE_USER. With
+// GOOGLE_API_ENDPOINT set, both APIs are served from that base URL without authentication, for testing
+// against a local fake.
+func New(ctx context.Context) (*Clients, error) {
+	batchSize, err := envInt("GOOGLE_BATCH_SIZE", defaultBatchSize)
+	if err != nil {
+		return nil, err
+	}
+	if batchSize < 1 || batchSize > maxBatchSize {
+		return nil, fmt.Errorf("invalid GOOGLE_BATCH_SIZE %d, expected 1 to %d", batchSize, maxBatchSize)
+	}
+
+	// 1. One transport, and so one token, rate limit and retry policy, for every API
+	var opts []option.ClientOption
+	base := http.DefaultTransport
+	endpoint := strings.TrimSpace(os.Getenv("GOOGLE_API_ENDPOINT"))
+	if endpoint != "" {
+		endpoint = strings.TrimSuffix(endpoint, "/") + "/"
+		tools.Log.Warnf("Using Google API endpoint %s without authentication", endpoint)
+		opts = append(opts, option.WithoutAuthentication())
+	} else {
+		ts, err := tokenSource(ctx)
+		if err != nil {
+			return nil, err
+		}
+		base = oauth2.NewClient(ctx, ts).Transport
+	}
+	transport, err := newRetryTransport(base)
+	if err != nil {
+		return nil, err
+	}
+	client := &http.Client{Transport: transport}
+	opts = append(opts, option.WithHTTPClient(client))
+
+	// 2. The services
+	directoryOpts, settingsOpts := opts, opts
+	if endpoint != "" {
+		directoryOpts = append(opts[:len(opts):len(opts)], option.WithEndpoint(endpoint))
+		settingsOpts = append(opts[:len(opts):len(opts)], option.WithEndpoint(endpoint+"groups/v1/groups/"))
 	}
 
-	data, err := os.ReadFile(credsPath)
+	directory, err := admin.NewService(ctx, directoryOpts...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create Google Directory client: %w", err)
+	}
+	settings, err := groupssettings.NewService(ctx, settingsOpts...)
 	if err != nil {
-		return nil, fmt.Errorf("failed to read service account JSON: %w", err)
+		return nil, fmt.Errorf("failed to create Groups Settings client: %w", err)
 	}
+	return &Clients{
+		Directory: directory,
+		Settings:  settings,
+		http:      client,
+		limiter:   transport.limiter,
+		batchSize: batchSize,
+	}, nil
+}
 
+// tokenSource returns tokens for GOOGLE_IMPERSONATE_USER through domain-wide delegation.
+//
+// With GOOGLE_SERVICE_ACCOUNT set, no key is needed: the base credentials (GOOGLE_CREDENTIALS, else
+// Application Default Credentials, which include workload identity federation configs) ask the IAM
+// Credentials API to sign the delegation JWT as that service account. Otherwise the JWT is signed
+// locally with the service account key.
+func tokenSource(ctx context.Context) (oauth2.TokenSource, error) {
 	impersonateUser := os.Getenv("GOOGLE_IMPERSONATE_USER")
 	if impersonateUser == "" {
 		return nil, fmt.Errorf("GOOGLE_IMPERSONATE_USER env var not set")
 	}
 
-	scopes := []string{
-		admin.AdminDirectoryGroupScope,
-		admin.AdminDirectoryUserScope,
-		"https://www.googleapis.com/auth/apps.groups.settings",
+	if account := strings.TrimSpace(os.Getenv("GOOGLE_SERVICE_ACCOUNT")); account != "" {
+		return keylessTokenSource(ctx, account, impersonateUser)
+	}
+
+	data, err := serviceAccountKey()
+	if err != nil {
+		return nil, err
 	}
 
 	config, err := google.JWTConfigFromJSON(data, scopes...)
@@ -39,38 +121,85 @@ func NewDirectoryService(ctx context.Context) (*admin.Service, error) {
 	}
 	config.Subject = impersonateUser
 
-	client := config.Client(ctx)
-
-	return admin.NewService(ctx, option.WithHTTPClient(client))
+	return config.TokenSource(ctx), nil
 }
 
-func NewImpersonatedHTTPClient(ctx context.Context) (*http.Client, error) {
-	credsPath := os.Getenv("GOOGLE_APPLICATION_CREDENTIALS")
-	if credsPath == "" {
-		return nil, fmt.Errorf("GOOGLE_APPLICATION_CREDENTIALS env var not set")
+// keylessTokenSource impersonates the service account account via IAM signJwt, which needs the base
+// credentials to hold roles/iam.serviceAccountTokenCreator on it.
+func keylessTokenSource(ctx context.Context, account, impersonateUser string) (oauth2.TokenSource, error) {
+	var opts []option.ClientOption
+	data, err := credentialsJSON()
+	if err != nil {
+		return nil, err
+	}
+	if data != nil {
+		opts = append(opts, option.WithCredentialsJSON(data))
 	}
 
-	data, err := os.ReadFile(credsPath)
+	ts, err := impersonate.CredentialsTokenSource(ctx, impersonate.CredentialsConfig{
+		TargetPrincipal: account,
+		Scopes:          scopes,
+		Subject:         impersonateUser,
+	}, opts...)
 	if err != nil {
-		return nil, fmt.Errorf("failed to read service account JSON: %w", err)
+		return nil, fmt.Errorf("failed to impersonate service account %s: %w", account, err)
 	}
+	return ts, nil
+}
 
-	impersonateUser := os.Getenv("GOOGLE_IMPERSONATE_USER")
-	if impersonateUser == "" {
-		return nil, fmt.Errorf("GOOGLE_IMPERSONATE_USER env var not set")
+// serviceAccountKey returns the service account JSON key: GOOGLE_CREDENTIALS, else the file at
+// GOOGLE_APPLICATION_CREDENTIALS.
+func serviceAccountKey() ([]byte, error) {
+	data, err := credentialsJSON()
+	if err != nil {
+		return nil, err
 	}
 
-	scopes := []string{
-		admin.AdminDirectoryGroupScope,
-		admin.AdminDirectoryUserScope,
-		"https://www.googleapis.com/auth/apps.groups.settings",
+	if data == nil {
+		credsPath := os.Getenv("GOOGLE_APPLICATION_CREDENTIALS")
+		if credsPath == "" {
+			return nil, fmt.Errorf("no Google credentials: set GOOGLE_SERVICE_ACCOUNT, GOOGLE_CREDENTIALS or GOOGLE_APPLICATION_CREDENTIALS")
+		}
+
+		data, err = os.ReadFile(credsPath)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read service account JSON: %w", err)
+		}
 	}
 
-	config, err := google.JWTConfigFromJSON(data, scopes...)
-	if err != nil {
+	var parsed struct {
+		Type       string `json:"type"`
+		PrivateKey string `json:"private_key"`
+	}
+	if err := json.Unmarshal(data, &parsed); err != nil {
 		return nil, fmt.Errorf("failed to parse service account credentials: %w", err)
 	}
-	config.Subject = impersonateUser
+	if parsed.PrivateKey != "" {
+		tools.RedactSecret(parsed.PrivateKey)
+	}
+	if parsed.Type != "service_account" {
+		return nil, fmt.Errorf("credentials of type %q hold no service account key, set GOOGLE_SERVICE_ACCOUNT to delegate through a service account without one", parsed.Type)
+	}
+	return data, nil
+}
 
-	return config.Client(ctx), nil
+// credentialsJSON returns the credentials in GOOGLE_CREDENTIALS, a secret reference (file://, env:,
+// exec:) or the JSON itself, or nil if it is not set. A private key in them is registered for redaction.
+func credentialsJSON() ([]byte, error) {
+	value, err := secrets.Env("GOOGLE_CREDENTIALS")
+	if err != nil || value == "" {
+		return nil, err
+	}
+
+	data := []byte(value)
+	var parsed struct {
+		PrivateKey string `json:"private_key"`
+	}
+	if err := json.Unmarshal(data, &parsed); err != nil {
+		return nil, fmt.Errorf("failed to parse GOOGLE_CREDENTIALS: %w", err)
+	}
+	if parsed.PrivateKey != "" {
+		tools.RedactSecret(parsed.PrivateKey)
+	}
+	return data, nil
 }
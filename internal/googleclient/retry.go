@@ -0,0 +1,259 @@
+package googleclient
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"math/rand"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/matthewdavidson09/dynamic-distro-groups/tools"
+	"google.golang.org/api/googleapi"
+)
+
+const (
+	defaultRateLimit  = 20 // requests per second, below the Directory API's 2400 per minute
+	defaultMaxRetries = 5
+	baseBackoff       = time.Second
+	maxBackoff        = 32 * time.Second
+)
+
+// rateLimitReasons are the googleapi.Error reasons Google returns, often with status 403, when a quota
+// is exhausted rather than access denied.
+var rateLimitReasons = map[string]bool{
+	"rateLimitExceeded":     true,
+	"userRateLimitExceeded": true,
+	"quotaExceeded":         true,
+	"backendError":          true,
+}
+
+// retryTransport sends every Google API request through one shared rate limit and retries requests
+// that failed for reasons that pass: throttling, server errors and, for reads, network errors.
+type retryTransport struct {
+	base       http.RoundTripper
+	limiter    *tokenBucket
+	maxRetries int
+}
+
+// newRetryTransport wraps base with the limits from GOOGLE_RATE_LIMIT (requests per second, 0 disables)
+// and GOOGLE_MAX_RETRIES.
+func newRetryTransport(base http.RoundTripper) (*retryTransport, error) {
+	rateLimit, err := envFloat("GOOGLE_RATE_LIMIT", defaultRateLimit)
+	if err != nil {
+		return nil, err
+	}
+	maxRetries, err := envInt("GOOGLE_MAX_RETRIES", defaultMaxRetries)
+	if err != nil {
+		return nil, err
+	}
+
+	t := &retryTransport{base: base, maxRetries: maxRetries}
+	if rateLimit > 0 {
+		t.limiter = newTokenBucket(rateLimit)
+	}
+	return t, nil
+}
+
+func (t *retryTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	ctx := req.Context()
+	for attempt := 0; ; attempt++ {
+		if t.limiter != nil {
+			if err := t.limiter.wait(ctx); err != nil {
+				return nil, err
+			}
+		}
+
+		// Every attempt needs a fresh copy of the body.
+		if attempt > 0 && req.Body != nil {
+			body, err := req.GetBody()
+			if err != nil {
+				return nil, err
+			}
+			req = req.Clone(ctx)
+			req.Body = body
+		}
+
+		resp, err := t.base.RoundTrip(req)
+		wait, retry := t.classify(req, resp, err)
+		if !retry || attempt >= t.maxRetries || (req.Body != nil && req.GetBody == nil) {
+			return resp, err
+		}
+		if resp != nil {
+			resp.Body.Close()
+		}
+
+		if backoff := jitteredBackoff(attempt); wait < backoff {
+			wait = backoff
+		}
+		tools.Log.WithFields(map[string]interface{}{
+			"method":  req.Method,
+			"url":     req.URL.Path,
+			"attempt": attempt + 1,
+			"wait":    wait.Round(time.Millisecond).String(),
+			"reason":  retryReason(resp, err),
+		}).Warn("Google API request failed, retrying")
+
+		if err := sleep(ctx, wait); err != nil {
+			return nil, err
+		}
+	}
+}
+
+// classify reports whether a request should be retried and how long the server asked to wait first.
+// It reads an error response's body and replaces it, so the caller still sees the googleapi.Error.
+func (t *retryTransport) classify(req *http.Request, resp *http.Response, err error) (time.Duration, bool) {
+	if err != nil {
+		// A write may have been applied before the connection dropped, so only reads are resent.
+		return 0, req.Context().Err() == nil && (req.Method == http.MethodGet || req.Method == http.MethodHead)
+	}
+	if resp.StatusCode < 400 {
+		return 0, false
+	}
+
+	body, readErr := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	resp.Body = io.NopCloser(bytes.NewReader(body))
+	if readErr != nil {
+		return 0, false
+	}
+
+	var apiErr *googleapi.Error
+	if !errors.As(googleapi.CheckResponseWithBody(resp, body), &apiErr) || !retryableError(apiErr) {
+		return 0, false
+	}
+	return retryAfter(resp.Header.Get("Retry-After")), true
+}
+
+// retryableError reports whether Google rejected a call for a reason that passes: throttling or a server
+// error.
+func retryableError(apiErr *googleapi.Error) bool {
+	switch apiErr.Code {
+	case http.StatusTooManyRequests, http.StatusInternalServerError, http.StatusBadGateway,
+		http.StatusServiceUnavailable, http.StatusGatewayTimeout:
+		return true
+	case http.StatusForbidden:
+		for _, item := range apiErr.Errors {
+			if rateLimitReasons[item.Reason] {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// retryReason describes a failed attempt for the log.
+func retryReason(resp *http.Response, err error) string {
+	if err != nil {
+		return err.Error()
+	}
+	return resp.Status
+}
+
+// jitteredBackoff returns a random wait up to baseBackoff doubled per attempt, capped at maxBackoff.
+func jitteredBackoff(attempt int) time.Duration {
+	limit := maxBackoff
+	if attempt < 6 {
+		limit = min(baseBackoff<<attempt, maxBackoff)
+	}
+	return limit/2 + time.Duration(rand.Int63n(int64(limit/2)+1))
+}
+
+// retryAfter parses a Retry-After header given in seconds or as an HTTP date.
+func retryAfter(value string) time.Duration {
+	if value == "" {
+		return 0
+	}
+	if seconds, err := strconv.Atoi(value); err == nil && seconds > 0 {
+		return time.Duration(seconds) * time.Second
+	}
+	if at, err := http.ParseTime(value); err == nil {
+		return max(time.Until(at), 0)
+	}
+	return 0
+}
+
+// tokenBucket allows rate requests per second on average and bursts of up to one second's worth.
+type tokenBucket struct {
+	mu     sync.Mutex
+	rate   float64
+	burst  float64
+	tokens float64
+	last   time.Time
+}
+
+func newTokenBucket(rate float64) *tokenBucket {
+	burst := max(rate, 1)
+	return &tokenBucket{rate: rate, burst: burst, tokens: burst, last: time.Now()}
+}
+
+// wait blocks until a token is available or ctx is done.
+func (b *tokenBucket) wait(ctx context.Context) error {
+	for {
+		b.mu.Lock()
+		now := time.Now()
+		b.tokens = min(b.burst, b.tokens+now.Sub(b.last).Seconds()*b.rate)
+		b.last = now
+		if b.tokens >= 1 {
+			b.tokens--
+			b.mu.Unlock()
+			return nil
+		}
+		delay := time.Duration((1 - b.tokens) / b.rate * float64(time.Second))
+		b.mu.Unlock()
+
+		if err := sleep(ctx, delay); err != nil {
+			return err
+		}
+	}
+}
+
+// Backoff waits before retry attempt+1 of a call that failed for a reason the transport does not retry,
+// as long as the transport would, or until ctx is done.
+func Backoff(ctx context.Context, attempt int) error {
+	return sleep(ctx, jitteredBackoff(attempt))
+}
+
+// sleep waits for d or until ctx is done.
+func sleep(ctx context.Context, d time.Duration) error {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
+
+// envFloat reads a non-negative number from the environment, or returns def when it is not set.
+func envFloat(name string, def float64) (float64, error) {
+	value := strings.TrimSpace(os.Getenv(name))
+	if value == "" {
+		return def, nil
+	}
+	n, err := strconv.ParseFloat(value, 64)
+	if err != nil || n < 0 {
+		return 0, fmt.Errorf("invalid %s %q, expected a non-negative number", name, value)
+	}
+	return n, nil
+}
+
+// envInt reads a non-negative whole number from the environment, or returns def when it is not set.
+func envInt(name string, def int) (int, error) {
+	value := strings.TrimSpace(os.Getenv(name))
+	if value == "" {
+		return def, nil
+	}
+	n, err := strconv.Atoi(value)
+	if err != nil || n < 0 {
+		return 0, fmt.Errorf("invalid %s %q, expected a non-negative whole number", name, value)
+	}
+	return n, nil
+}
@@ -0,0 +1,184 @@
+package googleclient
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"google.golang.org/api/googleapi"
+)
+
+func TestRetryAfter(t *testing.T) {
+	tests := []struct {
+		value string
+		want  time.Duration
+	}{
+		{"", 0},
+		{"7", 7 * time.Second},
+		{"0", 0},
+		{"-3", 0},
+		{"soon", 0},
+		{time.Now().Add(-time.Hour).UTC().Format(http.TimeFormat), 0},
+	}
+	for _, tt := range tests {
+		if got := retryAfter(tt.value); got != tt.want {
+			t.Errorf("retryAfter(%q) = %v, want %v", tt.value, got, tt.want)
+		}
+	}
+
+	date := time.Now().Add(time.Minute).UTC().Format(http.TimeFormat)
+	if got := retryAfter(date); got < 58*time.Second || got > time.Minute {
+		t.Errorf("retryAfter(%q) = %v, want about a minute", date, got)
+	}
+}
+
+// apiResponse is a Google API error response.
+func apiResponse(code int, reason, retryAfter string) *http.Response {
+	body := fmt.Sprintf(`{"error": {"code": %d, "message": %q, "errors": [{"reason": %q}]}}`, code, reason, reason)
+	resp := &http.Response{
+		StatusCode: code,
+		Status:     fmt.Sprintf("%d %s", code, http.StatusText(code)),
+		Header:     http.Header{"Content-Type": {"application/json"}},
+		Body:       io.NopCloser(strings.NewReader(body)),
+	}
+	if retryAfter != "" {
+		resp.Header.Set("Retry-After", retryAfter)
+	}
+	return resp
+}
+
+func TestClassify(t *testing.T) {
+	get, _ := http.NewRequest(http.MethodGet, "https://example.com/groups", nil)
+	post, _ := http.NewRequest(http.MethodPost, "https://example.com/groups", nil)
+	dropped := errors.New("connection reset")
+
+	tests := []struct {
+		name      string
+		req       *http.Request
+		resp      *http.Response
+		err       error
+		wantRetry bool
+		wantWait  time.Duration
+	}{
+		{name: "success", req: get, resp: &http.Response{StatusCode: http.StatusOK, Body: http.NoBody}},
+		{name: "read network error", req: get, err: dropped, wantRetry: true},
+		{name: "write network error", req: post, err: dropped},
+		{name: "too many requests", req: post, resp: apiResponse(http.StatusTooManyRequests, "rateLimitExceeded", "3"), wantRetry: true, wantWait: 3 * time.Second},
+		{name: "server error", req: post, resp: apiResponse(http.StatusServiceUnavailable, "backendError", ""), wantRetry: true},
+		{name: "quota 403", req: get, resp: apiResponse(http.StatusForbidden, "userRateLimitExceeded", ""), wantRetry: true},
+		{name: "access denied", req: get, resp: apiResponse(http.StatusForbidden, "forbidden", "")},
+		{name: "not found", req: get, resp: apiResponse(http.StatusNotFound, "notFound", "")},
+		{name: "conflict", req: post, resp: apiResponse(http.StatusConflict, "duplicate", "")},
+	}
+
+	var rt retryTransport
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			wait, retry := rt.classify(tt.req, tt.resp, tt.err)
+			if retry != tt.wantRetry || wait != tt.wantWait {
+				t.Errorf("classify() = %v, %v; want %v, %v", wait, retry, tt.wantWait, tt.wantRetry)
+			}
+			if tt.resp == nil || tt.resp.StatusCode < 400 {
+				return
+			}
+			// The caller still sees the error.
+			var apiErr *googleapi.Error
+			if err := googleapi.CheckResponse(tt.resp); !errors.As(err, &apiErr) || apiErr.Code != tt.resp.StatusCode {
+				t.Errorf("classify() consumed the error body: %v", err)
+			}
+		})
+	}
+}
+
+func TestJitteredBackoff(t *testing.T) {
+	for attempt, limit := range []time.Duration{1, 2, 4, 8, 16, 32, 32, 32} {
+		limit *= time.Second
+		for range 50 {
+			if got := jitteredBackoff(attempt); got < limit/2 || got > limit {
+				t.Fatalf("jitteredBackoff(%d) = %v, want %v to %v", attempt, got, limit/2, limit)
+			}
+		}
+	}
+	if got := jitteredBackoff(100); got > maxBackoff {
+		t.Errorf("jitteredBackoff(100) = %v, more than %v", got, maxBackoff)
+	}
+}
+
+func TestTokenBucket(t *testing.T) {
+	b := newTokenBucket(50)
+	ctx := context.Background()
+
+	// A full bucket serves a second's worth at once, then one request per 1/rate.
+	start := time.Now()
+	for range 50 {
+		if err := b.wait(ctx); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if elapsed := time.Since(start); elapsed > 50*time.Millisecond {
+		t.Errorf("burst of 50 took %v", elapsed)
+	}
+	start = time.Now()
+	for range 5 {
+		if err := b.wait(ctx); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if elapsed := time.Since(start); elapsed < 80*time.Millisecond {
+		t.Errorf("5 requests past the burst took %v, want about 100ms", elapsed)
+	}
+
+	// An empty bucket gives up when the context is done.
+	slow := newTokenBucket(0.1)
+	slow.tokens = 0
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Millisecond)
+	defer cancel()
+	if err := slow.wait(ctx); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("wait() on an empty bucket = %v, want context.DeadlineExceeded", err)
+	}
+}
+
+func TestNewRetryTransportSettings(t *testing.T) {
+	tests := []struct {
+		rateLimit  string
+		maxRetries string
+		wantErr    string
+	}{
+		{rateLimit: "", maxRetries: ""},
+		{rateLimit: "2.5", maxRetries: "3"},
+		{rateLimit: "0", maxRetries: "0"},
+		{rateLimit: "fast", wantErr: `invalid GOOGLE_RATE_LIMIT "fast"`},
+		{rateLimit: "-1", wantErr: `invalid GOOGLE_RATE_LIMIT "-1"`},
+		{maxRetries: "2.5", wantErr: `invalid GOOGLE_MAX_RETRIES "2.5", expected a non-negative whole number`},
+		{maxRetries: "-1", wantErr: `invalid GOOGLE_MAX_RETRIES "-1"`},
+	}
+	for _, tt := range tests {
+		t.Setenv("GOOGLE_RATE_LIMIT", tt.rateLimit)
+		t.Setenv("GOOGLE_MAX_RETRIES", tt.maxRetries)
+		_, err := newRetryTransport(http.DefaultTransport)
+		if tt.wantErr == "" {
+			if err != nil {
+				t.Errorf("newRetryTransport(%q, %q) = %v", tt.rateLimit, tt.maxRetries, err)
+			}
+			continue
+		}
+		if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+			t.Errorf("newRetryTransport(%q, %q) = %v, want error containing %q", tt.rateLimit, tt.maxRetries, err, tt.wantErr)
+		}
+	}
+}
+
+func TestNewRejectsFractionalBatchSize(t *testing.T) {
+	for _, size := range []string{"2.5", "0", "1001", "many"} {
+		t.Setenv("GOOGLE_BATCH_SIZE", size)
+		t.Setenv("GOOGLE_API_ENDPOINT", "http://localhost")
+		if _, err := New(context.Background()); err == nil || !strings.Contains(err.Error(), "GOOGLE_BATCH_SIZE") {
+			t.Errorf("New() with GOOGLE_BATCH_SIZE=%s = %v, want an error", size, err)
+		}
+	}
+}
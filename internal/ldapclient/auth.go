@@ -0,0 +1,150 @@
+package ldapclient
+
+import (
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/go-ldap/ldap/v3"
+	"github.com/go-ldap/ldap/v3/gssapi"
+	"github.com/matthewdavidson09/dynamic-distro-groups/internal/secrets"
+)
+
+// Bind methods, chosen with LDAP_BIND.
+const (
+	BindSimple = "simple" // DN or UPN and password, sent as is; needs TLS
+	BindNTLM   = "ntlm"   // NTLM challenge/response with a password or NT hash
+	BindGSSAPI = "gssapi" // Kerberos via SASL GSSAPI with a keytab or credential cache
+)
+
+// credentials is how the service account authenticates.
+type credentials struct {
+	method   string
+	user     string
+	password string
+
+	ntlmDomain string
+	ntlmHash   string
+
+	krb5Config string
+	keytab     string
+	realm      string
+	ccache     string
+	spn        string // overrides ldap/<server host name>
+}
+
+// loadCredentials reads the bind settings from the environment. LDAP_PASSWORD and LDAP_NTLM_HASH may be
+// secret references (file://, env:, exec:):
+//
+//	LDAP_BIND          simple (default), ntlm or gssapi
+//	LDAP_USER          simple: bind DN or UPN; ntlm: user or DOMAIN\user; gssapi with keytab: principal
+//	LDAP_PASSWORD      simple and ntlm
+//	LDAP_NTLM_DOMAIN   ntlm: domain, if not given in LDAP_USER (default LDAP_DOMAIN)
+//	LDAP_NTLM_HASH     ntlm: NT hash, instead of LDAP_PASSWORD
+//	KRB5_CONFIG        gssapi: krb5.conf (default /etc/krb5.conf)
+//	LDAP_KRB5_KEYTAB   gssapi: keytab for LDAP_USER
+//	LDAP_KRB5_REALM    gssapi: realm of LDAP_USER, if not given as user@REALM (default the krb5.conf default realm)
+//	LDAP_KRB5_CCACHE   gssapi: credential cache to use instead of a keytab (default KRB5CCNAME)
+//	LDAP_KRB5_SPN      gssapi: service principal (default ldap/<DC host name>)
+func loadCredentials() (*credentials, error) {
+	env := func(name string) string { return strings.TrimSpace(os.Getenv(name)) }
+
+	password, err := secrets.Env("LDAP_PASSWORD")
+	if err != nil {
+		return nil, err
+	}
+
+	c := &credentials{
+		method:   strings.ToLower(env("LDAP_BIND")),
+		user:     env("LDAP_USER"),
+		password: password,
+	}
+	if c.method == "" {
+		c.method = BindSimple
+	}
+
+	switch c.method {
+	case BindSimple:
+	case BindNTLM:
+		if c.ntlmHash, err = secrets.Env("LDAP_NTLM_HASH"); err != nil {
+			return nil, err
+		}
+		c.ntlmDomain = env("LDAP_NTLM_DOMAIN")
+		if domain, user, ok := strings.Cut(c.user, `\`); ok {
+			c.ntlmDomain, c.user = domain, user
+		}
+		if c.ntlmDomain == "" {
+			c.ntlmDomain = env("LDAP_DOMAIN")
+		}
+		if c.password == "" && c.ntlmHash == "" {
+			return nil, fmt.Errorf("LDAP_BIND=ntlm needs LDAP_PASSWORD or LDAP_NTLM_HASH")
+		}
+	case BindGSSAPI:
+		c.krb5Config = env("KRB5_CONFIG")
+		if c.krb5Config == "" {
+			c.krb5Config = "/etc/krb5.conf"
+		}
+		c.keytab, c.realm, c.spn = env("LDAP_KRB5_KEYTAB"), env("LDAP_KRB5_REALM"), env("LDAP_KRB5_SPN")
+		if user, realm, ok := strings.Cut(c.user, "@"); ok {
+			if c.realm != "" && !strings.EqualFold(realm, c.realm) {
+				return nil, fmt.Errorf("LDAP_USER realm %q does not match LDAP_KRB5_REALM %q", realm, c.realm)
+			}
+			c.user = user
+			if c.realm == "" {
+				c.realm = realm
+			}
+		}
+		c.ccache = env("LDAP_KRB5_CCACHE")
+		if c.ccache == "" {
+			c.ccache = strings.TrimPrefix(env("KRB5CCNAME"), "FILE:")
+		}
+		if c.keytab == "" && c.ccache == "" {
+			return nil, fmt.Errorf("LDAP_BIND=gssapi needs LDAP_KRB5_KEYTAB or a credential cache (LDAP_KRB5_CCACHE or KRB5CCNAME)")
+		}
+		if c.keytab != "" && c.user == "" {
+			return nil, fmt.Errorf("LDAP_BIND=gssapi with a keytab needs the principal in LDAP_USER")
+		}
+	default:
+		return nil, fmt.Errorf("invalid LDAP_BIND %q, expected simple, ntlm or gssapi", c.method)
+	}
+	return c, nil
+}
+
+// sendsPassword reports whether the bind puts the password on the wire as is.
+func (c *credentials) sendsPassword() bool {
+	return c.method == BindSimple && c.password != ""
+}
+
+// bind authenticates conn to the server s.
+func (c *credentials) bind(conn *ldap.Conn, s server) error {
+	switch c.method {
+	case BindNTLM:
+		if c.ntlmHash != "" {
+			return conn.NTLMBindWithHash(c.ntlmDomain, c.user, c.ntlmHash)
+		}
+		return conn.NTLMBind(c.ntlmDomain, c.user, c.password)
+
+	case BindGSSAPI:
+		// A client holds per-context keys, so each connection gets its own.
+		var client *gssapi.Client
+		var err error
+		if c.keytab != "" {
+			client, err = gssapi.NewClientWithKeytab(c.user, c.realm, c.keytab, c.krb5Config)
+		} else {
+			client, err = gssapi.NewClientFromCCache(c.ccache, c.krb5Config)
+		}
+		if err != nil {
+			return fmt.Errorf("failed to load Kerberos credentials: %w", err)
+		}
+		defer client.Close()
+
+		spn := c.spn
+		if spn == "" {
+			spn = "ldap/" + s.host
+		}
+		return conn.GSSAPIBind(client, spn, "")
+
+	default:
+		return conn.Bind(c.user, c.password)
+	}
+}
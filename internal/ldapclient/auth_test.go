@@ -0,0 +1,46 @@
+package ldapclient
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestLoadCredentialsGSSAPIRealm(t *testing.T) {
+	tests := []struct {
+		name      string
+		user      string
+		realm     string
+		wantUser  string
+		wantRealm string
+		wantErr   string
+	}{
+		{name: "realm from LDAP_KRB5_REALM", user: "svc-sync", realm: "EXAMPLE.COM", wantUser: "svc-sync", wantRealm: "EXAMPLE.COM"},
+		{name: "realm from LDAP_USER", user: "svc-sync@EXAMPLE.COM", wantUser: "svc-sync", wantRealm: "EXAMPLE.COM"},
+		{name: "both agree", user: "svc-sync@example.com", realm: "EXAMPLE.COM", wantUser: "svc-sync", wantRealm: "EXAMPLE.COM"},
+		{name: "both disagree", user: "svc-sync@OTHER.COM", realm: "EXAMPLE.COM", wantErr: `LDAP_USER realm "OTHER.COM" does not match LDAP_KRB5_REALM "EXAMPLE.COM"`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Setenv("LDAP_BIND", BindGSSAPI)
+			t.Setenv("LDAP_USER", tt.user)
+			t.Setenv("LDAP_KRB5_REALM", tt.realm)
+			t.Setenv("LDAP_KRB5_KEYTAB", "/etc/sync.keytab")
+			t.Setenv("LDAP_PASSWORD", "")
+
+			c, err := loadCredentials()
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("loadCredentials() = %v, want error containing %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if c.user != tt.wantUser || c.realm != tt.wantRealm {
+				t.Errorf("loadCredentials() user %q realm %q, want %q and %q", c.user, c.realm, tt.wantUser, tt.wantRealm)
+			}
+		})
+	}
+}
@@ -1,80 +1,215 @@
 package ldapclient
 
 import (
+	"errors"
 	"fmt"
 	"net"
 	"os"
 	"strings"
+	"sync"
+	"time"
 
 	"github.com/go-ldap/ldap/v3"
 	"github.com/joho/godotenv"
 	"github.com/matthewdavidson09/dynamic-distro-groups/tools"
 )
 
+// dialTimeout bounds connecting to one server, so a dead DC fails over quickly.
+const dialTimeout = 10 * time.Second
+
+// LDAPClient is a pool of connections bound to one of the candidate domain controllers. Each operation
+// checks out its own connection, so concurrent workers run in parallel on the wire; a connection that
+// drops is discarded and the operation retried on a fresh one, failing over to the next healthy server.
+// It is safe for concurrent use.
 type LDAPClient struct {
-	Conn   *ldap.Conn
 	BaseDN string
+
+	mu       sync.Mutex
+	ready    *sync.Cond // signalled when a connection is returned or a slot frees up
+	idle     []*pooledConn
+	open     int // connections dialed and not yet closed, idle or checked out
+	size     int // most connections open at once
+	idleTTL  time.Duration
+	closed   bool
+	pageSize uint32 // entries per page of SearchPaged
+	servers  []server
+	current  int // index into servers that new connections go to first
+	sec      *security
+	creds    *credentials
 }
 
-// Connect resolves LDAP hostname to an IP and returns a bound LDAPClient.
+// Connect discovers the domain controllers (see discoverServers) and returns a client bound to the first
+// healthy one.
 func Connect() (*LDAPClient, error) {
 	if err := godotenv.Load(".env"); err != nil {
 		return nil, fmt.Errorf("error loading .env: %w", err)
 	}
 
-	server := strings.TrimSpace(os.Getenv("LDAP_SERVER"))
-	port := os.Getenv("LDAP_PORT")
-	if port == "" {
-		port = "389"
+	sec, err := loadSecurity()
+	if err != nil {
+		return nil, err
+	}
+	servers, err := discoverServers(sec)
+	if err != nil {
+		return nil, err
 	}
+	return newClient(servers, sec)
+}
 
-	// Resolve DNS
-	addrs, err := net.LookupHost(server)
-	if err != nil || len(addrs) == 0 {
-		return nil, fmt.Errorf("DNS lookup failed for %s: %v", server, err)
+// ConnectWithIP connects to a specific LDAP IP and returns a bound client. Certificates are verified
+// against LDAP_TLS_SERVER_NAME or LDAP_SERVER, not the IP.
+func ConnectWithIP(ip, port string) (*LDAPClient, error) {
+	sec, err := loadSecurity()
+	if err != nil {
+		return nil, err
 	}
-	ip := addrs[0]
+	return newClient([]server{{host: strings.TrimSpace(os.Getenv("LDAP_SERVER")), ip: ip, port: port}}, sec)
+}
 
-	tools.Log.WithFields(map[string]interface{}{
-		"host": server,
-		"ip":   ip,
-		"port": port,
-	}).Debug("Resolved LDAP server IP")
+func newClient(servers []server, sec *security) (*LDAPClient, error) {
+	creds, err := loadCredentials()
+	if err != nil {
+		return nil, err
+	}
+
+	// Never send the password in cleartext unless told to
+	if sec.mode == SecurityNone && creds.sendsPassword() {
+		if !sec.allowInsecureBind {
+			return nil, fmt.Errorf("refusing to bind with a password over unencrypted LDAP: set LDAP_SCHEME=ldaps or LDAP_STARTTLS=true, or LDAP_ALLOW_INSECURE_BIND=true to override")
+		}
+		tools.Log.Warn("Binding with a password over unencrypted LDAP (LDAP_ALLOW_INSECURE_BIND)")
+	}
+	// go-ldap negotiates no SASL security layer, so only TLS protects the traffic after an NTLM or
+	// Kerberos bind, and DCs that require LDAP signing reject such binds over plain LDAP.
+	if sec.mode == SecurityNone && creds.method != BindSimple {
+		tools.Log.Warnf("LDAP_BIND=%s over unencrypted LDAP: the connection is neither signed nor sealed; use LDAPS or StartTLS", creds.method)
+	}
+
+	size, idleTTL, err := poolSettings()
+	if err != nil {
+		return nil, err
+	}
+	pageSize, err := pageSize()
+	if err != nil {
+		return nil, err
+	}
 
-	return ConnectWithIP(ip, port)
+	c := &LDAPClient{
+		BaseDN:   strings.TrimSpace(os.Getenv("BASE_DN")),
+		size:     size,
+		idleTTL:  idleTTL,
+		pageSize: pageSize,
+		servers:  servers,
+		sec:      sec,
+		creds:    creds,
+	}
+	c.ready = sync.NewCond(&c.mu)
+
+	// Dial the first connection now so configuration and credential errors surface at connect time.
+	pc, err := c.get()
+	if err != nil {
+		return nil, err
+	}
+	c.put(pc, false)
+	return c, nil
 }
 
-// ConnectWithIP connects to a specific LDAP IP and returns a bound client.
-func ConnectWithIP(ip, port string) (*LDAPClient, error) {
-	user := strings.TrimSpace(os.Getenv("LDAP_USER"))
-	pass := strings.TrimSpace(os.Getenv("LDAP_PASSWORD"))
-	baseDN := strings.TrimSpace(os.Getenv("BASE_DN"))
+// dialAny connects to the servers in turn, starting at start, and returns the first connection that binds
+// and passes the health check, with its server's index. Bad credentials fail at once instead of locking
+// the account out on every DC.
+func (c *LDAPClient) dialAny(start int) (*ldap.Conn, int, error) {
+	var errs []error
+	for i := 0; i < len(c.servers); i++ {
+		idx := (start + i) % len(c.servers)
+		s := c.servers[idx]
 
-	url := fmt.Sprintf("ldap://%s:%s", ip, port)
-	tools.Log.WithField("url", url).Debug("Connecting to resolved LDAP IP")
+		conn, err := dial(s, c.sec, c.creds)
+		if err == nil {
+			return conn, idx, nil
+		}
+		if ldap.IsErrorWithCode(err, ldap.LDAPResultInvalidCredentials) {
+			return nil, idx, err
+		}
+		tools.Log.WithFields(map[string]interface{}{
+			"server": s.host,
+			"addr":   s.addr(),
+		}).Warnf("LDAP server unavailable: %v", err)
+		errs = append(errs, fmt.Errorf("%s (%s): %w", s.host, s.addr(), err))
+	}
+	return nil, start, fmt.Errorf("failed to connect to LDAP: %w", errors.Join(errs...))
+}
+
+// dial connects and binds to one server and checks it is fit to use.
+func dial(s server, sec *security, creds *credentials) (*ldap.Conn, error) {
+	// 1. Dial, upgrading with StartTLS if configured
+	scheme := "ldap"
+	opts := []ldap.DialOpt{ldap.DialWithDialer(&net.Dialer{Timeout: dialTimeout})}
+	if sec.mode == SecurityLDAPS {
+		scheme = "ldaps"
+		opts = append(opts, ldap.DialWithTLSConfig(sec.forHost(s.host)))
+	}
+	url := fmt.Sprintf("%s://%s", scheme, s.addr())
+	tools.Log.WithFields(map[string]interface{}{
+		"server":   s.host,
+		"url":      url,
+		"security": sec.mode,
+	}).Debug("Connecting to LDAP server")
 
-	conn, err := ldap.DialURL(url)
+	conn, err := ldap.DialURL(url, opts...)
 	if err != nil {
-		return nil, fmt.Errorf("failed to connect to LDAP: %w", err)
+		return nil, err
 	}
 
-	if err := conn.Bind(user, pass); err != nil {
+	if sec.mode == SecurityStartTLS {
+		if err := conn.StartTLS(sec.forHost(s.host)); err != nil {
+			conn.Close()
+			return nil, fmt.Errorf("StartTLS failed: %w", err)
+		}
+	}
+
+	// 2. Bind
+	if err := creds.bind(conn, s); err != nil {
 		conn.Close()
 		return nil, fmt.Errorf("failed to bind: %w", err)
 	}
 
-	tools.Log.Debug("Successfully bound to LDAP")
+	// 3. Health check: a DC that has not finished its initial replication serves stale data
+	if err := checkHealth(conn); err != nil {
+		conn.Close()
+		return nil, err
+	}
+
+	tools.Log.WithField("server", s.host).Debug("Successfully bound to LDAP")
+	return conn, nil
+}
 
-	return &LDAPClient{
-		Conn:   conn,
-		BaseDN: baseDN,
-	}, nil
+// checkHealth reads the rootDSE and rejects a DC that reports isSynchronized=FALSE.
+func checkHealth(conn *ldap.Conn) error {
+	req := ldap.NewSearchRequest("", ldap.ScopeBaseObject, ldap.NeverDerefAliases, 1, int(dialTimeout/time.Second), false,
+		"(objectClass=*)", []string{"isSynchronized"}, nil)
+	result, err := conn.Search(req)
+	if err != nil {
+		return fmt.Errorf("health check failed: %w", err)
+	}
+	if len(result.Entries) > 0 && strings.EqualFold(result.Entries[0].GetAttributeValue("isSynchronized"), "FALSE") {
+		return fmt.Errorf("health check failed: server is not synchronized")
+	}
+	return nil
 }
 
-// Close cleans up the connection
+// Close closes every idle connection, and each checked-out one as it is returned.
 func (c *LDAPClient) Close() {
-	if c.Conn != nil {
-		c.Conn.Close()
-		tools.Log.Debug("Closed LDAP connection")
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.closed {
+		return
+	}
+	c.closed = true
+	for _, pc := range c.idle {
+		pc.conn.Close()
+		c.open--
 	}
+	c.idle = nil
+	c.ready.Broadcast()
+	tools.Log.Debug("Closed LDAP connections")
 }
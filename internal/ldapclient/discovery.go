@@ -0,0 +1,100 @@
+package ldapclient
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"strings"
+
+	"github.com/matthewdavidson09/dynamic-distro-groups/tools"
+)
+
+// server is one domain controller address to try.
+type server struct {
+	host string // DNS name, which the certificate is verified against
+	ip   string
+	port string
+}
+
+func (s server) addr() string {
+	return net.JoinHostPort(s.ip, s.port)
+}
+
+// discoverServers lists the domain controllers to try, best first:
+//
+//   - with LDAP_DOMAIN, the DCs of LDAP_SITE (_ldap._tcp.<site>._sites.dc._msdcs.<domain>) if set, then
+//     every DC of the domain (_ldap._tcp.<domain>), each ordered by SRV priority and weight, then
+//     LDAP_SERVER if set;
+//   - otherwise every address LDAP_SERVER resolves to.
+//
+// LDAP_PORT overrides the port of every server; without it SRV ports are used, except with ldaps.
+func discoverServers(sec *security) ([]server, error) {
+	domain := strings.TrimSpace(os.Getenv("LDAP_DOMAIN"))
+	site := strings.TrimSpace(os.Getenv("LDAP_SITE"))
+	host := strings.TrimSpace(os.Getenv("LDAP_SERVER"))
+	port := strings.TrimSpace(os.Getenv("LDAP_PORT"))
+
+	if domain == "" && host == "" {
+		return nil, fmt.Errorf("set LDAP_SERVER or LDAP_DOMAIN")
+	}
+
+	var servers []server
+	seen := map[string]bool{}
+	add := func(host, defaultPort string) {
+		if port != "" {
+			defaultPort = port
+		}
+		addrs, err := net.LookupHost(host)
+		if err != nil {
+			tools.Log.Warnf("DNS lookup failed for %s: %v", host, err)
+			return
+		}
+		for _, ip := range addrs {
+			s := server{host: host, ip: ip, port: defaultPort}
+			if !seen[s.addr()] {
+				seen[s.addr()] = true
+				servers = append(servers, s)
+			}
+		}
+	}
+
+	// 1. SRV records. LookupSRV already orders them by priority and shuffles by weight.
+	if domain != "" {
+		var names []string
+		if site != "" {
+			names = append(names, fmt.Sprintf("_ldap._tcp.%s._sites.dc._msdcs.%s", site, domain))
+		}
+		names = append(names, "_ldap._tcp."+domain)
+
+		for _, name := range names {
+			_, records, err := net.LookupSRV("", "", name)
+			if err != nil {
+				tools.Log.Warnf("SRV lookup failed for %s: %v", name, err)
+				continue
+			}
+			for _, srv := range records {
+				srvPort := fmt.Sprint(srv.Port)
+				if sec.mode == SecurityLDAPS {
+					srvPort = sec.defaultPort()
+				}
+				add(strings.TrimSuffix(srv.Target, "."), srvPort)
+			}
+		}
+	}
+
+	// 2. The configured server, as the only choice or the last resort
+	if host != "" {
+		add(host, sec.defaultPort())
+	}
+
+	if len(servers) == 0 {
+		return nil, fmt.Errorf("no LDAP servers found for LDAP_SERVER=%q LDAP_DOMAIN=%q", host, domain)
+	}
+
+	hosts := make([]string, len(servers))
+	for i, s := range servers {
+		hosts[i] = s.host + "@" + s.addr()
+	}
+	tools.Log.WithField("servers", hosts).Debug("Discovered LDAP servers")
+	return servers, nil
+}
@@ -0,0 +1,130 @@
+package ldapclient
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/go-ldap/ldap/v3"
+	"github.com/matthewdavidson09/dynamic-distro-groups/tools"
+)
+
+// maxAttempts is how often an operation is tried before a dropped connection fails it.
+const maxAttempts = 3
+
+// ErrRetried wraps the error of an operation that failed after a dropped connection made it run again.
+// An earlier attempt of a write may have been applied.
+var ErrRetried = errors.New("retried after the connection dropped")
+
+// Search runs a search, reconnecting and retrying if the connection drops.
+func (c *LDAPClient) Search(req *ldap.SearchRequest) (*ldap.SearchResult, error) {
+	var result *ldap.SearchResult
+	err := c.do("search "+req.BaseDN, func(conn *ldap.Conn) error {
+		var err error
+		result, err = conn.Search(req)
+		return err
+	})
+	return result, err
+}
+
+// SearchPaged runs a search in pages of LDAP_PAGE_SIZE entries (RFC 2696) and returns every entry. It
+// never returns a partial result: a failed page fails the search, and a dropped connection restarts it
+// from the first page on another connection, as paging cookies are bound to their connection.
+func (c *LDAPClient) SearchPaged(req *ldap.SearchRequest) (*ldap.SearchResult, error) {
+	var result *ldap.SearchResult
+	err := c.do("paged search "+req.BaseDN, func(conn *ldap.Conn) error {
+		var err error
+		result, err = searchPages(conn, req, c.pageSize)
+		return err
+	})
+	return result, err
+}
+
+func searchPages(conn *ldap.Conn, req *ldap.SearchRequest, pageSize uint32) (*ldap.SearchResult, error) {
+	paging := ldap.NewControlPaging(pageSize)
+	pageReq := *req
+	pageReq.Controls = append(append([]ldap.Control{}, req.Controls...), paging)
+
+	result := &ldap.SearchResult{}
+	for page := 1; ; page++ {
+		res, err := conn.Search(&pageReq)
+		if err != nil {
+			return nil, fmt.Errorf("page %d failed after %d entries: %w", page, len(result.Entries), err)
+		}
+		result.Entries = append(result.Entries, res.Entries...)
+		result.Referrals = append(result.Referrals, res.Referrals...)
+
+		// A server that ignores the control returns everything at once, or fails with sizeLimitExceeded.
+		ctrl, ok := ldap.FindControl(res.Controls, ldap.ControlTypePaging).(*ldap.ControlPaging)
+		if !ok || len(ctrl.Cookie) == 0 {
+			tools.Log.WithFields(map[string]interface{}{
+				"base":    req.BaseDN,
+				"pages":   page,
+				"entries": len(result.Entries),
+			}).Debug("Paged search complete")
+			return result, nil
+		}
+		paging.SetCookie(ctrl.Cookie)
+	}
+}
+
+// Add creates an entry. A retried add that finds the entry already there succeeded before the drop.
+func (c *LDAPClient) Add(req *ldap.AddRequest) error {
+	return c.do("add "+req.DN, func(conn *ldap.Conn) error { return conn.Add(req) }, ldap.LDAPResultEntryAlreadyExists)
+}
+
+// Modify changes an entry. A retried modify of a single value that finds it already added or removed
+// succeeded before the drop. With more values, the same codes only say that one of them was, so they fail
+// the modify and the caller has to check the values one by one.
+func (c *LDAPClient) Modify(req *ldap.ModifyRequest) error {
+	return c.do("modify "+req.DN, func(conn *ldap.Conn) error { return conn.Modify(req) }, modifyApplied(req)...)
+}
+
+// modifyApplied returns the result codes that show a retried modify was applied before the drop.
+func modifyApplied(req *ldap.ModifyRequest) []uint16 {
+	if len(req.Changes) != 1 || len(req.Changes[0].Modification.Vals) != 1 {
+		return nil
+	}
+	return []uint16{ldap.LDAPResultAttributeOrValueExists, ldap.LDAPResultNoSuchAttribute}
+}
+
+// ModifyDN renames or moves an entry. A retried rename that no longer finds the entry succeeded before
+// the drop.
+func (c *LDAPClient) ModifyDN(req *ldap.ModifyDNRequest) error {
+	return c.do("rename "+req.DN, func(conn *ldap.Conn) error { return conn.ModifyDN(req) }, ldap.LDAPResultNoSuchObject)
+}
+
+// Del deletes an entry. A retried delete that no longer finds the entry succeeded before the drop.
+func (c *LDAPClient) Del(req *ldap.DelRequest) error {
+	return c.do("delete "+req.DN, func(conn *ldap.Conn) error { return conn.Del(req) }, ldap.LDAPResultNoSuchObject)
+}
+
+// do runs op on a connection checked out of the pool. If the connection dropped it is discarded and op
+// runs again on another, failing over to the next server if need be. A write may have reached the server
+// before the drop, so on a retry the result codes in applied, which are what repeating a successful write
+// returns, count as success, and any other error of a retry wraps ErrRetried.
+func (c *LDAPClient) do(name string, op func(conn *ldap.Conn) error, applied ...uint16) error {
+	for attempt := 1; ; attempt++ {
+		pc, err := c.get()
+		if err != nil {
+			return err
+		}
+
+		err = op(pc.conn)
+		// A read error closes the connection but reaches pending requests as a plain error.
+		lost := err != nil && (ldap.IsErrorWithCode(err, ldap.ErrorNetwork) || pc.conn.IsClosing())
+		c.put(pc, lost)
+
+		if attempt > 1 && err != nil && ldap.IsErrorAnyOf(err, applied...) {
+			tools.Log.Debugf("LDAP %s was already applied before the connection dropped", name)
+			return nil
+		}
+		if !lost || attempt == maxAttempts {
+			if err != nil && attempt > 1 {
+				return fmt.Errorf("%w: %w", ErrRetried, err)
+			}
+			return err
+		}
+		tools.Log.WithField("server", c.servers[pc.server].host).
+			Warnf("LDAP connection lost during %s, retrying on another connection (attempt %d of %d): %v", name, attempt, maxAttempts, err)
+	}
+}
@@ -0,0 +1,102 @@
+package ldapclient
+
+import (
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/go-ldap/ldap/v3"
+)
+
+func TestModifyApplied(t *testing.T) {
+	one := ldap.NewModifyRequest("CN=g", nil)
+	one.Add("member", []string{"CN=a"})
+
+	many := ldap.NewModifyRequest("CN=g", nil)
+	many.Delete("member", []string{"CN=a", "CN=b"})
+
+	twoChanges := ldap.NewModifyRequest("CN=g", nil)
+	twoChanges.Add("member", []string{"CN=a"})
+	twoChanges.Delete("member", []string{"CN=b"})
+
+	if got := modifyApplied(one); len(got) != 2 {
+		t.Errorf("modifyApplied(one value) = %v, want the exists and no such attribute codes", got)
+	}
+	for name, req := range map[string]*ldap.ModifyRequest{"many values": many, "two changes": twoChanges} {
+		if got := modifyApplied(req); got != nil {
+			t.Errorf("modifyApplied(%s) = %v, want none", name, got)
+		}
+	}
+}
+
+// pagedUsers serves five entries in pages of two. The page with cookie failAt fails, and the first request
+// for the page with cookie dropOnce drops the connection.
+func pagedUsers(f *fakeLDAP, failAt, dropOnce string) {
+	var mu sync.Mutex
+	dropped := false
+	pages := map[string]fakePage{
+		"":   {entries: []*ldap.Entry{ldap.NewEntry("CN=a", nil), ldap.NewEntry("CN=b", nil)}, next: "p2"},
+		"p2": {entries: []*ldap.Entry{ldap.NewEntry("CN=c", nil), ldap.NewEntry("CN=d", nil)}, next: "p3"},
+		"p3": {entries: []*ldap.Entry{ldap.NewEntry("CN=e", nil)}},
+	}
+	f.search = func(base, cookie string) fakePage {
+		mu.Lock()
+		defer mu.Unlock()
+		if cookie == dropOnce && dropOnce != "" && !dropped {
+			dropped = true
+			return fakePage{drop: true}
+		}
+		if cookie == failAt && failAt != "" {
+			return fakePage{code: ldap.LDAPResultBusy}
+		}
+		return pages[cookie]
+	}
+}
+
+func TestSearchPaged(t *testing.T) {
+	tests := []struct {
+		name     string
+		failAt   string
+		dropOnce string
+		wantErr  string
+	}{
+		{name: "every page"},
+		{name: "failed page", failAt: "p2", wantErr: "page 2 failed after 2 entries"},
+		{name: "dropped connection restarts", dropOnce: "p3"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			f := newFakeLDAP(t, "dc1.example.com")
+			pagedUsers(f, tt.failAt, tt.dropOnce)
+			c := newTestClient(t, "2", f)
+
+			req := ldap.NewSearchRequest("OU=Users,DC=example,DC=com", ldap.ScopeWholeSubtree, ldap.NeverDerefAliases, 0, 0, false,
+				"(objectClass=user)", []string{"cn"}, nil)
+			result, err := c.SearchPaged(req)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("SearchPaged() = %v, want error containing %q", err, tt.wantErr)
+				}
+				if result != nil {
+					t.Errorf("SearchPaged() returned %d entries with the error, want no partial result", len(result.Entries))
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			var dns []string
+			for _, e := range result.Entries {
+				dns = append(dns, e.DN)
+			}
+			if got := strings.Join(dns, ","); got != "CN=a,CN=b,CN=c,CN=d,CN=e" {
+				t.Errorf("SearchPaged() = %s, want every entry once", got)
+			}
+			if len(req.Controls) != 0 {
+				t.Errorf("SearchPaged() added controls to the caller's request")
+			}
+		})
+	}
+}
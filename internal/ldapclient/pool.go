@@ -0,0 +1,159 @@
+package ldapclient
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-ldap/ldap/v3"
+	"github.com/matthewdavidson09/dynamic-distro-groups/tools"
+)
+
+const (
+	// defaultPageSize stays below Active Directory's default MaxPageSize of 1000.
+	defaultPageSize    = 500
+	defaultPoolSize    = 10
+	defaultIdleTimeout = 5 * time.Minute
+	// healthCheckAfter is how long a connection may sit idle before it is checked again on checkout.
+	healthCheckAfter = 30 * time.Second
+)
+
+// pooledConn is a bound connection and the server it is bound to.
+type pooledConn struct {
+	conn      *ldap.Conn
+	server    int // index into LDAPClient.servers
+	idleSince time.Time
+}
+
+// pageSize reads LDAP_PAGE_SIZE (default 500).
+func pageSize() (uint32, error) {
+	v := strings.TrimSpace(os.Getenv("LDAP_PAGE_SIZE"))
+	if v == "" {
+		return defaultPageSize, nil
+	}
+	n, err := strconv.ParseUint(v, 10, 32)
+	if err != nil || n < 1 {
+		return 0, fmt.Errorf("invalid LDAP_PAGE_SIZE %q, expected a positive number", v)
+	}
+	return uint32(n), nil
+}
+
+// poolSettings reads LDAP_POOL_SIZE (default 10) and LDAP_POOL_IDLE_TIMEOUT (a Go duration, default 5m).
+func poolSettings() (int, time.Duration, error) {
+	size, idleTTL := defaultPoolSize, defaultIdleTimeout
+	if v := strings.TrimSpace(os.Getenv("LDAP_POOL_SIZE")); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 {
+			return 0, 0, fmt.Errorf("invalid LDAP_POOL_SIZE %q, expected a positive number", v)
+		}
+		size = n
+	}
+	if v := strings.TrimSpace(os.Getenv("LDAP_POOL_IDLE_TIMEOUT")); v != "" {
+		d, err := time.ParseDuration(v)
+		if err != nil || d <= 0 {
+			return 0, 0, fmt.Errorf("invalid LDAP_POOL_IDLE_TIMEOUT %q, expected a duration such as 5m", v)
+		}
+		idleTTL = d
+	}
+	return size, idleTTL, nil
+}
+
+// get checks out a connection: the most recently used idle one that is still healthy, else a new one
+// if the pool has room, else the next one returned.
+func (c *LDAPClient) get() (*pooledConn, error) {
+	c.mu.Lock()
+	for {
+		if c.closed {
+			c.mu.Unlock()
+			return nil, fmt.Errorf("LDAP client is closed")
+		}
+
+		// 1. Reuse an idle connection, dropping expired and broken ones
+		if n := len(c.idle); n > 0 {
+			pc := c.idle[n-1]
+			c.idle = c.idle[:n-1]
+			idleFor := time.Since(pc.idleSince)
+			if idleFor > c.idleTTL || pc.conn.IsClosing() {
+				c.discard(pc)
+				continue
+			}
+			if idleFor < healthCheckAfter {
+				c.mu.Unlock()
+				return pc, nil
+			}
+
+			c.mu.Unlock()
+			err := checkHealth(pc.conn)
+			if err == nil {
+				return pc, nil
+			}
+			tools.Log.WithField("server", c.servers[pc.server].host).Debugf("Dropping idle LDAP connection: %v", err)
+			c.mu.Lock()
+			c.discard(pc)
+			continue
+		}
+
+		// 2. Dial a new one if there is room
+		if c.open < c.size {
+			c.open++
+			start := c.current
+			c.mu.Unlock()
+
+			conn, idx, err := c.dialAny(start)
+
+			c.mu.Lock()
+			if err != nil {
+				c.open--
+				c.ready.Signal()
+				c.mu.Unlock()
+				return nil, err
+			}
+			c.current = idx
+			c.mu.Unlock()
+			return &pooledConn{conn: conn, server: idx}, nil
+		}
+
+		// 3. Wait for one to come back
+		c.ready.Wait()
+	}
+}
+
+// put returns a checked-out connection. A broken one is closed, along with every idle connection to the
+// same server, and new connections fail over to the next server.
+func (c *LDAPClient) put(pc *pooledConn, broken bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	defer c.ready.Signal()
+
+	if !broken && !c.closed {
+		pc.idleSince = time.Now()
+		c.idle = append(c.idle, pc)
+		return
+	}
+
+	c.discard(pc)
+	if !broken {
+		return
+	}
+	kept := c.idle[:0]
+	for _, other := range c.idle {
+		if other.server == pc.server {
+			c.discard(other)
+		} else {
+			kept = append(kept, other)
+		}
+	}
+	c.idle = kept
+	if c.current == pc.server {
+		c.current = (pc.server + 1) % len(c.servers)
+	}
+}
+
+// discard closes a connection that is no longer in the pool. The caller holds c.mu.
+func (c *LDAPClient) discard(pc *pooledConn) {
+	pc.conn.Close()
+	c.open--
+	c.ready.Signal()
+}
@@ -0,0 +1,347 @@
+package ldapclient
+
+import (
+	"net"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	ber "github.com/go-asn1-ber/asn1-ber"
+	"github.com/go-ldap/ldap/v3"
+)
+
+// fakePage is what fakeLDAP answers to one search.
+type fakePage struct {
+	entries []*ldap.Entry
+	next    string // paging cookie of the next page, "" on the last
+	code    uint16 // result code, ldap.LDAPResultSuccess by default
+	drop    bool   // close the connection instead of answering
+}
+
+// fakeLDAP is a minimal LDAP server that accepts any simple bind, answers rootDSE health checks and hands
+// other searches to search with the request's paging cookie.
+type fakeLDAP struct {
+	server server
+	search func(base, cookie string) fakePage
+
+	mu             sync.Mutex
+	dials          int
+	healthChecks   int
+	unsynchronized bool
+}
+
+func newFakeLDAP(t *testing.T, host string) *fakeLDAP {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { ln.Close() })
+
+	ip, port, _ := net.SplitHostPort(ln.Addr().String())
+	f := &fakeLDAP{server: server{host: host, ip: ip, port: port}}
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			f.mu.Lock()
+			f.dials++
+			f.mu.Unlock()
+			go f.serve(conn)
+		}
+	}()
+	return f
+}
+
+func (f *fakeLDAP) serve(conn net.Conn) {
+	defer conn.Close()
+	for {
+		req, err := ber.ReadPacket(conn)
+		if err != nil || len(req.Children) < 2 {
+			return
+		}
+		id, op := req.Children[0].Value, req.Children[1]
+
+		switch op.Tag {
+		case ldap.ApplicationBindRequest:
+			conn.Write(fakeDone(id, ldap.ApplicationBindResponse, ldap.LDAPResultSuccess, "").Bytes())
+
+		case ldap.ApplicationSearchRequest:
+			base, _ := op.Children[0].Value.(string)
+			if base == "" {
+				f.mu.Lock()
+				f.healthChecks++
+				synchronized := "TRUE"
+				if f.unsynchronized {
+					synchronized = "FALSE"
+				}
+				f.mu.Unlock()
+				conn.Write(fakeEntry(id, ldap.NewEntry("", map[string][]string{"isSynchronized": {synchronized}})).Bytes())
+				conn.Write(fakeDone(id, ldap.ApplicationSearchResultDone, ldap.LDAPResultSuccess, "").Bytes())
+				continue
+			}
+
+			var paging *ldap.ControlPaging
+			if len(req.Children) > 2 {
+				for _, child := range req.Children[2].Children {
+					if c, err := ldap.DecodeControl(child); err == nil {
+						if p, ok := c.(*ldap.ControlPaging); ok {
+							paging = p
+						}
+					}
+				}
+			}
+			cookie := ""
+			if paging != nil {
+				cookie = string(paging.Cookie)
+			}
+
+			page := f.search(base, cookie)
+			if page.drop {
+				return
+			}
+			for _, e := range page.entries {
+				conn.Write(fakeEntry(id, e).Bytes())
+			}
+			done := fakeDone(id, ldap.ApplicationSearchResultDone, page.code, "")
+			if paging != nil {
+				controls := ber.Encode(ber.ClassContext, ber.TypeConstructed, 0, nil, "Controls")
+				controls.AppendChild((&ldap.ControlPaging{Cookie: []byte(page.next)}).Encode())
+				done.AppendChild(controls)
+			}
+			conn.Write(done.Bytes())
+
+		case ldap.ApplicationUnbindRequest:
+			return
+		}
+	}
+}
+
+// setUnsynchronized makes the server fail health checks, as a DC still replicating does.
+func (f *fakeLDAP) setUnsynchronized(v bool) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.unsynchronized = v
+}
+
+func (f *fakeLDAP) counts() (dials, healthChecks int) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.dials, f.healthChecks
+}
+
+func fakeMessage(id interface{}, op *ber.Packet) *ber.Packet {
+	msg := ber.Encode(ber.ClassUniversal, ber.TypeConstructed, ber.TagSequence, nil, "LDAP Response")
+	msg.AppendChild(ber.NewInteger(ber.ClassUniversal, ber.TypePrimitive, ber.TagInteger, id, "Message ID"))
+	msg.AppendChild(op)
+	return msg
+}
+
+func fakeDone(id interface{}, tag ber.Tag, code uint16, diagnostic string) *ber.Packet {
+	op := ber.Encode(ber.ClassApplication, ber.TypeConstructed, tag, nil, "Result")
+	op.AppendChild(ber.NewInteger(ber.ClassUniversal, ber.TypePrimitive, ber.TagEnumerated, int64(code), "Result Code"))
+	op.AppendChild(ber.NewString(ber.ClassUniversal, ber.TypePrimitive, ber.TagOctetString, "", "Matched DN"))
+	op.AppendChild(ber.NewString(ber.ClassUniversal, ber.TypePrimitive, ber.TagOctetString, diagnostic, "Diagnostic Message"))
+	return fakeMessage(id, op)
+}
+
+func fakeEntry(id interface{}, e *ldap.Entry) *ber.Packet {
+	op := ber.Encode(ber.ClassApplication, ber.TypeConstructed, ldap.ApplicationSearchResultEntry, nil, "Search Result Entry")
+	op.AppendChild(ber.NewString(ber.ClassUniversal, ber.TypePrimitive, ber.TagOctetString, e.DN, "Object Name"))
+	attrs := ber.Encode(ber.ClassUniversal, ber.TypeConstructed, ber.TagSequence, nil, "Attributes")
+	for _, a := range e.Attributes {
+		attr := ber.Encode(ber.ClassUniversal, ber.TypeConstructed, ber.TagSequence, nil, "Attribute")
+		attr.AppendChild(ber.NewString(ber.ClassUniversal, ber.TypePrimitive, ber.TagOctetString, a.Name, "Type"))
+		values := ber.Encode(ber.ClassUniversal, ber.TypeConstructed, ber.TagSet, nil, "Values")
+		for _, v := range a.Values {
+			values.AppendChild(ber.NewString(ber.ClassUniversal, ber.TypePrimitive, ber.TagOctetString, v, "Value"))
+		}
+		attr.AppendChild(values)
+		attrs.AppendChild(attr)
+	}
+	op.AppendChild(attrs)
+	return fakeMessage(id, op)
+}
+
+// newTestClient connects to the fake servers, in order, with a simple bind over plain LDAP.
+func newTestClient(t *testing.T, poolSize string, fakes ...*fakeLDAP) *LDAPClient {
+	t.Helper()
+	t.Setenv("LDAP_BIND", BindSimple)
+	t.Setenv("LDAP_USER", "svc-sync@example.com")
+	t.Setenv("LDAP_PASSWORD", "secret")
+	t.Setenv("LDAP_POOL_SIZE", poolSize)
+	t.Setenv("LDAP_POOL_IDLE_TIMEOUT", "")
+	t.Setenv("LDAP_PAGE_SIZE", "2")
+
+	var servers []server
+	for _, f := range fakes {
+		servers = append(servers, f.server)
+	}
+	c, err := newClient(servers, &security{mode: SecurityNone, allowInsecureBind: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(c.Close)
+	return c
+}
+
+// age makes every idle connection look idle for d.
+func (c *LDAPClient) age(d time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for _, pc := range c.idle {
+		pc.idleSince = pc.idleSince.Add(-d)
+	}
+}
+
+func TestPoolReusesIdleConnection(t *testing.T) {
+	f := newFakeLDAP(t, "dc1.example.com")
+	c := newTestClient(t, "2", f)
+
+	first, err := c.get()
+	if err != nil {
+		t.Fatal(err)
+	}
+	c.put(first, false)
+
+	again, err := c.get()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if again.conn != first.conn {
+		t.Errorf("get() dialed a new connection, want the idle one reused")
+	}
+	// A connection checked out again at once is not health checked again.
+	if dials, checks := f.counts(); dials != 1 || checks != 1 {
+		t.Errorf("dials %d health checks %d, want 1 and 1", dials, checks)
+	}
+
+	// A second concurrent checkout dials a second connection.
+	second, err := c.get()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if second.conn == again.conn {
+		t.Errorf("get() handed out a connection that is checked out")
+	}
+	if dials, _ := f.counts(); dials != 2 {
+		t.Errorf("dials %d, want 2", dials)
+	}
+	c.put(again, false)
+	c.put(second, false)
+}
+
+func TestPoolWaitsWhenFull(t *testing.T) {
+	f := newFakeLDAP(t, "dc1.example.com")
+	c := newTestClient(t, "1", f)
+
+	held, err := c.get()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	got := make(chan *pooledConn)
+	go func() {
+		pc, err := c.get()
+		if err != nil {
+			t.Error(err)
+		}
+		got <- pc
+	}()
+
+	select {
+	case <-got:
+		t.Fatal("get() returned while the only connection was checked out")
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	c.put(held, false)
+	if pc := <-got; pc == nil || pc.conn != held.conn {
+		t.Errorf("get() = %v, want the returned connection", pc)
+	}
+	if dials, _ := f.counts(); dials != 1 {
+		t.Errorf("dials %d, want 1 with LDAP_POOL_SIZE=1", dials)
+	}
+}
+
+func TestPoolHealthChecksStaleConnection(t *testing.T) {
+	f := newFakeLDAP(t, "dc1.example.com")
+	c := newTestClient(t, "2", f)
+
+	c.age(time.Minute)
+	pc, err := c.get()
+	if err != nil {
+		t.Fatal(err)
+	}
+	c.put(pc, false)
+	if dials, checks := f.counts(); dials != 1 || checks != 2 {
+		t.Errorf("dials %d health checks %d, want the idle connection checked and reused", dials, checks)
+	}
+}
+
+func TestPoolFailsOverFromUnhealthyServer(t *testing.T) {
+	dc1, dc2 := newFakeLDAP(t, "dc1.example.com"), newFakeLDAP(t, "dc2.example.com")
+	c := newTestClient(t, "2", dc1, dc2)
+
+	dc1.setUnsynchronized(true)
+	c.age(time.Minute)
+
+	pc, err := c.get()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.put(pc, false)
+	if pc.server != 1 {
+		t.Errorf("get() connected to server %d, want dc2 after dc1 stopped being synchronized", pc.server)
+	}
+	if c.open != 1 {
+		t.Errorf("open = %d, want the unhealthy connection closed", c.open)
+	}
+}
+
+func TestPoolDropsExpiredConnection(t *testing.T) {
+	f := newFakeLDAP(t, "dc1.example.com")
+	c := newTestClient(t, "2", f)
+
+	c.age(defaultIdleTimeout + time.Minute)
+	pc, err := c.get()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.put(pc, false)
+
+	// The expired connection is closed without a health check and replaced.
+	if dials, checks := f.counts(); dials != 2 || checks != 2 {
+		t.Errorf("dials %d health checks %d, want a second connection dialed", dials, checks)
+	}
+	if c.open != 1 {
+		t.Errorf("open = %d, want 1", c.open)
+	}
+}
+
+func TestPoolSettings(t *testing.T) {
+	t.Setenv("LDAP_POOL_SIZE", "")
+	t.Setenv("LDAP_POOL_IDLE_TIMEOUT", "")
+	size, idleTTL, err := poolSettings()
+	if err != nil || size != defaultPoolSize || idleTTL != defaultIdleTimeout {
+		t.Errorf("poolSettings() = %d, %s, %v, want the defaults", size, idleTTL, err)
+	}
+
+	t.Setenv("LDAP_POOL_SIZE", "4")
+	t.Setenv("LDAP_POOL_IDLE_TIMEOUT", "90s")
+	if size, idleTTL, err = poolSettings(); err != nil || size != 4 || idleTTL != 90*time.Second {
+		t.Errorf("poolSettings() = %d, %s, %v, want 4 and 90s", size, idleTTL, err)
+	}
+
+	for name, value := range map[string]string{"LDAP_POOL_SIZE": "0", "LDAP_POOL_IDLE_TIMEOUT": "5"} {
+		t.Run(name, func(t *testing.T) {
+			t.Setenv(name, value)
+			if _, _, err := poolSettings(); err == nil || !strings.Contains(err.Error(), "invalid "+name) {
+				t.Errorf("poolSettings() = %v, want an error for %s=%s", err, name, value)
+			}
+		})
+	}
+}
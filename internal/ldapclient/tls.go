@@ -0,0 +1,147 @@
+package ldapclient
+
+import (
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/base64"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// Transport security modes, chosen with LDAP_SCHEME and LDAP_STARTTLS.
+const (
+	SecurityNone     = "none"     // plain ldap://
+	SecurityLDAPS    = "ldaps"    // TLS from the first byte, usually port 636
+	SecurityStartTLS = "starttls" // ldap:// upgraded with the StartTLS extended operation
+)
+
+// security is how a connection is protected.
+type security struct {
+	mode              string
+	tls               *tls.Config // nil for SecurityNone; ServerName is set per server by forHost
+	serverName        string      // LDAP_TLS_SERVER_NAME, overriding the server's host name
+	allowInsecureBind bool
+}
+
+// loadSecurity reads the TLS settings from the environment:
+//
+//	LDAP_SCHEME               ldap (default) or ldaps
+//	LDAP_STARTTLS             true to upgrade an ldap:// connection with StartTLS
+//	LDAP_CA_FILE              PEM bundle trusted instead of the system roots
+//	LDAP_TLS_SERVER_NAME      name the certificate must match (default the server's host name, as we dial an IP)
+//	LDAP_TLS_PINS             comma-separated base64 SHA-256 hashes of trusted public keys (SPKI)
+//	LDAP_ALLOW_INSECURE_BIND  true to allow a password bind without TLS
+func loadSecurity() (*security, error) {
+	s := &security{mode: SecurityNone}
+
+	scheme := strings.ToLower(strings.TrimSpace(os.Getenv("LDAP_SCHEME")))
+	startTLS, err := envBool("LDAP_STARTTLS")
+	if err != nil {
+		return nil, err
+	}
+	if s.allowInsecureBind, err = envBool("LDAP_ALLOW_INSECURE_BIND"); err != nil {
+		return nil, err
+	}
+
+	switch scheme {
+	case "", "ldap":
+		if startTLS {
+			s.mode = SecurityStartTLS
+		}
+	case "ldaps":
+		if startTLS {
+			return nil, fmt.Errorf("LDAP_STARTTLS cannot be combined with LDAP_SCHEME=ldaps")
+		}
+		s.mode = SecurityLDAPS
+	default:
+		return nil, fmt.Errorf("invalid LDAP_SCHEME %q, expected ldap or ldaps", scheme)
+	}
+	if s.mode == SecurityNone {
+		return s, nil
+	}
+
+	// 1. Certificate verification
+	s.serverName = strings.TrimSpace(os.Getenv("LDAP_TLS_SERVER_NAME"))
+	s.tls = &tls.Config{MinVersion: tls.VersionTLS12}
+
+	if caFile := strings.TrimSpace(os.Getenv("LDAP_CA_FILE")); caFile != "" {
+		pem, err := os.ReadFile(caFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read LDAP_CA_FILE: %w", err)
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("LDAP_CA_FILE %s contains no PEM certificates", caFile)
+		}
+		s.tls.RootCAs = pool
+	}
+
+	// 2. Optional public key pinning, on top of the chain verification
+	if pins := strings.TrimSpace(os.Getenv("LDAP_TLS_PINS")); pins != "" {
+		allowed := map[string]bool{}
+		for _, pin := range strings.Split(pins, ",") {
+			pin = strings.TrimPrefix(strings.TrimSpace(pin), "sha256/")
+			if raw, err := base64.StdEncoding.DecodeString(pin); err != nil || len(raw) != sha256.Size {
+				return nil, fmt.Errorf("invalid LDAP_TLS_PINS entry %q, expected a base64 SHA-256 hash", pin)
+			}
+			allowed[pin] = true
+		}
+		s.tls.VerifyConnection = func(cs tls.ConnectionState) error {
+			for _, cert := range cs.PeerCertificates {
+				if allowed[spkiPin(cert)] {
+					return nil
+				}
+			}
+			return fmt.Errorf("LDAP server certificate for %s matches none of LDAP_TLS_PINS", cs.ServerName)
+		}
+	}
+
+	return s, nil
+}
+
+// spkiPin is the base64 SHA-256 hash of a certificate's public key, the form used by LDAP_TLS_PINS.
+// Print it for a server with:
+//
+//	openssl s_client -connect dc:636 </dev/null | openssl x509 -pubkey -noout |
+//	  openssl pkey -pubin -outform der | openssl dgst -sha256 -binary | base64
+func spkiPin(cert *x509.Certificate) string {
+	sum := sha256.Sum256(cert.RawSubjectPublicKeyInfo)
+	return base64.StdEncoding.EncodeToString(sum[:])
+}
+
+// forHost returns the TLS configuration for a server, verifying its certificate against host unless
+// LDAP_TLS_SERVER_NAME is set.
+func (s *security) forHost(host string) *tls.Config {
+	if s.tls == nil {
+		return nil
+	}
+	cfg := s.tls.Clone()
+	cfg.ServerName = host
+	if s.serverName != "" {
+		cfg.ServerName = s.serverName
+	}
+	return cfg
+}
+
+// defaultPort is the port used when LDAP_PORT is not set.
+func (s *security) defaultPort() string {
+	if s.mode == SecurityLDAPS {
+		return "636"
+	}
+	return "389"
+}
+
+func envBool(name string) (bool, error) {
+	v := strings.TrimSpace(os.Getenv(name))
+	if v == "" {
+		return false, nil
+	}
+	b, err := strconv.ParseBool(v)
+	if err != nil {
+		return false, fmt.Errorf("invalid %s %q, expected true or false", name, v)
+	}
+	return b, nil
+}
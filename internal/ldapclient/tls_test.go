@@ -0,0 +1,215 @@
+package ldapclient
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// selfSigned returns a throwaway certificate for dc.example.com.
+func selfSigned(t *testing.T) *x509.Certificate {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "dc.example.com"},
+		DNSNames:     []string{"dc.example.com"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return cert
+}
+
+// clearSecurityEnv unsets every variable loadSecurity reads, so the host environment cannot leak in.
+func clearSecurityEnv(t *testing.T) {
+	for _, name := range []string{"LDAP_SCHEME", "LDAP_STARTTLS", "LDAP_CA_FILE", "LDAP_TLS_SERVER_NAME", "LDAP_TLS_PINS", "LDAP_ALLOW_INSECURE_BIND"} {
+		t.Setenv(name, "")
+	}
+}
+
+func TestLoadSecurityMode(t *testing.T) {
+	tests := []struct {
+		name     string
+		scheme   string
+		startTLS string
+		wantMode string
+		wantPort string
+		wantErr  string
+	}{
+		{name: "default", wantMode: SecurityNone, wantPort: "389"},
+		{name: "ldap", scheme: "LDAP", wantMode: SecurityNone, wantPort: "389"},
+		{name: "starttls", startTLS: "true", wantMode: SecurityStartTLS, wantPort: "389"},
+		{name: "ldaps", scheme: "ldaps", wantMode: SecurityLDAPS, wantPort: "636"},
+		{name: "ldaps with starttls", scheme: "ldaps", startTLS: "true", wantErr: "cannot be combined"},
+		{name: "bad scheme", scheme: "https", wantErr: `invalid LDAP_SCHEME "https"`},
+		{name: "bad starttls", startTLS: "yes please", wantErr: "invalid LDAP_STARTTLS"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			clearSecurityEnv(t)
+			t.Setenv("LDAP_SCHEME", tt.scheme)
+			t.Setenv("LDAP_STARTTLS", tt.startTLS)
+
+			s, err := loadSecurity()
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("loadSecurity() = %v, want error containing %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if s.mode != tt.wantMode || s.defaultPort() != tt.wantPort {
+				t.Errorf("loadSecurity() mode %q port %s, want %q and %s", s.mode, s.defaultPort(), tt.wantMode, tt.wantPort)
+			}
+			if (s.tls == nil) != (tt.wantMode == SecurityNone) {
+				t.Errorf("loadSecurity() tls config %v for mode %q", s.tls, s.mode)
+			}
+		})
+	}
+}
+
+func TestLoadSecurityServerName(t *testing.T) {
+	clearSecurityEnv(t)
+	t.Setenv("LDAP_SCHEME", "ldaps")
+
+	s, err := loadSecurity()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := s.forHost("dc1.example.com").ServerName; got != "dc1.example.com" {
+		t.Errorf("forHost() ServerName = %q, want the server's host name", got)
+	}
+
+	t.Setenv("LDAP_TLS_SERVER_NAME", "ldap.example.com")
+	if s, err = loadSecurity(); err != nil {
+		t.Fatal(err)
+	}
+	if got := s.forHost("dc1.example.com").ServerName; got != "ldap.example.com" {
+		t.Errorf("forHost() ServerName = %q, want LDAP_TLS_SERVER_NAME", got)
+	}
+	if s.tls.ServerName != "" {
+		t.Errorf("forHost() changed the shared config")
+	}
+}
+
+func TestLoadSecurityCAFile(t *testing.T) {
+	cert := selfSigned(t)
+	dir := t.TempDir()
+	good := filepath.Join(dir, "ca.pem")
+	if err := os.WriteFile(good, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: cert.Raw}), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	bad := filepath.Join(dir, "bad.pem")
+	if err := os.WriteFile(bad, []byte("not a certificate"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	clearSecurityEnv(t)
+	t.Setenv("LDAP_STARTTLS", "true")
+
+	t.Setenv("LDAP_CA_FILE", good)
+	s, err := loadSecurity()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s.tls.RootCAs == nil {
+		t.Errorf("loadSecurity() did not trust LDAP_CA_FILE")
+	}
+
+	t.Setenv("LDAP_CA_FILE", bad)
+	if _, err := loadSecurity(); err == nil || !strings.Contains(err.Error(), "contains no PEM certificates") {
+		t.Errorf("loadSecurity() = %v, want an error for a file without certificates", err)
+	}
+
+	t.Setenv("LDAP_CA_FILE", filepath.Join(dir, "missing.pem"))
+	if _, err := loadSecurity(); err == nil || !strings.Contains(err.Error(), "failed to read LDAP_CA_FILE") {
+		t.Errorf("loadSecurity() = %v, want an error for a missing file", err)
+	}
+}
+
+func TestLoadSecurityPins(t *testing.T) {
+	cert, other := selfSigned(t), selfSigned(t)
+	state := tls.ConnectionState{ServerName: "dc.example.com", PeerCertificates: []*x509.Certificate{cert}}
+
+	tests := []struct {
+		name      string
+		pins      string
+		wantErr   string
+		wantMatch bool
+	}{
+		{name: "matching pin", pins: spkiPin(cert), wantMatch: true},
+		{name: "sha256 prefix and a second pin", pins: "sha256/" + spkiPin(other) + ", sha256/" + spkiPin(cert), wantMatch: true},
+		{name: "other key", pins: spkiPin(other)},
+		{name: "not base64", pins: "not-a-pin!", wantErr: "invalid LDAP_TLS_PINS entry"},
+		{name: "wrong length", pins: "c2hvcnQ=", wantErr: "invalid LDAP_TLS_PINS entry"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			clearSecurityEnv(t)
+			t.Setenv("LDAP_SCHEME", "ldaps")
+			t.Setenv("LDAP_TLS_PINS", tt.pins)
+
+			s, err := loadSecurity()
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("loadSecurity() = %v, want error containing %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			// The pin check must survive the per-server clone.
+			err = s.forHost("dc.example.com").VerifyConnection(state)
+			if tt.wantMatch && err != nil {
+				t.Errorf("VerifyConnection() = %v, want the pinned key accepted", err)
+			}
+			if !tt.wantMatch && (err == nil || !strings.Contains(err.Error(), "matches none of LDAP_TLS_PINS")) {
+				t.Errorf("VerifyConnection() = %v, want the unpinned key rejected", err)
+			}
+		})
+	}
+}
+
+func TestNewClientRefusesCleartextPassword(t *testing.T) {
+	t.Setenv("LDAP_BIND", BindSimple)
+	t.Setenv("LDAP_USER", "svc-sync@example.com")
+	t.Setenv("LDAP_PASSWORD", "secret")
+
+	// No servers: the refusal must come before any dial.
+	_, err := newClient(nil, &security{mode: SecurityNone})
+	if err == nil || !strings.Contains(err.Error(), "refusing to bind with a password over unencrypted LDAP") {
+		t.Errorf("newClient() = %v, want the cleartext bind refused", err)
+	}
+
+	_, err = newClient(nil, &security{mode: SecurityNone, allowInsecureBind: true})
+	if err != nil && strings.Contains(err.Error(), "refusing to bind") {
+		t.Errorf("newClient() = %v, want LDAP_ALLOW_INSECURE_BIND to allow the bind", err)
+	}
+}
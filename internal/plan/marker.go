@@ -0,0 +1,23 @@
+package plan
+
+import "regexp"
+
+// markerPrefix identifies groups this tool owns.
+const markerPrefix = "managed-by:dynamic-distro-groups"
+
+var ownerMarkerPattern = regexp.MustCompile(regexp.QuoteMeta(markerPrefix) + ` rule=([^\s\]]+)`)
+
+// OwnerMarker is the ownership marker recorded on a group managed by the given rule. AD keeps it in
+// adminDescription, Google in the group description.
+func OwnerMarker(ruleID string) string {
+	return markerPrefix + " rule=" + ruleID
+}
+
+// ParseOwnerMarker returns the rule ID recorded in s by OwnerMarker.
+func ParseOwnerMarker(s string) (string, bool) {
+	m := ownerMarkerPattern.FindStringSubmatch(s)
+	if m == nil {
+		return "", false
+	}
+	return m[1], true
+}
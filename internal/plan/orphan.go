@@ -0,0 +1,78 @@
+package plan
+
+import (
+	"regexp"
+	"strings"
+	"time"
+)
+
+// Orphan actions.
+const (
+	OrphanRetire = "retire" // empty, hide, stamp and archive the group
+	OrphanDelete = "delete"
+)
+
+// DateLayout is the format of orphan dates.
+const DateLayout = "2006-01-02"
+
+var orphanStampPattern = regexp.MustCompile(`\s*\[orphaned (\d{4}-\d{2}-\d{2})\]`)
+
+// OrphanPlan is a managed group that no rule produces any more, and what to do with it.
+type OrphanPlan struct {
+	Target        string   `json:"target"` // ad or google
+	Mail          string   `json:"mail"`
+	DN            string   `json:"dn,omitempty"` // AD only
+	Action        string   `json:"action"`
+	OrphanedSince string   `json:"orphaned_since"`    // date the group was first found orphaned
+	Stamp         bool     `json:"stamp,omitempty"`   // record OrphanedSince on the group
+	Hide          bool     `json:"hide,omitempty"`    // hide the group from address lists
+	MoveTo        string   `json:"move_to,omitempty"` // AD OU to archive the group in
+	RemoveMembers []string `json:"remove_members,omitempty"`
+	MemberCount   int      `json:"member_count"` // live members when planned
+	MembersHash   string   `json:"members_hash"` // hash of the live members when planned
+}
+
+// Removals is how many memberships the action ends.
+func (o OrphanPlan) Removals() int {
+	if o.Action == OrphanDelete {
+		return o.MemberCount
+	}
+	return len(o.RemoveMembers)
+}
+
+// OrphanStamp is the marker recorded on a group when it is first found orphaned.
+func OrphanStamp(date string) string {
+	return "[orphaned " + date + "]"
+}
+
+// ParseOrphanStamp returns the date recorded in s by OrphanStamp.
+func ParseOrphanStamp(s string) (string, bool) {
+	m := orphanStampPattern.FindStringSubmatch(s)
+	if m == nil {
+		return "", false
+	}
+	return m[1], true
+}
+
+// StripOrphanStamp removes the OrphanStamp marker from s.
+func StripOrphanStamp(s string) string {
+	return strings.TrimSpace(orphanStampPattern.ReplaceAllString(s, ""))
+}
+
+// AddOrphanStamp records the OrphanStamp marker for date at the end of s, replacing any earlier one and
+// keeping the rest of s.
+func AddOrphanStamp(s, date string) string {
+	return strings.TrimSpace(StripOrphanStamp(s) + " " + OrphanStamp(date))
+}
+
+// DeleteDue reports whether a group orphaned since date is due for deletion after days. Zero days never is.
+func DeleteDue(date string, days int, now time.Time) bool {
+	if days <= 0 {
+		return false
+	}
+	since, err := time.Parse(DateLayout, date)
+	if err != nil {
+		return false
+	}
+	return !now.Before(since.AddDate(0, 0, days))
+}
@@ -0,0 +1,193 @@
+// Package plan describes the changes a sync would make, in a form that can be saved, reviewed and applied later.
+package plan
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Version is the plan file format version. Apply refuses plans with a different version.
+const Version = 1
+
+// ErrDrift is returned when the live state no longer matches what a plan was made against.
+var ErrDrift = errors.New("live state changed since the plan was made")
+
+// Plan is every change for one run, across AD and Google.
+type Plan struct {
+	Version     int          `json:"version"`
+	CreatedAt   time.Time    `json:"created_at"`
+	SourceUsers int          `json:"source_users"` // users returned by the LDAP search the plan was made from
+	Groups      []GroupPlan  `json:"groups"`
+	Orphans     []OrphanPlan `json:"orphans,omitempty"` // managed groups no rule produces any more
+}
+
+// GroupPlan holds the changes for one managed group.
+type GroupPlan struct {
+	RuleID     string      `json:"rule_id"`
+	Value      string      `json:"value"`                 // grouping value
+	Key        string      `json:"key"`                   // state key of the group
+	RenamedKey string      `json:"renamed_key,omitempty"` // state key the group was recorded under before a rename
+	Mail       string      `json:"mail"`
+	TotalUsers int         `json:"total_users"`
+	AD         *ADPlan     `json:"ad,omitempty"`
+	Google     *GooglePlan `json:"google,omitempty"`
+}
+
+// ADPlan holds the changes for a group in Active Directory.
+type ADPlan struct {
+	DN            string            `json:"dn"`                       // existing or to-be-created DN
+	ObjectGUID    string            `json:"object_guid,omitempty"`    // of the existing group
+	Rename        string            `json:"rename,omitempty"`         // new CN for a group whose name changed
+	Create        *ADGroup          `json:"create,omitempty"`         // set when the group does not exist yet
+	SetAttributes map[string]string `json:"set_attributes,omitempty"` // attribute fixes on an existing group
+	AddMembers    []string          `json:"add_members,omitempty"`    // member DNs
+	RemoveMembers []string          `json:"remove_members,omitempty"` // member DNs
+	MoveTo        string            `json:"move_to,omitempty"`        // OU to move an archived group back to
+	MemberCount   int               `json:"member_count"`             // live members when planned
+	MembersHash   string            `json:"members_hash"`             // hash of the live members when planned
+	DesiredHash   string            `json:"desired_hash,omitempty"`   // hash of the members once applied
+}
+
+// ADGroup is the attributes of a group to create.
+type ADGroup struct {
+	CN             string `json:"cn"`
+	SAMAccountName string `json:"sam_account_name"`
+	Mail           string `json:"mail"`
+	DisplayName    string `json:"display_name"`
+	Description    string `json:"description"`
+	Owner          string `json:"owner"` // rule ID recorded in the ownership marker
+}
+
+// GooglePlan holds the changes for a group in Google Workspace.
+type GooglePlan struct {
+	ID            string            `json:"id,omitempty"`          // of the existing group
+	Create        *GoogleGroup      `json:"create,omitempty"`      // set when the group does not exist yet
+	RenameFrom    string            `json:"rename_from,omitempty"` // current address of a group whose mail changed; kept as an alias
+	AddMembers    []Member          `json:"add_members,omitempty"`
+	UpdateRoles   []Member          `json:"update_roles,omitempty"`
+	RemoveMembers []string          `json:"remove_members,omitempty"`
+	Settings      map[string]string `json:"settings,omitempty"`     // Groups Settings API fields to change
+	Description   string            `json:"description,omitempty"`  // new description, set when reviving an orphan
+	MemberCount   int               `json:"member_count"`           // live members when planned
+	MembersHash   string            `json:"members_hash"`           // hash of the live members and roles when planned
+	DesiredHash   string            `json:"desired_hash,omitempty"` // hash of the members and roles once applied
+}
+
+// GoogleGroup is the group to create.
+type GoogleGroup struct {
+	Name        string `json:"name"`
+	Description string `json:"description"`
+}
+
+// Member is a Google group member and role.
+type Member struct {
+	Email string `json:"email"`
+	Role  string `json:"role"`
+}
+
+// Empty reports whether the AD plan changes nothing.
+func (p *ADPlan) Empty() bool {
+	return p == nil || (p.Create == nil && p.Rename == "" && p.MoveTo == "" && len(p.SetAttributes) == 0 &&
+		len(p.AddMembers) == 0 && len(p.RemoveMembers) == 0)
+}
+
+// Empty reports whether the Google plan changes nothing.
+func (p *GooglePlan) Empty() bool {
+	return p == nil || (p.Create == nil && p.RenameFrom == "" && len(p.AddMembers) == 0 && len(p.UpdateRoles) == 0 &&
+		len(p.RemoveMembers) == 0 && len(p.Settings) == 0 && p.Description == "")
+}
+
+// Empty reports whether the group plan changes nothing.
+func (g GroupPlan) Empty() bool {
+	return g.AD.Empty() && g.Google.Empty()
+}
+
+// Changes returns the groups that have at least one change.
+func (p *Plan) Changes() []GroupPlan {
+	var changed []GroupPlan
+	for _, g := range p.Groups {
+		if !g.Empty() {
+			changed = append(changed, g)
+		}
+	}
+	return changed
+}
+
+// HasChanges reports whether applying the plan would change anything.
+func (p *Plan) HasChanges() bool {
+	return len(p.Changes()) > 0 || len(p.Orphans) > 0
+}
+
+// TouchesGoogle reports whether applying the plan reads or writes Google groups.
+func (p *Plan) TouchesGoogle() bool {
+	for _, g := range p.Groups {
+		if !g.Google.Empty() {
+			return true
+		}
+	}
+	for _, o := range p.Orphans {
+		if o.Target == "google" {
+			return true
+		}
+	}
+	return false
+}
+
+// Sort orders groups and orphans by mail so plan files diff cleanly.
+func (p *Plan) Sort() {
+	sort.Slice(p.Groups, func(i, j int) bool { return p.Groups[i].Mail < p.Groups[j].Mail })
+	sort.Slice(p.Orphans, func(i, j int) bool {
+		if p.Orphans[i].Mail != p.Orphans[j].Mail {
+			return p.Orphans[i].Mail < p.Orphans[j].Mail
+		}
+		return p.Orphans[i].Target < p.Orphans[j].Target
+	})
+}
+
+// Write saves the plan as indented JSON.
+func (p *Plan) Write(path string) error {
+	data, err := json.MarshalIndent(p, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to encode plan: %w", err)
+	}
+	if err := os.WriteFile(path, append(data, '\n'), 0o600); err != nil {
+		return fmt.Errorf("failed to write plan: %w", err)
+	}
+	return nil
+}
+
+// Read loads a plan file and checks its version.
+func Read(path string) (*Plan, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read plan: %w", err)
+	}
+
+	var p Plan
+	if err := json.Unmarshal(data, &p); err != nil {
+		return nil, fmt.Errorf("failed to parse plan %s: %w", path, err)
+	}
+	if p.Version != Version {
+		return nil, fmt.Errorf("plan %s has version %d, this build applies version %d", path, p.Version, Version)
+	}
+	return &p, nil
+}
+
+// HashMembers returns a stable hash of a member set. Entries are normalized to lower case.
+func HashMembers(members []string) string {
+	normalized := make([]string, len(members))
+	for i, m := range members {
+		normalized[i] = strings.ToLower(strings.TrimSpace(m))
+	}
+	sort.Strings(normalized)
+
+	sum := sha256.Sum256([]byte(strings.Join(normalized, "\n")))
+	return hex.EncodeToString(sum[:16])
+}
@@ -0,0 +1,164 @@
+package plan
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func samplePlan() *Plan {
+	return &Plan{
+		Version:     Version,
+		CreatedAt:   time.Date(2025, 3, 1, 12, 0, 0, 0, time.UTC),
+		SourceUsers: 1200,
+		Groups: []GroupPlan{
+			{
+				RuleID:     "departments",
+				Value:      "Sales",
+				Key:        "departments/Sales",
+				RenamedKey: "departments/sales",
+				Mail:       "list-dept-sales@example.com",
+				TotalUsers: 3,
+				AD: &ADPlan{
+					DN:            "CN=list-dept-sales,OU=Groups,DC=example,DC=com",
+					ObjectGUID:    "0f3c",
+					SetAttributes: map[string]string{"displayName": "Sales"},
+					AddMembers:    []string{"CN=Ann,DC=example,DC=com"},
+					RemoveMembers: []string{"CN=Bob,DC=example,DC=com"},
+					MemberCount:   2,
+					MembersHash:   HashMembers([]string{"CN=Bob,DC=example,DC=com", "CN=Cid,DC=example,DC=com"}),
+					DesiredHash:   HashMembers([]string{"CN=Ann,DC=example,DC=com", "CN=Cid,DC=example,DC=com"}),
+				},
+				Google: &GooglePlan{
+					ID:            "03abc",
+					RenameFrom:    "list-dept-old-sales@example.com",
+					AddMembers:    []Member{{Email: "ann@example.com", Role: "MEMBER"}},
+					UpdateRoles:   []Member{{Email: "cid@example.com", Role: "MANAGER"}},
+					RemoveMembers: []string{"bob@example.com"},
+					Settings:      map[string]string{"whoCanPostMessage": "ALL_IN_DOMAIN_CAN_POST"},
+					MemberCount:   2,
+					MembersHash:   "aa",
+					DesiredHash:   "bb",
+				},
+			},
+			{
+				RuleID: "states",
+				Value:  "TX",
+				Key:    "states/TX",
+				Mail:   "list-state-tx@example.com",
+				AD: &ADPlan{
+					DN: "CN=list-state-tx,OU=Groups,DC=example,DC=com",
+					Create: &ADGroup{
+						CN:             "list-state-tx",
+						SAMAccountName: "list-state-tx",
+						Mail:           "list-state-tx@example.com",
+						DisplayName:    "State: TX",
+						Description:    "State: TX distro group",
+						Owner:          "states",
+					},
+				},
+				Google: &GooglePlan{Create: &GoogleGroup{Name: "State: TX", Description: "State: TX distro group"}},
+			},
+		},
+		Orphans: []OrphanPlan{{Target: "google", Mail: "list-state-nv@example.com", Action: OrphanDelete}},
+	}
+}
+
+func TestWriteReadRoundTrip(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "plan.json")
+	want := samplePlan()
+	if err := want.Write(path); err != nil {
+		t.Fatal(err)
+	}
+
+	got, err := Read(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("Read() = %+v, want %+v", got, want)
+	}
+}
+
+func TestReadRejectsOtherVersions(t *testing.T) {
+	for _, version := range []int{0, Version + 1} {
+		p := samplePlan()
+		p.Version = version
+		path := filepath.Join(t.TempDir(), "plan.json")
+		if err := p.Write(path); err != nil {
+			t.Fatal(err)
+		}
+
+		_, err := Read(path)
+		if err == nil || !strings.Contains(err.Error(), "this build applies version") {
+			t.Errorf("Read() of version %d = %v, want a version error", version, err)
+		}
+	}
+}
+
+func TestReadRejectsBrokenFiles(t *testing.T) {
+	dir := t.TempDir()
+	if _, err := Read(filepath.Join(dir, "missing.json")); err == nil {
+		t.Error("Read() of a missing file succeeded")
+	}
+
+	path := filepath.Join(dir, "plan.json")
+	if err := os.WriteFile(path, []byte(`{"version": 1, "groups": [`), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := Read(path); err == nil || !strings.Contains(err.Error(), "failed to parse plan") {
+		t.Errorf("Read() of truncated JSON = %v, want a parse error", err)
+	}
+}
+
+func TestHashMembers(t *testing.T) {
+	a := HashMembers([]string{"CN=Ann,DC=example,DC=com", " cn=bob,dc=example,dc=com"})
+	b := HashMembers([]string{"cn=bob,dc=example,dc=com", "CN=ANN,DC=EXAMPLE,DC=COM "})
+	if a != b {
+		t.Errorf("HashMembers depends on order or case: %s != %s", a, b)
+	}
+	if c := HashMembers([]string{"cn=bob,dc=example,dc=com"}); c == a {
+		t.Error("HashMembers ignores a missing member")
+	}
+}
+
+func TestChanges(t *testing.T) {
+	p := samplePlan()
+	p.Groups = append(p.Groups, GroupPlan{Key: "states/CA", AD: &ADPlan{DN: "CN=x"}, Google: &GooglePlan{ID: "1"}})
+	if got := len(p.Changes()); got != 2 {
+		t.Errorf("Changes() returned %d groups, want 2 without the unchanged one", got)
+	}
+	if !p.TouchesGoogle() {
+		t.Error("TouchesGoogle() = false for a plan with Google changes")
+	}
+
+	adOnly := &Plan{Groups: []GroupPlan{{AD: &ADPlan{AddMembers: []string{"CN=x"}}, Google: &GooglePlan{ID: "1"}}}}
+	if adOnly.TouchesGoogle() || !adOnly.HasChanges() {
+		t.Error("a plan with only AD changes touches Google or has no changes")
+	}
+}
+
+func TestOrphanStamp(t *testing.T) {
+	tests := []struct {
+		in, want string
+	}{
+		{"", "[orphaned 2025-03-01]"},
+		{"Owned by the finance team", "Owned by the finance team [orphaned 2025-03-01]"},
+		{"Owned by the finance team [orphaned 2024-12-24]", "Owned by the finance team [orphaned 2025-03-01]"},
+	}
+	for _, tt := range tests {
+		got := AddOrphanStamp(tt.in, "2025-03-01")
+		if got != tt.want {
+			t.Errorf("AddOrphanStamp(%q) = %q, want %q", tt.in, got, tt.want)
+		}
+		if since, ok := ParseOrphanStamp(got); !ok || since != "2025-03-01" {
+			t.Errorf("ParseOrphanStamp(%q) = %q, %v", got, since, ok)
+		}
+		if stripped, notes := StripOrphanStamp(got), StripOrphanStamp(tt.in); stripped != notes {
+			t.Errorf("StripOrphanStamp(%q) = %q, want the notes %q back", got, stripped, notes)
+		}
+	}
+}
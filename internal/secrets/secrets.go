@@ -0,0 +1,111 @@
+// Package secrets resolves credentials given in the environment as references to files, other
+// environment variables or commands, and keeps the resolved values out of the log.
+package secrets
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"os"
+	"os/exec"
+	"strings"
+	"time"
+
+	"github.com/matthewdavidson09/dynamic-distro-groups/tools"
+)
+
+// Reference prefixes. A value without one is the secret itself.
+const (
+	FilePrefix = "file://" // file://<path>: the contents of a file, e.g. a Docker or Kubernetes secret
+	EnvPrefix  = "env:"    // env:<NAME>: the value of another environment variable
+	ExecPrefix = "exec:"   // exec:<command>: the standard output of a shell command
+)
+
+// execTimeout bounds how long an exec: command may run.
+const execTimeout = 30 * time.Second
+
+// Env resolves the secret referenced by the environment variable name and registers it for
+// redaction. An unset variable resolves to "".
+func Env(name string) (string, error) {
+	ref := strings.TrimSpace(os.Getenv(name))
+	if ref == "" {
+		return "", nil
+	}
+
+	value, err := Resolve(ref)
+	if err != nil {
+		return "", fmt.Errorf("failed to resolve %s: %w", name, err)
+	}
+	return value, nil
+}
+
+// Resolve returns the secret ref refers to and registers it for redaction. One trailing newline is
+// dropped from file contents and command output.
+func Resolve(ref string) (string, error) {
+	var value string
+	switch {
+	case strings.HasPrefix(ref, FilePrefix):
+		data, err := os.ReadFile(strings.TrimPrefix(ref, FilePrefix))
+		if err != nil {
+			return "", fmt.Errorf("failed to read secret file: %w", err)
+		}
+		value = trimNewline(string(data))
+
+	case strings.HasPrefix(ref, EnvPrefix):
+		name := strings.TrimPrefix(ref, EnvPrefix)
+		v, ok := os.LookupEnv(name)
+		if !ok {
+			return "", fmt.Errorf("secret variable %s is not set", name)
+		}
+		value = v
+
+	case strings.HasPrefix(ref, ExecPrefix):
+		out, err := run(strings.TrimSpace(strings.TrimPrefix(ref, ExecPrefix)))
+		if err != nil {
+			return "", err
+		}
+		value = trimNewline(out)
+
+	default:
+		value = ref
+	}
+
+	if value == "" {
+		return "", fmt.Errorf("secret is empty")
+	}
+	tools.RedactSecret(value)
+	return value, nil
+}
+
+// run runs command with the shell and returns its standard output.
+func run(command string) (string, error) {
+	if command == "" {
+		return "", fmt.Errorf("exec: secret has no command")
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), execTimeout)
+	defer cancel()
+
+	var stdout, stderr bytes.Buffer
+	cmd := exec.CommandContext(ctx, "sh", "-c", command)
+	cmd.Stdout, cmd.Stderr = &stdout, &stderr
+
+	if err := cmd.Run(); err != nil {
+		// The command line is left out: it may carry a token of its own.
+		if msg := strings.TrimSpace(stderr.String()); msg != "" {
+			return "", fmt.Errorf("secret command failed: %w: %s", err, firstLine(msg))
+		}
+		return "", fmt.Errorf("secret command failed: %w", err)
+	}
+	return stdout.String(), nil
+}
+
+func trimNewline(s string) string {
+	s = strings.TrimSuffix(s, "\n")
+	return strings.TrimSuffix(s, "\r")
+}
+
+func firstLine(s string) string {
+	line, _, _ := strings.Cut(s, "\n")
+	return line
+}
@@ -0,0 +1,75 @@
+package secrets
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/matthewdavidson09/dynamic-distro-groups/tools"
+)
+
+func TestResolve(t *testing.T) {
+	dir := t.TempDir()
+	file := filepath.Join(dir, "password")
+	if err := os.WriteFile(file, []byte("from-a-file\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	empty := filepath.Join(dir, "empty")
+	if err := os.WriteFile(empty, []byte("\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("SECRETS_TEST_VALUE", "from-another-variable")
+	os.Unsetenv("SECRETS_TEST_UNSET")
+
+	tests := []struct {
+		name    string
+		ref     string
+		want    string
+		wantErr string
+	}{
+		{name: "plain value", ref: "plain-secret", want: "plain-secret"},
+		{name: "file", ref: FilePrefix + file, want: "from-a-file"},
+		{name: "missing file", ref: FilePrefix + filepath.Join(dir, "missing"), wantErr: "failed to read secret file"},
+		{name: "empty file", ref: FilePrefix + empty, wantErr: "secret is empty"},
+		{name: "env", ref: EnvPrefix + "SECRETS_TEST_VALUE", want: "from-another-variable"},
+		{name: "unset env", ref: EnvPrefix + "SECRETS_TEST_UNSET", wantErr: "secret variable SECRETS_TEST_UNSET is not set"},
+		{name: "exec", ref: ExecPrefix + " printf 'from-a-command\\r\\n'", want: "from-a-command"},
+		{name: "exec keeps inner newlines", ref: ExecPrefix + "printf 'line-one\\nline-two\\n'", want: "line-one\nline-two"},
+		{name: "exec failure", ref: ExecPrefix + "echo 'vault is sealed' >&2; exit 3", wantErr: "secret command failed: exit status 3: vault is sealed"},
+		{name: "exec without command", ref: ExecPrefix + " ", wantErr: "exec: secret has no command"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := Resolve(tt.ref)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("Resolve() = %q, %v, want error containing %q", got, err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got != tt.want {
+				t.Errorf("Resolve() = %q, want %q", got, tt.want)
+			}
+			if redacted := tools.Redact("value " + got); redacted != "value [REDACTED]" {
+				t.Errorf("Redact() = %q, want the resolved secret registered", redacted)
+			}
+		})
+	}
+}
+
+func TestEnv(t *testing.T) {
+	t.Setenv("SECRETS_TEST_REF", "env:SECRETS_TEST_MISSING")
+	if _, err := Env("SECRETS_TEST_REF"); err == nil || !strings.Contains(err.Error(), "failed to resolve SECRETS_TEST_REF") {
+		t.Errorf("Env() = %v, want the variable named in the error", err)
+	}
+
+	t.Setenv("SECRETS_TEST_REF", "  ")
+	if got, err := Env("SECRETS_TEST_REF"); got != "" || err != nil {
+		t.Errorf("Env() = %q, %v, want an unset secret to resolve to nothing", got, err)
+	}
+}
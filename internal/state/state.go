@@ -0,0 +1,147 @@
+// Package state persists what the tool knows about the groups it manages between runs.
+package state
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+)
+
+// MaxRuns is how many runs the history keeps; older ones are dropped.
+const MaxRuns = 100
+
+// Run outcomes.
+const (
+	OutcomeSuccess = "success"
+	OutcomeFailed  = "failed"  // some groups or rules failed
+	OutcomeAborted = "aborted" // nothing was written: safety limits or drift
+)
+
+// Store is the state file. It is loaded whole, changed in memory and written back with Save.
+type Store struct {
+	Groups map[string]*Group `json:"groups"`         // keyed by Key(rule, value)
+	Runs   []Run             `json:"runs,omitempty"` // oldest first
+
+	path string
+}
+
+// Group is a managed group and its IDs in each target. The IDs survive renames, unlike mail and CN.
+type Group struct {
+	RuleID       string `json:"rule_id"`
+	Value        string `json:"value"` // grouping value the group was produced for
+	Mail         string `json:"mail"`
+	ADObjectGUID string `json:"ad_object_guid,omitempty"`
+	GoogleID     string `json:"google_id,omitempty"`
+	// Membership hashes (see plan.HashMembers) as of the last successful sync of each target.
+	ADMembersHash     string    `json:"ad_members_hash,omitempty"`
+	GoogleMembersHash string    `json:"google_members_hash,omitempty"`
+	LastSyncAt        time.Time `json:"last_sync_at"`         // last run that synced the group without errors
+	LastError         string    `json:"last_error,omitempty"` // of the last run, if it failed
+	UpdatedAt         time.Time `json:"updated_at"`
+}
+
+// Run is one sync or apply that got as far as a plan, with its outcome.
+type Run struct {
+	Command     string    `json:"command"` // sync or apply
+	StartedAt   time.Time `json:"started_at"`
+	FinishedAt  time.Time `json:"finished_at"`
+	Outcome     string    `json:"outcome"`
+	Error       string    `json:"error,omitempty"`
+	Forced      bool      `json:"forced,omitempty"` // safety limits overridden
+	SourceUsers int       `json:"source_users"`
+	Groups      int       `json:"groups"`  // groups in the plan
+	Changed     int       `json:"changed"` // groups whose changes were applied
+	Failed      int       `json:"failed"`  // groups that failed to apply
+	Added       int       `json:"added"`   // members added across targets
+	Removed     int       `json:"removed"` // members removed across targets
+	Orphans     int       `json:"orphans"` // orphan lifecycle actions applied
+}
+
+// Key identifies the group a rule produces for a grouping value.
+func Key(ruleID, value string) string {
+	return ruleID + "/" + value
+}
+
+// Open loads the state file at path. A missing file is an empty store.
+func Open(path string) (*Store, error) {
+	s := &Store{Groups: map[string]*Group{}, path: path}
+
+	data, err := os.ReadFile(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return s, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read state: %w", err)
+	}
+	if err := json.Unmarshal(data, s); err != nil {
+		return nil, fmt.Errorf("failed to parse state %s: %w", path, err)
+	}
+	if s.Groups == nil {
+		s.Groups = map[string]*Group{}
+	}
+	return s, nil
+}
+
+// Save writes the store atomically, so an interrupted run never leaves a truncated file.
+func (s *Store) Save() error {
+	data, err := json.MarshalIndent(s, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to encode state: %w", err)
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(s.path), filepath.Base(s.path)+".*.tmp")
+	if err != nil {
+		return fmt.Errorf("failed to write state: %w", err)
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(append(data, '\n')); err != nil {
+		tmp.Close()
+		return fmt.Errorf("failed to write state: %w", err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("failed to write state: %w", err)
+	}
+	if err := os.Rename(tmp.Name(), s.path); err != nil {
+		return fmt.Errorf("failed to write state: %w", err)
+	}
+	return nil
+}
+
+// Path is the file the store is saved to.
+func (s *Store) Path() string {
+	return s.path
+}
+
+// AddRun appends a run to the history, dropping the oldest beyond MaxRuns.
+func (s *Store) AddRun(r Run) {
+	s.Runs = append(s.Runs, r)
+	if len(s.Runs) > MaxRuns {
+		s.Runs = s.Runs[len(s.Runs)-MaxRuns:]
+	}
+}
+
+// LastRun returns the most recent run, or nil before the first one.
+func (s *Store) LastRun() *Run {
+	if len(s.Runs) == 0 {
+		return nil
+	}
+	return &s.Runs[len(s.Runs)-1]
+}
+
+// RuleGroups returns the keys of the groups recorded for a rule, sorted.
+func (s *Store) RuleGroups(ruleID string) []string {
+	var keys []string
+	for key, g := range s.Groups {
+		if g.RuleID == ruleID {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+	return keys
+}
@@ -0,0 +1,97 @@
+package state
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestOpenMissingFile(t *testing.T) {
+	st, err := Open(filepath.Join(t.TempDir(), "state.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if st.Groups == nil || len(st.Groups) != 0 || st.LastRun() != nil {
+		t.Errorf("Open() of a missing file = %+v, want an empty store", st)
+	}
+}
+
+func TestSaveOpenRoundTrip(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "state.json")
+	st, err := Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	at := time.Date(2025, 3, 1, 12, 0, 0, 0, time.UTC)
+	st.Groups[Key("departments", "Sales")] = &Group{
+		RuleID:        "departments",
+		Value:         "Sales",
+		Mail:          "list-dept-sales@example.com",
+		ADObjectGUID:  "0f3c",
+		GoogleID:      "03abc",
+		ADMembersHash: "aa",
+		LastSyncAt:    at,
+		UpdatedAt:     at,
+	}
+	st.AddRun(Run{Command: "sync", StartedAt: at, FinishedAt: at.Add(time.Minute), Outcome: OutcomeSuccess, Added: 3})
+	if err := st.Save(); err != nil {
+		t.Fatal(err)
+	}
+
+	got, err := Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(got.Groups, st.Groups) || !reflect.DeepEqual(got.Runs, st.Runs) {
+		t.Errorf("Open() = %+v, want %+v", got, st)
+	}
+	if got.Path() != path {
+		t.Errorf("Path() = %q, want %q", got.Path(), path)
+	}
+
+	// Save leaves no temporary files behind.
+	entries, err := os.ReadDir(filepath.Dir(path))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 1 {
+		t.Errorf("directory holds %d files after Save, want only the state file", len(entries))
+	}
+}
+
+func TestOpenBrokenFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "state.json")
+	if err := os.WriteFile(path, []byte(`{"groups": {`), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := Open(path); err == nil || !strings.Contains(err.Error(), "failed to parse state") {
+		t.Errorf("Open() of truncated JSON = %v, want a parse error", err)
+	}
+}
+
+func TestAddRunKeepsMaxRuns(t *testing.T) {
+	st := &Store{Groups: map[string]*Group{}}
+	for i := range MaxRuns + 5 {
+		st.AddRun(Run{Added: i})
+	}
+	if len(st.Runs) != MaxRuns {
+		t.Fatalf("history holds %d runs, want %d", len(st.Runs), MaxRuns)
+	}
+	if st.Runs[0].Added != 5 || st.LastRun().Added != MaxRuns+4 {
+		t.Errorf("history runs from %d to %d, want the newest %d", st.Runs[0].Added, st.LastRun().Added, MaxRuns)
+	}
+}
+
+func TestRuleGroups(t *testing.T) {
+	st := &Store{Groups: map[string]*Group{
+		"departments/Sales": {RuleID: "departments"},
+		"states/TX":         {RuleID: "states"},
+		"departments/HR":    {RuleID: "departments"},
+	}}
+	if got, want := st.RuleGroups("departments"), []string{"departments/HR", "departments/Sales"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("RuleGroups() = %v, want %v", got, want)
+	}
+}
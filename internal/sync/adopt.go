@@ -0,0 +1,92 @@
+package sync
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/matthewdavidson09/dynamic-distro-groups/internal/active_directory"
+	"github.com/matthewdavidson09/dynamic-distro-groups/internal/config"
+	"github.com/matthewdavidson09/dynamic-distro-groups/internal/googleclient"
+	"github.com/matthewdavidson09/dynamic-distro-groups/internal/ldapclient"
+	"github.com/matthewdavidson09/dynamic-distro-groups/internal/plan"
+	"github.com/matthewdavidson09/dynamic-distro-groups/tools"
+	admin "google.golang.org/api/admin/directory/v1"
+)
+
+// AdoptGroups records the ownership marker of each selected rule on the existing AD and Google groups it
+// produces, so later syncs manage them. Membership and settings are left for the next sync. It returns
+// how many groups were (or, on a dry run, would be) adopted. gs may be nil when NeedsGoogle is false.
+func AdoptGroups(client *ldapclient.LDAPClient, gs *googleclient.Clients, cfg *config.Config, users []active_directory.ADUser, opts Options) (int, error) {
+	if gs == nil && NeedsGoogle(cfg, opts) {
+		return 0, errNoGoogle
+	}
+
+	adopted := 0
+	var errs []error
+	for _, rule := range cfg.Rules {
+		groups, err := ExpandRule(rule, users)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("rule %s: %w", rule.ID, err))
+			continue
+		}
+
+		for _, g := range groups {
+			if !opts.Selects(g) {
+				continue
+			}
+			if opts.TargetEnabled(rule, config.TargetAD) {
+				ok, err := active_directory.AdoptGroup(client, adIdentity(g), cfg.Orphans.ArchiveOU, opts.DryRun)
+				if err != nil {
+					errs = append(errs, fmt.Errorf("AD %s: %w", g.Names.Mail, err))
+				} else if ok {
+					adopted++
+				}
+			}
+			if opts.TargetEnabled(rule, config.TargetGoogle) {
+				ok, err := adoptGoogleGroup(gs, g, opts.DryRun)
+				if err != nil {
+					errs = append(errs, fmt.Errorf("Google %s: %w", g.Names.Mail, err))
+				} else if ok {
+					adopted++
+				}
+			}
+		}
+	}
+
+	return adopted, errors.Join(errs...)
+}
+
+// adoptGoogleGroup rewrites the description of an existing Google group to carry the rule's ownership
+// marker, keeping any orphan stamp. It reports whether the group needed adopting.
+func adoptGoogleGroup(gs *googleclient.Clients, g GroupTarget, dryRun bool) (bool, error) {
+	group, err := gs.Directory.Groups.Get(g.Names.Mail).Do()
+	if isNotFound(err) {
+		return false, nil
+	}
+	if err != nil {
+		return false, fmt.Errorf("failed to get group: %w", err)
+	}
+	if checkGoogleOwner(group, g.Rule.ID) == nil {
+		return false, nil
+	}
+
+	description := googleDescription(g.Rule.ID)
+	if since, stamped := plan.ParseOrphanStamp(group.Description); stamped {
+		description += " " + plan.OrphanStamp(since)
+	}
+
+	log := tools.Log.WithFields(map[string]interface{}{
+		"group":    g.Names.Mail,
+		"previous": group.Description,
+		"rule":     g.Rule.ID,
+	})
+	if dryRun {
+		log.Info("[DRY RUN] Would adopt Google group")
+		return true, nil
+	}
+	if _, err := gs.Directory.Groups.Patch(g.Names.Mail, &admin.Group{Description: description}).Do(); err != nil {
+		return true, fmt.Errorf("failed to update description: %w", err)
+	}
+	log.Info("Adopted Google group")
+	return true, nil
+}
@@ -0,0 +1,230 @@
+package sync
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"sync/atomic"
+
+	"github.com/matthewdavidson09/dynamic-distro-groups/internal/active_directory"
+	"github.com/matthewdavidson09/dynamic-distro-groups/internal/googleclient"
+	"github.com/matthewdavidson09/dynamic-distro-groups/internal/ldapclient"
+	"github.com/matthewdavidson09/dynamic-distro-groups/internal/plan"
+	"github.com/matthewdavidson09/dynamic-distro-groups/internal/state"
+	"github.com/matthewdavidson09/dynamic-distro-groups/tools"
+)
+
+// applyWorkers is how many groups a plan applies concurrently.
+const applyWorkers = 5
+
+// ApplyPlan executes a plan, records each group's IDs and outcome in st and counts the failed groups in
+// run; saving st is left to FinishRun. With verify set, every group with changes is first checked against
+// the live state and nothing is written if any of them drifted since the plan was made. gs may be nil when
+// the plan does not touch Google.
+func ApplyPlan(client *ldapclient.LDAPClient, gs *googleclient.Clients, p *plan.Plan, st *state.Store, run *state.Run, verify bool) error {
+	ctx := context.Background()
+
+	if gs == nil && p.TouchesGoogle() {
+		return errNoGoogle
+	}
+
+	// 1. Refuse the whole plan if anything drifted
+	if verify {
+		if err := verifyPlan(ctx, client, gs, p.Changes(), p.Orphans); err != nil {
+			return err
+		}
+	}
+
+	// 2. Apply group by group, counting what was actually done
+	var mu sync.Mutex
+	failed := map[string]error{}
+	var changed, added, removed atomic.Int32
+	tools.RunWithWorkers(p.Groups, applyWorkers, func(g plan.GroupPlan) {
+		metrics, err := applyGroupPlan(ctx, client, gs, g)
+		added.Add(int32(metrics.ADAdded + metrics.GoogleAdded))
+		removed.Add(int32(metrics.ADRemoved + metrics.GoogleRemoved))
+		if err != nil {
+			mu.Lock()
+			failed[g.Key] = err
+			mu.Unlock()
+		} else if !g.AD.Empty() || !g.Google.Empty() {
+			changed.Add(1)
+		}
+	})
+
+	// 3. Retire and delete orphans
+	var orphansDone, orphansFailed atomic.Int32
+	tools.RunWithWorkers(p.Orphans, applyWorkers, func(o plan.OrphanPlan) {
+		n, err := applyOrphan(ctx, client, gs, &o)
+		removed.Add(int32(n))
+		if err != nil {
+			tools.Log.WithFields(map[string]interface{}{
+				"target": o.Target,
+				"group":  o.Mail,
+				"action": o.Action,
+			}).Errorf("Orphan lifecycle error after %d removals: %v", n, err)
+			orphansFailed.Add(1)
+			return
+		}
+		orphansDone.Add(1)
+	})
+
+	// 4. Remember the groups for the next run
+	RecordPlan(st, p, failed)
+	run.Changed, run.Failed = int(changed.Load()), len(failed)
+	run.Added, run.Removed = int(added.Load()), int(removed.Load())
+	run.Orphans = int(orphansDone.Load())
+
+	var errs []error
+	if n := len(failed); n > 0 {
+		errs = append(errs, fmt.Errorf("%d of %d groups failed", n, len(p.Groups)))
+	}
+	if n := orphansFailed.Load(); n > 0 {
+		errs = append(errs, fmt.Errorf("%d of %d orphaned groups failed", n, len(p.Orphans)))
+	}
+	return errors.Join(errs...)
+}
+
+// verifyPlan checks every group against the live state and reports all drift at once.
+func verifyPlan(ctx context.Context, client *ldapclient.LDAPClient, gs *googleclient.Clients, groups []plan.GroupPlan, orphans []plan.OrphanPlan) error {
+	var mu sync.Mutex
+	var problems []error
+	tools.RunWithWorkers(groups, applyWorkers, func(g plan.GroupPlan) {
+		var errs []error
+		if !g.AD.Empty() {
+			if err := active_directory.VerifyGroupPlan(client, g.AD); err != nil {
+				errs = append(errs, err)
+			}
+		}
+		if !g.Google.Empty() {
+			if err := verifyGooglePlan(ctx, gs, g.Mail, g.Google); err != nil {
+				errs = append(errs, err)
+			}
+		}
+		mu.Lock()
+		problems = append(problems, errs...)
+		mu.Unlock()
+	})
+	tools.RunWithWorkers(orphans, applyWorkers, func(o plan.OrphanPlan) {
+		if err := verifyOrphan(ctx, client, gs, &o); err != nil {
+			mu.Lock()
+			problems = append(problems, err)
+			mu.Unlock()
+		}
+	})
+
+	if len(problems) == 0 {
+		return nil
+	}
+	for _, err := range problems {
+		tools.Log.Error(err)
+	}
+	if err := errors.Join(problems...); errors.Is(err, plan.ErrDrift) {
+		return fmt.Errorf("%d changes no longer match the live state, re-run plan: %w", len(problems), plan.ErrDrift)
+	}
+	return fmt.Errorf("failed to verify plan: %w", errors.Join(problems...))
+}
+
+// applyGroupPlan applies one group's changes to each target and returns the member changes it made.
+func applyGroupPlan(ctx context.Context, client *ldapclient.LDAPClient, gs *googleclient.Clients, g plan.GroupPlan) (tools.SyncMetrics, error) {
+	log := tools.Log.WithFields(map[string]interface{}{
+		"rule":  g.RuleID,
+		"group": g.Mail,
+	})
+	metrics := tools.SyncMetrics{GroupEmail: g.Mail, TotalUsers: g.TotalUsers}
+	var errs []error
+
+	// 1. Active Directory
+	if !g.AD.Empty() {
+		var err error
+		metrics.ADAdded, metrics.ADRemoved, err = active_directory.ApplyGroupPlan(client, g.AD)
+		if err != nil {
+			log.Errorf("AD sync error: %v", err)
+			errs = append(errs, fmt.Errorf("AD: %w", err))
+		}
+	}
+
+	// 2. Google Workspace
+	if !g.Google.Empty() {
+		var err error
+		metrics.GoogleAdded, metrics.GoogleRemoved, err = applyGooglePlan(ctx, gs, g.Mail, g.Google)
+		if err != nil {
+			log.Errorf("Google group sync error: %v", err)
+			errs = append(errs, fmt.Errorf("Google: %w", err))
+		}
+	}
+
+	// 3. Combined sync summary log
+	tools.LogSyncCombined(metrics)
+	return metrics, errors.Join(errs...)
+}
+
+// LogPlan logs every change in the plan as a dry run, with the same summary line a sync prints, followed
+// by the orphan lifecycle actions.
+func LogPlan(p *plan.Plan) {
+	for _, g := range p.Groups {
+		metrics := tools.SyncMetrics{GroupEmail: g.Mail, TotalUsers: g.TotalUsers}
+
+		if a := g.AD; a != nil {
+			if a.Create != nil {
+				tools.Log.Infof("[DRY RUN] Would create AD group %s", a.DN)
+			}
+			if a.Rename != "" {
+				tools.Log.Infof("[DRY RUN] Would rename AD group %s to %s", a.DN, a.Rename)
+			}
+			for attr, value := range a.SetAttributes {
+				tools.Log.Infof("[DRY RUN] Would set %s=%q on %s", attr, value, a.DN)
+			}
+			for _, dn := range a.AddMembers {
+				tools.Log.Debugf("[DRY RUN] Would add %s → %s", dn, a.DN)
+			}
+			for _, dn := range a.RemoveMembers {
+				tools.Log.Debugf("[DRY RUN] Would remove %s ← %s", dn, a.DN)
+			}
+			metrics.ADAdded, metrics.ADRemoved = len(a.AddMembers), len(a.RemoveMembers)
+		}
+
+		if gp := g.Google; gp != nil {
+			if gp.Create != nil {
+				tools.Log.Infof("[DRY RUN] Would create Google group %s", g.Mail)
+			}
+			if gp.RenameFrom != "" {
+				tools.Log.Infof("[DRY RUN] Would rename Google group %s to %s, keeping the old address as an alias", gp.RenameFrom, g.Mail)
+			}
+			for _, m := range gp.AddMembers {
+				tools.Log.Infof("[DRY RUN] Would add %s to %s as %s", m.Email, g.Mail, m.Role)
+			}
+			for _, m := range gp.UpdateRoles {
+				tools.Log.Infof("[DRY RUN] Would update role for %s to %s", m.Email, m.Role)
+			}
+			for _, email := range gp.RemoveMembers {
+				tools.Log.Infof("[DRY RUN] Would remove %s from %s", email, g.Mail)
+			}
+			if len(gp.Settings) > 0 {
+				tools.Log.Infof("[DRY RUN] Would apply %d Google group settings to %s", len(gp.Settings), g.Mail)
+			}
+			metrics.GoogleAdded, metrics.GoogleRemoved = len(gp.AddMembers), len(gp.RemoveMembers)
+		}
+
+		tools.LogSyncCombined(metrics)
+	}
+
+	for _, o := range p.Orphans {
+		name := o.Mail
+		if o.DN != "" {
+			name = o.DN
+		}
+		if o.Action == plan.OrphanDelete {
+			tools.Log.Infof("[DRY RUN] Would delete orphaned %s group %s (orphaned since %s, %d members)", o.Target, name, o.OrphanedSince, o.MemberCount)
+			continue
+		}
+		tools.Log.Infof("[DRY RUN] Would retire orphaned %s group %s: remove %d members", o.Target, name, len(o.RemoveMembers))
+		if o.Stamp {
+			tools.Log.Infof("[DRY RUN] Would stamp %s as orphaned since %s", name, o.OrphanedSince)
+		}
+		if o.MoveTo != "" {
+			tools.Log.Infof("[DRY RUN] Would move %s to %s", name, o.MoveTo)
+		}
+	}
+}
@@ -0,0 +1,110 @@
+package sync
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/matthewdavidson09/dynamic-distro-groups/internal/plan"
+	"github.com/matthewdavidson09/dynamic-distro-groups/internal/state"
+)
+
+func TestApplyPlanRefusesDrift(t *testing.T) {
+	fake, gs := newFakeGoogle(t)
+	fake.addGroup("1", "list-dept-sales@example.com", "ann@example.com=MEMBER", "bob@example.com=MEMBER")
+	fake.addGroup("2", "list-dept-hr@example.com", "cid@example.com=MEMBER")
+
+	// The plan was made before cid joined Sales; HR is unchanged.
+	p := &plan.Plan{Version: plan.Version, Groups: []plan.GroupPlan{
+		{
+			Key:  "departments/Sales",
+			Mail: "list-dept-sales@example.com",
+			Google: &plan.GooglePlan{
+				ID:            "1",
+				RemoveMembers: []string{"bob@example.com"},
+				MembersHash:   hashGoogleMembers(map[string]string{"ann@example.com": "MEMBER", "bob@example.com": "MEMBER"}),
+			},
+		},
+		{
+			Key:  "departments/HR",
+			Mail: "list-dept-hr@example.com",
+			Google: &plan.GooglePlan{
+				ID:            "2",
+				RemoveMembers: []string{"cid@example.com"},
+				MembersHash:   hashGoogleMembers(map[string]string{"cid@example.com": "MEMBER"}),
+			},
+		},
+	}}
+	fake.members["list-dept-sales@example.com"]["cid@example.com"] = "MEMBER"
+
+	st := &state.Store{Groups: map[string]*state.Group{}}
+	err := ApplyPlan(nil, gs, p, st, &state.Run{}, true)
+	if !errors.Is(err, plan.ErrDrift) {
+		t.Fatalf("ApplyPlan() = %v, want ErrDrift", err)
+	}
+	if len(fake.writes) != 0 {
+		t.Errorf("ApplyPlan() wrote %v despite drift", fake.writes)
+	}
+	if len(st.Groups) != 0 {
+		t.Errorf("ApplyPlan() recorded %d groups despite drift", len(st.Groups))
+	}
+}
+
+func TestApplyPlanRefusesVanishedAndNewGroups(t *testing.T) {
+	fake, gs := newFakeGoogle(t)
+	fake.addGroup("2", "list-state-tx@example.com")
+
+	p := &plan.Plan{Version: plan.Version, Groups: []plan.GroupPlan{
+		{Key: "states/CA", Mail: "list-state-ca@example.com", Google: &plan.GooglePlan{ID: "1", RemoveMembers: []string{"ann@example.com"}}},
+		{Key: "states/TX", Mail: "list-state-tx@example.com", Google: &plan.GooglePlan{Create: &plan.GoogleGroup{Name: "TX"}}},
+	}}
+
+	err := ApplyPlan(nil, gs, p, &state.Store{Groups: map[string]*state.Group{}}, &state.Run{}, true)
+	if !errors.Is(err, plan.ErrDrift) {
+		t.Fatalf("ApplyPlan() = %v, want ErrDrift", err)
+	}
+	if len(fake.writes) != 0 {
+		t.Errorf("ApplyPlan() wrote %v despite drift", fake.writes)
+	}
+}
+
+func TestApplyPlanNeedsGoogle(t *testing.T) {
+	p := &plan.Plan{Groups: []plan.GroupPlan{{Google: &plan.GooglePlan{RemoveMembers: []string{"ann@example.com"}}}}}
+	if err := ApplyPlan(nil, nil, p, &state.Store{}, &state.Run{}, true); !errors.Is(err, errNoGoogle) {
+		t.Errorf("ApplyPlan() without Google clients = %v, want errNoGoogle", err)
+	}
+}
+
+func TestApplyPlanCountsWhatWasApplied(t *testing.T) {
+	fake, gs := newFakeGoogle(t)
+	fake.addGroup("1", "list-dept-sales@example.com", "ann@example.com=MEMBER", "bob@example.com=MEMBER")
+
+	// HR no longer exists, so its addition fails while Sales applies.
+	p := &plan.Plan{Version: plan.Version, SourceUsers: 3, Groups: []plan.GroupPlan{
+		{
+			Key:  "departments/Sales",
+			Mail: "list-dept-sales@example.com",
+			Google: &plan.GooglePlan{
+				ID:            "1",
+				AddMembers:    []plan.Member{{Email: "cid@example.com", Role: "MEMBER"}},
+				RemoveMembers: []string{"bob@example.com"},
+			},
+		},
+		{
+			Key:    "departments/HR",
+			Mail:   "list-dept-hr@example.com",
+			Google: &plan.GooglePlan{ID: "2", AddMembers: []plan.Member{{Email: "dee@example.com", Role: "MEMBER"}}},
+		},
+		{Key: "departments/Legal", Mail: "list-dept-legal@example.com", Google: &plan.GooglePlan{ID: "3"}},
+	}}
+
+	st := &state.Store{Groups: map[string]*state.Group{}}
+	run := &state.Run{}
+	if err := ApplyPlan(nil, gs, p, st, run, false); err == nil {
+		t.Fatal("ApplyPlan() succeeded although HR is gone")
+	}
+
+	want := state.Run{Changed: 1, Failed: 1, Added: 1, Removed: 1}
+	if *run != want {
+		t.Errorf("run = %+v, want %+v", *run, want)
+	}
+}
@@ -0,0 +1,257 @@
+package sync
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/matthewdavidson09/dynamic-distro-groups/internal/active_directory"
+	"github.com/matthewdavidson09/dynamic-distro-groups/internal/config"
+	"github.com/matthewdavidson09/dynamic-distro-groups/internal/googleclient"
+	"github.com/matthewdavidson09/dynamic-distro-groups/internal/ldapclient"
+	"github.com/matthewdavidson09/dynamic-distro-groups/internal/plan"
+	"github.com/matthewdavidson09/dynamic-distro-groups/internal/state"
+	"github.com/matthewdavidson09/dynamic-distro-groups/tools"
+	"google.golang.org/api/groupssettings/v1"
+)
+
+// Options control which groups a run touches and whether it writes.
+type Options struct {
+	DryRun  bool
+	Force   bool     // apply even when the plan exceeds the safety limits
+	Only    []string // rule IDs, group mails or CNs to sync; empty means everything
+	Targets []string // restrict to these targets (lowercase ad, google); empty means each rule's own
+}
+
+// TargetEnabled reports whether a rule's groups should be synced to target in this run.
+func (o Options) TargetEnabled(rule config.Rule, target string) bool {
+	return rule.HasTarget(target) && o.targetSelected(target)
+}
+
+// targetSelected reports whether --targets includes target.
+func (o Options) targetSelected(target string) bool {
+	return len(o.Targets) == 0 || slices.Contains(o.Targets, target)
+}
+
+// Selects reports whether --only includes the group, either by its rule ID or its own mail or CN.
+func (o Options) Selects(g GroupTarget) bool {
+	if len(o.Only) == 0 {
+		return true
+	}
+	for _, only := range o.Only {
+		only = strings.TrimSpace(only)
+		if strings.EqualFold(only, g.Rule.ID) || strings.EqualFold(only, g.Names.Mail) || strings.EqualFold(only, g.Names.CN) {
+			return true
+		}
+	}
+	return false
+}
+
+// NeedsGoogle reports whether a run with opts reads or writes Google groups.
+func NeedsGoogle(cfg *config.Config, opts Options) bool {
+	if cfg.Orphans.Enabled && opts.targetSelected(config.TargetGoogle) {
+		return true
+	}
+	for _, rule := range cfg.Rules {
+		if opts.TargetEnabled(rule, config.TargetGoogle) {
+			return true
+		}
+	}
+	return false
+}
+
+// BuildPlan expands every rule and plans each selected group against the live AD and Google state,
+// using the IDs in st to find groups that were renamed. It only reads. gs may be nil when
+// NeedsGoogle is false. When some groups fail to plan, the returned plan holds the rest alongside the
+// error.
+func BuildPlan(client *ldapclient.LDAPClient, gs *googleclient.Clients, cfg *config.Config, st *state.Store, users []active_directory.ADUser, opts Options) (*plan.Plan, error) {
+	p := &plan.Plan{Version: plan.Version, CreatedAt: time.Now().UTC(), SourceUsers: len(users)}
+
+	if gs == nil && NeedsGoogle(cfg, opts) {
+		return nil, errNoGoogle
+	}
+
+	// Google plans vet new members against one listing of the customer's users.
+	var accounts *googleUsers
+	for _, rule := range cfg.Rules {
+		if opts.TargetEnabled(rule, config.TargetGoogle) {
+			var err error
+			if accounts, err = loadGoogleUsers(context.Background(), gs); err != nil {
+				return nil, err
+			}
+			break
+		}
+	}
+
+	// 1. Every rule's groups, none of which may share a name with another's
+	failed := 0
+	expanded := make([][]GroupTarget, len(cfg.Rules))
+	expandErrs := make([]error, len(cfg.Rules))
+	claims := nameClaims{}
+	for i, rule := range cfg.Rules {
+		groups, err := ExpandRule(rule, users)
+		if err != nil {
+			tools.Log.Errorf("Rule %s failed: %v", rule.ID, err)
+			expandErrs[i] = err
+			failed++
+			continue
+		}
+		for _, g := range groups {
+			if err := claims.claim(g); err != nil {
+				return nil, err
+			}
+		}
+		expanded[i] = groups
+	}
+
+	// 2. Each rule's plan
+	for i, rule := range cfg.Rules {
+		if expandErrs[i] != nil {
+			continue
+		}
+		tools.Log.Debugf("Planning %s groups...", rule.ID)
+		groups, err := planRule(client, gs, accounts, cfg, st, rule, expanded[i], opts)
+		p.Groups = append(p.Groups, groups...)
+		if err != nil {
+			tools.Log.Errorf("Rule %s failed: %v", rule.ID, err)
+			failed++
+		}
+	}
+
+	if failed > 0 {
+		p.Sort()
+		return p, fmt.Errorf("%d of %d rules had failures", failed, len(cfg.Rules))
+	}
+
+	// Orphans can only be told apart when every rule ran and planned cleanly.
+	if cfg.Orphans.Enabled {
+		if cfg.Filtered() || len(opts.Only) > 0 {
+			tools.Log.Info("Skipping orphaned group detection because only some rules were selected")
+		} else if err := planOrphans(client, gs, cfg.Orphans, p, opts); err != nil {
+			p.Sort()
+			return p, fmt.Errorf("orphaned group detection failed: %w", err)
+		}
+	}
+
+	p.Sort()
+	return p, nil
+}
+
+// planRule plans every selected group a rule expanded to.
+func planRule(client *ldapclient.LDAPClient, gs *googleclient.Clients, accounts *googleUsers, cfg *config.Config, st *state.Store, rule config.Rule, expanded []GroupTarget, opts Options) ([]plan.GroupPlan, error) {
+	settings, err := GroupSettingsForProfile(cfg, rule.Google.Settings)
+	if err != nil {
+		return nil, fmt.Errorf("rule %s: %w", rule.ID, err)
+	}
+
+	resolveKnownGroups(client, gs, st, rule, expanded, cfg.Orphans.ArchiveOU)
+
+	var groups []GroupTarget
+	for _, g := range expanded {
+		if opts.Selects(g) {
+			groups = append(groups, g)
+		}
+	}
+	if len(groups) == 0 {
+		return nil, nil
+	}
+
+	start := time.Now()
+	tools.Log.Infof("Planning %d groups for rule %s...", len(groups), rule.ID)
+
+	var mu sync.Mutex
+	var planned []plan.GroupPlan
+	var failed atomic.Int32
+	tools.RunWithWorkers(groups, rule.Workers, func(g GroupTarget) {
+		gp, err := planGroupTarget(client, gs, accounts, g, settings, cfg.Orphans.ArchiveOU, opts)
+		if err != nil {
+			tools.Log.WithFields(map[string]interface{}{
+				"rule":  g.Rule.ID,
+				"value": g.Value,
+			}).Errorf("Plan error: %v", err)
+			failed.Add(1)
+			return
+		}
+		mu.Lock()
+		planned = append(planned, gp)
+		mu.Unlock()
+	})
+
+	tools.Log.Debugf("Planned rule %s in %s", rule.ID, time.Since(start))
+	if n := failed.Load(); n > 0 {
+		return planned, fmt.Errorf("rule %s: %d of %d groups failed", rule.ID, n, len(groups))
+	}
+	return planned, nil
+}
+
+// planGroupTarget plans one expanded group for each of its enabled targets.
+func planGroupTarget(client *ldapclient.LDAPClient, gs *googleclient.Clients, accounts *googleUsers, g GroupTarget, settings *groupssettings.Groups, archiveOU string, opts Options) (plan.GroupPlan, error) {
+	// The rendered mail is the single source of the group's address in both AD and Google.
+	gp := plan.GroupPlan{RuleID: g.Rule.ID, Value: g.Value, Key: g.Key(), RenamedKey: g.RenamedKey, Mail: g.Names.Mail}
+	for _, user := range g.Members {
+		if normalizeEmail(user.Email) != "" {
+			gp.TotalUsers++
+		}
+	}
+
+	// 1. Active Directory
+	if opts.TargetEnabled(g.Rule, config.TargetAD) {
+		adPlan, err := active_directory.PlanGroup(client, adIdentity(g), g.Members, archiveOU)
+		if err != nil {
+			return gp, fmt.Errorf("AD: %w", err)
+		}
+		gp.AD = adPlan
+		if g.Known != nil {
+			warnOutsideChange(g, config.TargetAD, g.Known.ADMembersHash, adPlan.MembersHash)
+		}
+	}
+
+	// 2. Google Workspace
+	if opts.TargetEnabled(g.Rule, config.TargetGoogle) {
+		googlePlan, err := planGoogleGroup(context.Background(), gs, accounts, g, settings)
+		if err != nil {
+			return gp, fmt.Errorf("Google: %w", err)
+		}
+		gp.Google = googlePlan
+		if g.Known != nil {
+			warnOutsideChange(g, config.TargetGoogle, g.Known.GoogleMembersHash, googlePlan.MembersHash)
+		}
+	}
+
+	return gp, nil
+}
+
+// warnOutsideChange logs when a group's live membership no longer matches what the last sync left it
+// with, i.e. someone edited it by hand. The next apply reverts such edits.
+func warnOutsideChange(g GroupTarget, target, recorded, live string) {
+	if recorded == "" || live == recorded {
+		return
+	}
+	tools.Log.WithFields(map[string]interface{}{
+		"rule":      g.Rule.ID,
+		"group":     g.Names.Mail,
+		"target":    target,
+		"last_sync": g.Known.LastSyncAt.Format(time.RFC3339),
+	}).Warn("Membership changed outside of sync since the last run")
+}
+
+// adIdentity maps a group's rendered names onto the AD group attributes.
+func adIdentity(g GroupTarget) active_directory.GroupIdentity {
+	names := g.Names
+	id := active_directory.GroupIdentity{
+		Owner:          g.Rule.ID,
+		CN:             names.CN,
+		SAMAccountName: names.SAMAccountName,
+		Email:          names.Mail,
+		DisplayName:    names.DisplayName,
+		Description:    names.Description,
+	}
+	if g.Known != nil {
+		id.ObjectGUID = g.Known.ADObjectGUID
+	}
+	return id
+}
@@ -0,0 +1,286 @@
+package sync
+
+import (
+	"fmt"
+	"os"
+	"slices"
+	"sort"
+	"strings"
+
+	"github.com/matthewdavidson09/dynamic-distro-groups/internal/active_directory"
+	"github.com/matthewdavidson09/dynamic-distro-groups/internal/config"
+	"github.com/matthewdavidson09/dynamic-distro-groups/internal/expr"
+	"github.com/matthewdavidson09/dynamic-distro-groups/internal/state"
+	"github.com/matthewdavidson09/dynamic-distro-groups/tools"
+)
+
+// GroupTarget is one concrete group produced by expanding a rule.
+type GroupTarget struct {
+	Rule    config.Rule
+	Value   string            // grouping value, slugified into the group name
+	Label   string            // human-readable value for display names
+	Values  map[string]string // grouping attribute -> value, for naming templates
+	Names   config.GroupNames
+	Members []active_directory.ADUser
+
+	// Set from the state store before planning.
+	Known      *state.Group // IDs recorded for the group by earlier runs
+	RenamedKey string       // state key of the group this one was renamed from
+}
+
+// Key is the group's key in the state store.
+func (g GroupTarget) Key() string {
+	return state.Key(g.Rule.ID, g.Value)
+}
+
+// ExpandRule turns a rule into the concrete, named groups it produces for the given users.
+func ExpandRule(rule config.Rule, users []active_directory.ADUser) ([]GroupTarget, error) {
+	groups, err := expandRule(rule, users)
+	if err != nil {
+		return nil, err
+	}
+
+	domain := os.Getenv("GROUP_EMAIL_DOMAIN")
+	for i := range groups {
+		g := &groups[i]
+		g.Names, err = rule.Naming.Render(config.NameData{
+			RuleID:   rule.ID,
+			Category: rule.Naming.Category,
+			Value:    g.Value,
+			Slug:     tools.Slugify(g.Value),
+			Label:    g.Label,
+			Values:   g.Values,
+			Domain:   domain,
+		})
+		if err != nil {
+			return nil, fmt.Errorf("naming %q: %w", g.Value, err)
+		}
+	}
+
+	claims := nameClaims{}
+	for _, g := range groups {
+		if err := claims.claim(g); err != nil {
+			return nil, fmt.Errorf("%w; normalize values that differ only in case or punctuation, e.g. with normalize: [trim, lower]", err)
+		}
+	}
+	return groups, nil
+}
+
+// nameClaims maps each rendered CN and mail to the group that rendered it, so that two groups never sync
+// into one directory group and undo each other's members.
+type nameClaims map[string]string
+
+// claim records g's names, failing when another group rendered one of them. Names compare case-insensitively,
+// as AD and Google do.
+func (c nameClaims) claim(g GroupTarget) error {
+	for _, name := range []struct{ field, value string }{{"CN", g.Names.CN}, {"mail", g.Names.Mail}} {
+		key := name.field + "\x00" + strings.ToLower(name.value)
+		if other, taken := c[key]; taken {
+			return fmt.Errorf("groups %s and %s both render %s %q", other, g.Key(), name.field, name.value)
+		}
+		c[key] = g.Key()
+	}
+	return nil
+}
+
+func expandRule(rule config.Rule, users []active_directory.ADUser) ([]GroupTarget, error) {
+	index := active_directory.NewUserIndex(users)
+
+	users = filterUsers(users, rule.Filter)
+	if rule.Where != nil {
+		users = whereUsers(users, rule.Where)
+	}
+	if prog := rule.Program(); prog != nil {
+		matched, err := matchUsers(users, prog, index)
+		if err != nil {
+			return nil, err
+		}
+		users = matched
+	}
+
+	switch {
+	case len(rule.CrossProduct) > 0:
+		return expandCrossProduct(rule, users)
+
+	case rule.GroupBy == "":
+		if len(users) == 0 {
+			return nil, nil
+		}
+		return []GroupTarget{{Rule: rule, Value: rule.Value, Label: rule.Value, Members: users}}, nil
+
+	case strings.EqualFold(rule.GroupBy, "manager"):
+		return expandManagers(rule, users), nil
+	}
+
+	normalize, err := active_directory.NewNormalizer(rule.Normalize)
+	if err != nil {
+		return nil, err
+	}
+	buckets := active_directory.GroupUsersByAttribute(users, rule.GroupBy, normalize)
+
+	var groups []GroupTarget
+	for value, members := range buckets {
+		groups = append(groups, GroupTarget{
+			Rule:    rule,
+			Value:   value,
+			Label:   value,
+			Values:  map[string]string{rule.GroupBy: value},
+			Members: members,
+		})
+	}
+	sortTargets(groups)
+	return groups, nil
+}
+
+// expandCrossProduct produces one group per combination of cross_product values that has users,
+// e.g. department x state yields "Engineering-TX". Users missing any of the attributes are skipped.
+func expandCrossProduct(rule config.Rule, users []active_directory.ADUser) ([]GroupTarget, error) {
+	normalizers := make([]func(string) string, len(rule.CrossProduct))
+	for i, attr := range rule.CrossProduct {
+		steps := rule.Normalize
+		if steps == nil {
+			steps = config.DefaultNormalize(attr)
+		}
+		normalize, err := active_directory.NewNormalizer(steps)
+		if err != nil {
+			return nil, err
+		}
+		normalizers[i] = normalize
+	}
+
+	// Buckets are keyed on the values joined by NUL, which no attribute value contains; the "-" join is only
+	// the group's value, and two combinations may share it when values contain "-".
+	buckets := make(map[string]*GroupTarget)
+	byValue := make(map[string]string)
+	for _, u := range users {
+		parts := make([]string, len(rule.CrossProduct))
+		values := make(map[string]string, len(rule.CrossProduct))
+		for i, attr := range rule.CrossProduct {
+			parts[i] = normalizers[i](u.Attribute(attr))
+			values[attr] = parts[i]
+		}
+		if slices.Contains(parts, "") {
+			continue
+		}
+
+		key := strings.Join(parts, "\x00")
+		g, ok := buckets[key]
+		if !ok {
+			value := strings.Join(parts, "-")
+			if other, taken := byValue[value]; taken {
+				return nil, fmt.Errorf("cross_product values %q and %q both make group %q",
+					strings.ReplaceAll(other, "\x00", " / "), strings.Join(parts, " / "), value)
+			}
+			byValue[value] = key
+			g = &GroupTarget{Rule: rule, Value: value, Label: strings.Join(parts, " / "), Values: values}
+			buckets[key] = g
+		}
+		g.Members = append(g.Members, u)
+	}
+
+	groups := make([]GroupTarget, 0, len(buckets))
+	for _, g := range buckets {
+		groups = append(groups, *g)
+	}
+	sortTargets(groups)
+	return groups, nil
+}
+
+// expandManagers produces one group per manager containing the manager and their direct reports.
+func expandManagers(rule config.Rule, users []active_directory.ADUser) []GroupTarget {
+	byDN := make(map[string]active_directory.ADUser, len(users))
+	for _, u := range users {
+		byDN[active_directory.NormalizeDN(u.DN)] = u
+	}
+
+	var groups []GroupTarget
+	for managerDN, reports := range active_directory.GroupUsersByManager(users) {
+		manager, ok := byDN[managerDN]
+		if !ok || manager.Email == "" {
+			continue
+		}
+		label := manager.DisplayName
+		if label == "" {
+			label = manager.SAMAccountName
+		}
+		groups = append(groups, GroupTarget{
+			Rule:    rule,
+			Value:   manager.SAMAccountName,
+			Label:   label,
+			Values:  map[string]string{"manager": manager.SAMAccountName},
+			Members: append(reports, manager),
+		})
+	}
+	sortTargets(groups)
+	return groups
+}
+
+// filterUsers keeps users whose attributes match every filter entry (case-insensitive).
+func filterUsers(users []active_directory.ADUser, filter map[string]string) []active_directory.ADUser {
+	if len(filter) == 0 {
+		return users
+	}
+
+	var matched []active_directory.ADUser
+	for _, u := range users {
+		ok := true
+		for attr, want := range filter {
+			if !strings.EqualFold(strings.TrimSpace(u.Attribute(attr)), strings.TrimSpace(want)) {
+				ok = false
+				break
+			}
+		}
+		if ok {
+			matched = append(matched, u)
+		}
+	}
+	return matched
+}
+
+// whereUsers keeps users matching the rule's where predicate.
+func whereUsers(users []active_directory.ADUser, where *config.Predicate) []active_directory.ADUser {
+	var matched []active_directory.ADUser
+	for _, u := range users {
+		if where.Matches(u) {
+			matched = append(matched, u)
+		}
+	}
+	return matched
+}
+
+// matchUsers keeps users for which the rule's match expression is true.
+func matchUsers(users []active_directory.ADUser, prog *expr.Program, index active_directory.UserIndex) ([]active_directory.ADUser, error) {
+	var matched []active_directory.ADUser
+	for _, u := range users {
+		ok, err := prog.Eval(userEnv{user: u, index: index})
+		if err != nil {
+			return nil, fmt.Errorf("match failed for %s: %w", u.SAMAccountName, err)
+		}
+		if ok {
+			matched = append(matched, u)
+		}
+	}
+	return matched, nil
+}
+
+// userEnv exposes an ADUser and their manager chain to expressions.
+type userEnv struct {
+	user  active_directory.ADUser
+	index active_directory.UserIndex
+}
+
+func (e userEnv) AttributeValues(name string) []string {
+	return e.user.AttributeValues(name)
+}
+
+func (e userEnv) Manager() (expr.Env, bool) {
+	mgr, ok := e.index.Manager(e.user)
+	if !ok {
+		return nil, false
+	}
+	return userEnv{user: mgr, index: e.index}, true
+}
+
+func sortTargets(groups []GroupTarget) {
+	sort.Slice(groups, func(i, j int) bool { return groups[i].Value < groups[j].Value })
+}
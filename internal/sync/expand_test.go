@@ -0,0 +1,301 @@
+package sync
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/matthewdavidson09/dynamic-distro-groups/internal/active_directory"
+	"github.com/matthewdavidson09/dynamic-distro-groups/internal/config"
+)
+
+// loadRule loads a rules file holding a single rule, so it gets the same defaults and validation as in a run.
+func loadRule(t *testing.T, rules string) config.Rule {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "groups.yaml")
+	if err := os.WriteFile(path, []byte(rules), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	cfg, err := config.Load(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return cfg.Rules[0]
+}
+
+// expand runs ExpandRule and returns the groups by value.
+func expand(t *testing.T, rule config.Rule, users []active_directory.ADUser) map[string]GroupTarget {
+	t.Helper()
+	t.Setenv("GROUP_EMAIL_DOMAIN", "example.com")
+	groups, err := ExpandRule(rule, users)
+	if err != nil {
+		t.Fatalf("ExpandRule() = %v", err)
+	}
+	byValue := make(map[string]GroupTarget, len(groups))
+	for _, g := range groups {
+		byValue[g.Value] = g
+	}
+	return byValue
+}
+
+func memberNames(g GroupTarget) []string {
+	names := make([]string, len(g.Members))
+	for i, u := range g.Members {
+		names[i] = u.SAMAccountName
+	}
+	return names
+}
+
+func TestExpandRuleGroupBy(t *testing.T) {
+	rule := loadRule(t, `
+rules:
+  - id: departments
+    group_by: department
+    naming: { category: dept }
+`)
+	users := []active_directory.ADUser{
+		{SAMAccountName: "ann", Department: " customer success"},
+		{SAMAccountName: "bob", Department: "Customer Success"},
+		{SAMAccountName: "cid", Department: "R&D"},
+		{SAMAccountName: "dee"},
+	}
+
+	groups := expand(t, rule, users)
+	if len(groups) != 2 {
+		t.Fatalf("ExpandRule() = %v, want two groups", groups)
+	}
+
+	cs := groups["Customer Success"]
+	if got := memberNames(cs); len(got) != 2 {
+		t.Errorf("Customer Success members = %v, want ann and bob", got)
+	}
+	if cs.Names.CN != "list-dept-customer-success" || cs.Names.Mail != "list-dept-customer-success@example.com" {
+		t.Errorf("Customer Success names = %+v", cs.Names)
+	}
+	if cs.Values["department"] != "Customer Success" {
+		t.Errorf("Customer Success values = %v", cs.Values)
+	}
+	if rd := groups["R&D"]; rd.Names.CN != "list-dept-rd" {
+		t.Errorf("R&D CN = %q, want list-dept-rd", rd.Names.CN)
+	}
+}
+
+func TestExpandRuleGroupByExtendedAttribute(t *testing.T) {
+	rule := loadRule(t, `
+rules:
+  - id: offices
+    group_by: physicalDeliveryOfficeName
+    normalize: [trim, lower]
+`)
+	users := []active_directory.ADUser{
+		{SAMAccountName: "ann", Attributes: map[string][]string{"physicaldeliveryofficename": {"Austin "}}},
+		{SAMAccountName: "bob", Attributes: map[string][]string{"physicaldeliveryofficename": {"AUSTIN"}}},
+	}
+
+	groups := expand(t, rule, users)
+	if got := memberNames(groups["austin"]); len(groups) != 1 || len(got) != 2 {
+		t.Errorf("ExpandRule() = %v, want one austin group with both users", groups)
+	}
+}
+
+func TestExpandRuleManagers(t *testing.T) {
+	rule := loadRule(t, `
+rules:
+  - id: managers
+    group_by: manager
+    naming: { category: reports }
+`)
+	users := []active_directory.ADUser{
+		{SAMAccountName: "boss", DN: "CN=Boss,DC=example,DC=com", Email: "boss@example.com", DisplayName: "The Boss"},
+		{SAMAccountName: "ann", ManagerDN: "cn=boss,dc=example,dc=com"},
+		{SAMAccountName: "bob", ManagerDN: "CN=Boss,DC=example,DC=com"},
+		{SAMAccountName: "cid", ManagerDN: "CN=Gone,DC=example,DC=com"},
+	}
+
+	groups := expand(t, rule, users)
+	boss, ok := groups["boss"]
+	if len(groups) != 1 || !ok {
+		t.Fatalf("ExpandRule() = %v, want one group for boss", groups)
+	}
+	if got := memberNames(boss); len(got) != 3 {
+		t.Errorf("boss members = %v, want ann, bob and boss", got)
+	}
+	if boss.Label != "The Boss" {
+		t.Errorf("boss label = %q", boss.Label)
+	}
+}
+
+func TestExpandRuleCrossProduct(t *testing.T) {
+	rule := loadRule(t, `
+rules:
+  - id: dept-state
+    cross_product: [department, state]
+    naming: { category: ds }
+`)
+	users := []active_directory.ADUser{
+		{SAMAccountName: "ann", Department: "engineering", State: "tx"},
+		{SAMAccountName: "bob", Department: "Engineering", State: "TX"},
+		{SAMAccountName: "cid", Department: "Engineering", State: "CA"},
+		{SAMAccountName: "dee", Department: "Sales"},
+	}
+
+	groups := expand(t, rule, users)
+	if len(groups) != 2 {
+		t.Fatalf("ExpandRule() = %v, want Engineering-TX and Engineering-CA", groups)
+	}
+	tx := groups["Engineering-TX"]
+	if got := memberNames(tx); len(got) != 2 {
+		t.Errorf("Engineering-TX members = %v, want ann and bob", got)
+	}
+	if tx.Label != "Engineering / TX" || tx.Names.CN != "list-ds-engineering-tx" {
+		t.Errorf("Engineering-TX label %q, CN %q", tx.Label, tx.Names.CN)
+	}
+	if tx.Values["department"] != "Engineering" || tx.Values["state"] != "TX" {
+		t.Errorf("Engineering-TX values = %v", tx.Values)
+	}
+}
+
+func TestExpandRuleCrossProductValuesWithDash(t *testing.T) {
+	rule := loadRule(t, `
+rules:
+  - id: dept-title
+    cross_product: [department, title]
+    normalize: [trim]
+`)
+
+	// Distinct combinations that join to different values stay apart even when values contain "-".
+	groups := expand(t, rule, []active_directory.ADUser{
+		{SAMAccountName: "ann", Department: "R-D", Title: "Lead"},
+		{SAMAccountName: "bob", Department: "R-D", Title: "Staff"},
+	})
+	if len(groups["R-D-Lead"].Members) != 1 || len(groups["R-D-Staff"].Members) != 1 {
+		t.Errorf("ExpandRule() = %v, want one member each in R-D-Lead and R-D-Staff", groups)
+	}
+
+	// Distinct combinations that join to the same value would make one group out of two, so they fail.
+	t.Setenv("GROUP_EMAIL_DOMAIN", "example.com")
+	_, err := ExpandRule(rule, []active_directory.ADUser{
+		{SAMAccountName: "ann", Department: "A-B", Title: "C"},
+		{SAMAccountName: "bob", Department: "A", Title: "B-C"},
+	})
+	if err == nil {
+		t.Fatal("ExpandRule() merged A-B / C and A / B-C into one group")
+	}
+}
+
+func TestExpandRuleWhereAndFilter(t *testing.T) {
+	rule := loadRule(t, `
+rules:
+  - id: texas-sales
+    value: texas-sales
+    filter: { department: sales }
+    where:
+      all:
+        - { attr: state, in: [TX] }
+        - not: { attr: title, contains: intern }
+`)
+	users := []active_directory.ADUser{
+		{SAMAccountName: "ann", Department: "Sales", State: "TX", Title: "Account Executive"},
+		{SAMAccountName: "bob", Department: "Sales", State: "TX", Title: "Sales Intern"},
+		{SAMAccountName: "cid", Department: "Sales", State: "CA"},
+		{SAMAccountName: "dee", Department: "Engineering", State: "TX"},
+	}
+
+	groups := expand(t, rule, users)
+	if got := memberNames(groups["texas-sales"]); len(groups) != 1 || len(got) != 1 || got[0] != "ann" {
+		t.Errorf("ExpandRule() = %v, want texas-sales with only ann", groups)
+	}
+
+	if groups := expand(t, rule, users[1:]); len(groups) != 0 {
+		t.Errorf("ExpandRule() = %v, want no group when nobody matches", groups)
+	}
+}
+
+func TestExpandRuleRejectsDuplicateNames(t *testing.T) {
+	t.Setenv("GROUP_EMAIL_DOMAIN", "example.com")
+	tests := []struct {
+		name    string
+		rules   string
+		titles  []string
+		wantErr string
+	}{
+		{
+			name:    "values differing in case",
+			rules:   "rules:\n  - id: titles\n    group_by: title\n",
+			titles:  []string{"Engineer", "engineer"},
+			wantErr: `both render CN "list-titles-engineer"`,
+		},
+		{
+			name:    "values differing in punctuation",
+			rules:   "rules:\n  - id: titles\n    group_by: title\n",
+			titles:  []string{"R&D", "RD"},
+			wantErr: `both render CN "list-titles-rd"`,
+		},
+		{
+			name:    "template dropping the value",
+			rules:   "rules:\n  - id: titles\n    group_by: title\n    naming:\n      cn: \"list-{{ .Category }}-{{ .Slug }}\"\n      mail: \"titles@{{ .Domain }}\"\n",
+			titles:  []string{"Engineer", "Manager"},
+			wantErr: `both render mail "titles@example.com"`,
+		},
+		{
+			name:   "folded by normalize",
+			rules:  "rules:\n  - id: titles\n    group_by: title\n    normalize: [trim, lower]\n",
+			titles: []string{"Engineer", "engineer"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rule := loadRule(t, tt.rules)
+			users := make([]active_directory.ADUser, len(tt.titles))
+			for i, title := range tt.titles {
+				users[i] = active_directory.ADUser{SAMAccountName: title, Title: title}
+			}
+
+			_, err := ExpandRule(rule, users)
+			if tt.wantErr == "" {
+				if err != nil {
+					t.Fatalf("ExpandRule() = %v", err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Fatalf("ExpandRule() = %v, want error containing %q", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestBuildPlanRejectsNamesSharedAcrossRules(t *testing.T) {
+	t.Setenv("GROUP_EMAIL_DOMAIN", "example.com")
+	path := filepath.Join(t.TempDir(), "groups.yaml")
+	rules := `
+rules:
+  - id: sales
+    value: sales
+    filter: { department: Sales }
+    naming: { category: team }
+    targets: [ad]
+  - id: sales-titles
+    group_by: title
+    naming: { category: team }
+    targets: [ad]
+`
+	if err := os.WriteFile(path, []byte(rules), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	cfg, err := config.Load(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	users := []active_directory.ADUser{
+		{SAMAccountName: "ann", Department: "Sales", Title: "Sales"},
+	}
+
+	// Nothing reaches a directory: the run only targets Google, which neither rule syncs to.
+	_, err = BuildPlan(nil, nil, cfg, nil, users, Options{Targets: []string{config.TargetGoogle}})
+	if err == nil || !strings.Contains(err.Error(), `both render CN "list-team-sales"`) {
+		t.Fatalf("BuildPlan() = %v, want a shared-name error", err)
+	}
+}
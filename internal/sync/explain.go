@@ -0,0 +1,88 @@
+package sync
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/matthewdavidson09/dynamic-distro-groups/internal/active_directory"
+	"github.com/matthewdavidson09/dynamic-distro-groups/internal/config"
+)
+
+// RuleExplanation says whether a user lands in a rule's groups and, if not, why.
+type RuleExplanation struct {
+	RuleID string
+	Groups []string // mails of the rule's groups the user is a member of
+	Reason string   // why the user is excluded; empty when Groups is not
+}
+
+// ExplainUser evaluates every rule for one user.
+func ExplainUser(cfg *config.Config, users []active_directory.ADUser, user active_directory.ADUser) ([]RuleExplanation, error) {
+	index := active_directory.NewUserIndex(users)
+	userDN := active_directory.NormalizeDN(user.DN)
+
+	var explanations []RuleExplanation
+	for _, rule := range cfg.Rules {
+		exp := RuleExplanation{RuleID: rule.ID}
+
+		groups, err := ExpandRule(rule, users)
+		if err != nil {
+			return nil, fmt.Errorf("rule %s: %w", rule.ID, err)
+		}
+		for _, g := range groups {
+			for _, m := range g.Members {
+				if active_directory.NormalizeDN(m.DN) == userDN {
+					exp.Groups = append(exp.Groups, g.Names.Mail)
+					break
+				}
+			}
+		}
+
+		if len(exp.Groups) == 0 {
+			exp.Reason, err = exclusionReason(rule, user, index)
+			if err != nil {
+				return nil, fmt.Errorf("rule %s: %w", rule.ID, err)
+			}
+		}
+		explanations = append(explanations, exp)
+	}
+	return explanations, nil
+}
+
+// exclusionReason finds the first rule stage that drops the user.
+func exclusionReason(rule config.Rule, user active_directory.ADUser, index active_directory.UserIndex) (string, error) {
+	for attr, want := range rule.Filter {
+		if len(filterUsers([]active_directory.ADUser{user}, map[string]string{attr: want})) == 0 {
+			return fmt.Sprintf("filter %s=%q does not match (has %q)", attr, want, user.Attribute(attr)), nil
+		}
+	}
+
+	if rule.Where != nil && !rule.Where.Matches(user) {
+		return "where condition is false", nil
+	}
+
+	if prog := rule.Program(); prog != nil {
+		ok, err := prog.Eval(userEnv{user: user, index: index})
+		if err != nil {
+			return "", err
+		}
+		if !ok {
+			return fmt.Sprintf("match is false: %s", prog), nil
+		}
+	}
+
+	switch {
+	case strings.EqualFold(rule.GroupBy, "manager"):
+		return "has no manager with a mail address and no direct reports", nil
+	case rule.GroupBy != "":
+		return fmt.Sprintf("%s is empty", rule.GroupBy), nil
+	case len(rule.CrossProduct) > 0:
+		var empty []string
+		for _, attr := range rule.CrossProduct {
+			if strings.TrimSpace(user.Attribute(attr)) == "" {
+				empty = append(empty, attr)
+			}
+		}
+		return fmt.Sprintf("%s is empty", strings.Join(empty, ", ")), nil
+	}
+	return "not selected", nil
+}
@@ -2,305 +2,390 @@ package sync
 
 import (
 	"context"
+	"encoding/json"
+	"errors"
 	"fmt"
-	"math/rand"
+	"net/http"
+	"sort"
 	"strings"
-	"time"
 
+	"github.com/matthewdavidson09/dynamic-distro-groups/internal/config"
 	"github.com/matthewdavidson09/dynamic-distro-groups/internal/googleclient"
+	"github.com/matthewdavidson09/dynamic-distro-groups/internal/plan"
 	"github.com/matthewdavidson09/dynamic-distro-groups/tools"
 	admin "google.golang.org/api/admin/directory/v1"
+	"google.golang.org/api/googleapi"
 	"google.golang.org/api/groupssettings/v1"
-	"google.golang.org/api/option"
 )
 
+const (
+	roleMember  = "MEMBER"
+	roleManager = "MANAGER"
+)
+
+// managedDescription is the description of Google groups this tool creates, followed by the ownership marker.
+const managedDescription = "Synced from Active Directory"
+
+// ErrNotManaged is returned for a Google group that matches a rule but lacks that rule's ownership marker.
+var ErrNotManaged = errors.New("Google group is not managed by this rule")
+
+// googleDescription is the description of a Google group owned by ruleID.
+func googleDescription(ruleID string) string {
+	return managedDescription + " [" + plan.OwnerMarker(ruleID) + "]"
+}
+
+// checkGoogleOwner returns an error wrapping ErrNotManaged unless the group carries the ownership marker of ruleID.
+func checkGoogleOwner(group *admin.Group, ruleID string) error {
+	owner, ok := plan.ParseOwnerMarker(group.Description)
+	if !ok {
+		return fmt.Errorf("%s has no ownership marker, run adopt to take it over: %w", group.Email, ErrNotManaged)
+	}
+	if owner != ruleID {
+		return fmt.Errorf("%s is owned by rule %q, run adopt to move it to %q: %w", group.Email, owner, ruleID, ErrNotManaged)
+	}
+	return nil
+}
+
+// settingsIgnored are Groups Settings fields that describe the group rather than configure it.
+var settingsIgnored = map[string]bool{"kind": true, "email": true, "name": true, "description": true}
+
 // normalizeEmail lowercases and trims whitespace for reliable comparisons.
 func normalizeEmail(email string) string {
 	return strings.ToLower(strings.TrimSpace(email))
 }
 
-// SyncGoogleGroup ensures a Google Group exists and reflects the given AD users.
-func SyncGoogleGroup(
-	ctx context.Context,
-	svc *admin.Service,
-	groupEmail, groupName string,
-	adUsers []string,
-	dryRun bool,
-) (int, int, error) {
-	group, err := getOrCreateGoogleGroup(ctx, svc, groupEmail, groupName)
-	if err != nil {
-		return 0, 0, fmt.Errorf("google group sync error: %w", err)
+// planGoogleGroup computes the changes that make the Google group match g, adding only users accounts
+// knows to have a mailbox. It only reads from Google.
+func planGoogleGroup(ctx context.Context, gs *googleclient.Clients, accounts *googleUsers, g GroupTarget, settings *groupssettings.Groups) (*plan.GooglePlan, error) {
+	groupEmail := g.Names.Mail
+	p := &plan.GooglePlan{}
+
+	// 1. Look up the group, by its recorded ID first so a renamed group is found, and its current
+	// members and settings
+	current := map[string]string{}
+	var currentSettings *groupssettings.Groups
+	group, err := getGoogleGroup(gs, g)
+	switch {
+	case isNotFound(err):
+		p.Create = &plan.GoogleGroup{Name: g.Names.GoogleName, Description: googleDescription(g.Rule.ID)}
+	case err != nil:
+		return nil, fmt.Errorf("failed to get group %s: %w", groupEmail, err)
+	default:
+		if err := checkGoogleOwner(group, g.Rule.ID); err != nil {
+			return nil, err
+		}
+		p.ID = group.Id
+		if normalizeEmail(group.Email) != groupEmail {
+			p.RenameFrom = normalizeEmail(group.Email)
+		}
+		// A revived orphan loses its stamp; the settings diff below makes it visible again.
+		if _, stamped := plan.ParseOrphanStamp(group.Description); stamped {
+			p.Description = plan.StripOrphanStamp(group.Description)
+		}
+		current, err = ListGoogleGroupMembers(ctx, gs.Directory, group.Id)
+		if err != nil {
+			return nil, fmt.Errorf("failed to fetch current members: %w", err)
+		}
+		currentSettings, err = gs.Settings.Groups.Get(group.Email).Do()
+		if err != nil {
+			return nil, fmt.Errorf("failed to fetch group settings: %w", err)
+		}
 	}
+	p.MemberCount = len(current)
+	p.MembersHash = hashGoogleMembers(current)
 
-	adUserSet := make(map[string]struct{})
-	userCache := make(map[string]bool)
+	// 2. Build desired member -> role map, skipping new members without a usable mailbox
+	desired := map[string]string{}
+	for _, user := range g.Members {
+		email := normalizeEmail(user.Email)
+		if email == "" {
+			continue
+		}
+		// Google lists members by primary address, so a mail that is an alias must not look missing.
+		account := accounts.lookup(email)
+		if account != nil {
+			email = account.Primary
+		}
+		if _, exists := current[email]; !exists {
+			switch {
+			case account == nil:
+				tools.Log.Debugf("Skipping %s — no Google user", email)
+				continue
+			case account.Suspended:
+				tools.Log.Debugf("Skipping %s — suspended in Google", email)
+				continue
+			case !account.Mailbox:
+				tools.Log.Debugf("Skipping %s — no mailbox setup", email)
+				continue
+			}
+		}
 
-	for _, email := range adUsers {
-		normalized := normalizeEmail(email)
-		if normalized != "" {
-			adUserSet[normalized] = struct{}{}
+		role := roleMember
+		if g.Rule.Google.Roles == config.RolesManagers && len(user.DirectReports) > 0 {
+			role = roleManager
 		}
+		desired[email] = role
 	}
 
-	// Fetch current Google Group members (paged)
-	currentMembers := make(map[string]bool)
-	memberListCall := svc.Members.List(group.Email)
-	err = memberListCall.Pages(ctx, func(page *admin.Members) error {
-		for _, m := range page.Members {
-			if m.Email != "" {
-				currentMembers[normalizeEmail(m.Email)] = true
-			}
-			if m.Id != "" && m.Type == "ALIAS" {
-				currentMembers[normalizeEmail(m.Id)] = true
-			}
+	// 3. Diff members. Roles are only managed under the managers policy.
+	applied := make(map[string]string, len(desired))
+	for email, role := range desired {
+		currentRole, exists := current[email]
+		applied[email] = role
+		switch {
+		case !exists:
+			p.AddMembers = append(p.AddMembers, plan.Member{Email: email, Role: role})
+		case g.Rule.Google.Roles == config.RolesManagers && currentRole != role:
+			p.UpdateRoles = append(p.UpdateRoles, plan.Member{Email: email, Role: role})
+		default:
+			applied[email] = currentRole
 		}
-		return nil
-	})
+	}
+	p.DesiredHash = hashGoogleMembers(applied)
+	for email := range current {
+		if _, ok := desired[email]; !ok {
+			p.RemoveMembers = append(p.RemoveMembers, email)
+		}
+	}
+	sortMembers(p.AddMembers)
+	sortMembers(p.UpdateRoles)
+	sort.Strings(p.RemoveMembers)
+
+	// 4. Diff settings
+	p.Settings, err = diffSettings(settings, currentSettings)
 	if err != nil {
-		tools.Log.WithError(err).Errorf("Error fetching group members for %s", group.Email)
+		return nil, err
 	}
 
-	var toAdd, toRemove []string
+	tools.Log.WithFields(map[string]interface{}{
+		"group":    groupEmail,
+		"create":   p.Create != nil,
+		"add":      len(p.AddMembers),
+		"update":   len(p.UpdateRoles),
+		"remove":   len(p.RemoveMembers),
+		"settings": len(p.Settings),
+	}).Debug("Google Group sync plan")
 
-	// Determine additions
-	for email := range adUserSet {
-		if email == "" || currentMembers[email] {
-			continue
-		}
+	return p, nil
+}
 
-		if allowed, cached := userCache[email]; cached {
-			if allowed {
-				toAdd = append(toAdd, email)
-			}
-			continue
+// verifyGooglePlan checks that the group is still in the state p was planned against.
+func verifyGooglePlan(ctx context.Context, gs *googleclient.Clients, groupEmail string, p *plan.GooglePlan) error {
+	groupKey := googleGroupKey(groupEmail, p)
+	_, err := gs.Directory.Groups.Get(groupKey).Do()
+	switch {
+	case isNotFound(err):
+		if p.Create == nil {
+			return fmt.Errorf("Google group %s no longer exists: %w", groupEmail, plan.ErrDrift)
 		}
+		return nil
+	case err != nil:
+		return fmt.Errorf("failed to get group %s: %w", groupEmail, err)
+	case p.Create != nil:
+		return fmt.Errorf("Google group %s was created since the plan was made: %w", groupEmail, plan.ErrDrift)
+	}
 
-		allowed := isMailboxUser(svc, email)
-		userCache[email] = allowed
-		if allowed {
-			toAdd = append(toAdd, email)
-		} else {
-			tools.Log.Debugf("Skipping %s — no mailbox", email)
-		}
+	current, err := ListGoogleGroupMembers(ctx, gs.Directory, groupKey)
+	if err != nil {
+		return fmt.Errorf("failed to fetch current members: %w", err)
+	}
+	if hashGoogleMembers(current) != p.MembersHash {
+		return fmt.Errorf("members of Google group %s changed since the plan was made: %w", groupEmail, plan.ErrDrift)
 	}
+	return nil
+}
 
-	// Determine removals
-	for email := range currentMembers {
-		if _, ok := adUserSet[email]; !ok {
-			toRemove = append(toRemove, email)
+// applyGooglePlan executes a Google plan and returns how many members were added and removed. It records
+// the ID of a group it creates in p.
+func applyGooglePlan(ctx context.Context, gs *googleclient.Clients, groupEmail string, p *plan.GooglePlan) (int, int, error) {
+	// 1. Create or rename the group and fix its description
+	if p.Create != nil {
+		group := &admin.Group{Email: groupEmail, Name: p.Create.Name, Description: p.Create.Description}
+		created, err := gs.Directory.Groups.Insert(group).Do()
+		if err != nil {
+			return 0, 0, fmt.Errorf("failed to create group %s: %w", groupEmail, err)
 		}
+		p.ID = created.Id
+		tools.Log.Infof("Created Google group %s", groupEmail)
 	}
-
-	if dryRun {
-		for _, email := range toAdd {
-			tools.Log.Infof("[DRY RUN] Would add %s to %s", email, groupEmail)
+	groupKey := googleGroupKey(groupEmail, p)
+	if p.RenameFrom != "" {
+		if err := renameGoogleGroup(gs, groupKey, p.RenameFrom, groupEmail); err != nil {
+			return 0, 0, err
 		}
-		for _, email := range toRemove {
-			tools.Log.Infof("[DRY RUN] Would remove %s from %s", email, groupEmail)
+	}
+	if p.Description != "" {
+		if _, err := gs.Directory.Groups.Patch(groupKey, &admin.Group{Description: p.Description}).Do(); err != nil {
+			return 0, 0, fmt.Errorf("failed to update description of %s: %w", groupEmail, err)
 		}
-		return len(toAdd), len(toRemove), nil
 	}
 
-	// Apply additions
-	for _, email := range toAdd {
-		member := &admin.Member{Email: email, Role: "MEMBER"}
-		_, err := svc.Members.Insert(group.Email, member).Do()
+	// 2. Apply member changes in batches, continuing past individual failures
+	added, addErrs := addGoogleMembers(ctx, gs, groupKey, groupEmail, p.AddMembers)
+	_, roleErrs := updateGoogleRoles(ctx, gs, groupKey, groupEmail, p.UpdateRoles)
+	removed, removeErrs := removeGoogleMembers(ctx, gs, groupKey, groupEmail, p.RemoveMembers)
+	failed := len(addErrs) + len(roleErrs) + len(removeErrs)
+
+	// 3. Apply settings
+	if len(p.Settings) > 0 {
+		settings, err := settingsFromMap(p.Settings)
 		if err != nil {
-			tools.Log.WithError(err).Errorf("Failed to add %s to %s", email, groupEmail)
-		} else {
-			tools.Log.Infof("Added %s to %s", email, groupEmail)
+			return added, removed, err
 		}
-	}
-
-	// Apply removals
-	for _, email := range toRemove {
-		if err := svc.Members.Delete(group.Email, email).Do(); err != nil {
-			tools.Log.WithError(err).Errorf("Failed to remove %s from %s", email, groupEmail)
-		} else {
-			tools.Log.Infof("Removed %s from %s", email, groupEmail)
+		if err := ApplyGoogleGroupSettings(ctx, gs.Settings, groupEmail, settings); err != nil {
+			return added, removed, err
 		}
+		tools.Log.Infof("Successfully applied Google group settings to %s", groupEmail)
 	}
 
-	return len(toAdd), len(toRemove), nil
+	if failed > 0 {
+		return added, removed, fmt.Errorf("%d member changes failed on %s", failed, groupEmail)
+	}
+	return added, removed, nil
 }
 
-func ApplyGoogleGroupSettings(ctx context.Context, groupEmail string) error {
-	client, err := googleclient.NewImpersonatedHTTPClient(ctx)
-	if err != nil {
-		return fmt.Errorf("failed to create impersonated client: %w", err)
+// getGoogleGroup fetches the group by its recorded ID, falling back to its rendered mail.
+func getGoogleGroup(gs *googleclient.Clients, g GroupTarget) (*admin.Group, error) {
+	if g.Known != nil && g.Known.GoogleID != "" {
+		group, err := gs.Directory.Groups.Get(g.Known.GoogleID).Do()
+		if !isNotFound(err) {
+			return group, err
+		}
 	}
+	return gs.Directory.Groups.Get(g.Names.Mail).Do()
+}
 
-	settingsService, err := groupssettings.NewService(ctx, option.WithHTTPClient(client))
-	if err != nil {
-		return fmt.Errorf("failed to create GroupsSettings service: %w", err)
+// googleGroupKey is how the API addresses the group: by ID when known, which survives renames.
+func googleGroupKey(groupEmail string, p *plan.GooglePlan) string {
+	if p.ID != "" {
+		return p.ID
 	}
+	return groupEmail
+}
 
-	settings := &groupssettings.Groups{
-		AllowExternalMembers:       "false",
-		AllowWebPosting:            "false",
-		AllowGoogleCommunication:   "false",
-		IncludeInGlobalAddressList: "false",
-		IsArchived:                 "true",
-		MembersCanPostAsTheGroup:   "false",
-		ShowInGroupDirectory:       "false",
-		MessageModerationLevel:     "MODERATE_NONE",
-		WhoCanContactOwner:         "ALL_IN_DOMAIN_CAN_CONTACT",
-		WhoCanAdd:                  "NONE_CAN_ADD",
-		WhoCanDiscoverGroup:        "ALL_MEMBERS_CAN_DISCOVER",
-		WhoCanJoin:                 "INVITED_CAN_JOIN",
-		WhoCanLeaveGroup:           "NONE_CAN_LEAVE",
-		WhoCanPostMessage:          "ALL_MANAGERS_CAN_POST",
-		WhoCanViewGroup:            "ALL_MEMBERS_CAN_VIEW",
-		WhoCanViewMembership:       "ALL_MANAGERS_CAN_VIEW",
-		WhoCanInvite:               "NONE_CAN_INVITE",
-		ReplyTo:                    "REPLY_TO_MANAGERS",
+// renameGoogleGroup changes a group's address and keeps the old one as an alias, so mail to it still arrives.
+func renameGoogleGroup(gs *googleclient.Clients, groupKey, from, to string) error {
+	if _, err := gs.Directory.Groups.Patch(groupKey, &admin.Group{Email: to}).Do(); err != nil {
+		return fmt.Errorf("failed to rename %s to %s: %w", from, to, err)
 	}
 
-	const maxRetries = 5
-	var attemptErr error
-
-	for i := 0; i < maxRetries; i++ {
-		_, attemptErr = settingsService.Groups.Update(groupEmail, settings).Do()
-		if attemptErr == nil {
+	// Google normally keeps the old address as an alias on its own; make sure of it.
+	aliases, err := gs.Directory.Groups.Aliases.List(groupKey).Do()
+	if err != nil {
+		return fmt.Errorf("failed to list aliases of %s: %w", to, err)
+	}
+	for _, a := range aliases.Aliases {
+		if alias, ok := a.(map[string]interface{}); ok && strings.EqualFold(fmt.Sprint(alias["alias"]), from) {
+			tools.Log.Infof("Renamed Google group %s to %s", from, to)
 			return nil
 		}
-
-		if strings.Contains(attemptErr.Error(), "Unable to lookup group") || strings.Contains(attemptErr.Error(), "Error 404") {
-			// Backoff before retry
-			wait := time.Duration(1<<i)*time.Second + time.Duration(rand.Intn(500))*time.Millisecond
-			time.Sleep(wait)
-			continue
-		}
-
-		// Other errors - don't retry
-		return fmt.Errorf("failed to apply group settings: %w", attemptErr)
+	}
+	if _, err := gs.Directory.Groups.Aliases.Insert(groupKey, &admin.Alias{Alias: from}).Do(); err != nil {
+		return fmt.Errorf("failed to keep %s as an alias of %s: %w", from, to, err)
 	}
 
-	return fmt.Errorf("failed to apply group settings after %d retries: %w", maxRetries, attemptErr)
+	tools.Log.Infof("Renamed Google group %s to %s", from, to)
+	return nil
 }
 
-// SyncGoogleGroupWithRoles syncs users to a Google Group, assigning MANAGER or MEMBER roles.
-func SyncGoogleGroupWithRoles(
-	ctx context.Context,
-	svc *admin.Service,
-	groupEmail, groupName string,
-	memberEmails []string,
-	managerEmails map[string]bool,
-	dryRun bool,
-) (int, int, error) {
-	group, err := getOrCreateGoogleGroup(ctx, svc, groupEmail, groupName)
-	if err != nil {
-		return 0, 0, fmt.Errorf("get/create group failed: %w", err)
-	}
-
-	// Build desired member -> role map
-	desiredMembers := map[string]string{}
-	userCache := make(map[string]bool)
+// ApplyGoogleGroupSettings patches the given settings onto a group, retrying while a new group propagates.
+// Throttling and server errors are already retried by the Google client.
+func ApplyGoogleGroupSettings(ctx context.Context, settingsService *groupssettings.Service, groupEmail string, settings *groupssettings.Groups) error {
+	const maxRetries = 5
 
-	for _, email := range memberEmails {
-		email = normalizeEmail(email)
-		if email == "" {
-			continue
+	// Throttling and server errors are retried by the transport; only propagation is retried here.
+	for attempt := 0; ; attempt++ {
+		_, err := settingsService.Groups.Patch(groupEmail, settings).Context(ctx).Do()
+		if err == nil {
+			return nil
 		}
-
-		// Cache mailbox check
-		allowed, cached := userCache[email]
-		if !cached {
-			allowed = isMailboxUser(svc, email)
-			userCache[email] = allowed
+		if !isPropagating(err) {
+			return fmt.Errorf("failed to apply group settings: %w", err)
 		}
-		if !allowed {
-			tools.Log.Debugf("Skipping %s — no mailbox setup", email)
-			continue
+		if attempt == maxRetries {
+			return fmt.Errorf("failed to apply group settings after %d retries: %w", maxRetries, err)
 		}
-
-		role := "MEMBER"
-		if managerEmails[email] {
-			role = "MANAGER"
+		if err := googleclient.Backoff(ctx, attempt); err != nil {
+			return fmt.Errorf("failed to apply group settings: %w", err)
 		}
-		desiredMembers[email] = role
 	}
+}
 
-	// Get current members (with roles)
-	currentMembers := map[string]string{}
-	err = svc.Members.List(group.Email).Pages(ctx, func(page *admin.Members) error {
-		for _, m := range page.Members {
-			if m.Email != "" {
-				currentMembers[normalizeEmail(m.Email)] = m.Role
-			}
-		}
-		return nil
-	})
+// diffSettings returns the desired settings fields that differ from current. A nil current means the
+// group does not exist yet, so every desired field is returned.
+func diffSettings(desired, current *groupssettings.Groups) (map[string]string, error) {
+	want, err := settingsToMap(desired)
 	if err != nil {
-		return 0, 0, fmt.Errorf("failed to fetch current members: %w", err)
+		return nil, err
 	}
-
-	var toAdd, toRemove, toUpdate []string
-
-	for email, desiredRole := range desiredMembers {
-		currentRole, exists := currentMembers[email]
-		if !exists {
-			toAdd = append(toAdd, email)
-		} else if currentRole != desiredRole {
-			toUpdate = append(toUpdate, email)
+	have := map[string]string{}
+	if current != nil {
+		if have, err = settingsToMap(current); err != nil {
+			return nil, err
 		}
 	}
 
-	for email := range currentMembers {
-		if _, ok := desiredMembers[email]; !ok {
-			toRemove = append(toRemove, email)
+	diff := map[string]string{}
+	for field, value := range want {
+		if have[field] != value {
+			diff[field] = value
 		}
 	}
+	return diff, nil
+}
 
-	tools.Log.WithFields(map[string]interface{}{
-		"group":   groupEmail,
-		"add":     len(toAdd),
-		"update":  len(toUpdate),
-		"remove":  len(toRemove),
-		"dry_run": dryRun,
-	}).Debug("Google Group sync plan")
-
-	if dryRun {
-		for _, email := range toAdd {
-			tools.Log.Infof("[DRY RUN] Would add %s to %s as %s", email, groupEmail, desiredMembers[email])
-		}
-		for _, email := range toUpdate {
-			tools.Log.Infof("[DRY RUN] Would update role for %s to %s", email, desiredMembers[email])
-		}
-		for _, email := range toRemove {
-			tools.Log.Infof("[DRY RUN] Would remove %s from %s", email, groupEmail)
-		}
-		return len(toAdd), len(toRemove), nil
+// settingsToMap flattens the set string fields of a settings object, keyed by API field name.
+func settingsToMap(settings *groupssettings.Groups) (map[string]string, error) {
+	data, err := json.Marshal(settings)
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode group settings: %w", err)
+	}
+	var raw map[string]interface{}
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return nil, fmt.Errorf("failed to decode group settings: %w", err)
 	}
 
-	// Add new members
-	for _, email := range toAdd {
-		member := &admin.Member{Email: email, Role: desiredMembers[email]}
-		if _, err := svc.Members.Insert(group.Email, member).Do(); err != nil {
-			tools.Log.WithError(err).Errorf("Failed to add %s to %s", email, groupEmail)
-		} else {
-			tools.Log.Infof("Added %s as %s to %s", email, member.Role, groupEmail)
+	out := map[string]string{}
+	for field, value := range raw {
+		if s, ok := value.(string); ok && s != "" && !settingsIgnored[field] {
+			out[field] = s
 		}
 	}
+	return out, nil
+}
 
-	// Update roles
-	for _, email := range toUpdate {
-		member := &admin.Member{Role: desiredMembers[email]}
-		if _, err := svc.Members.Update(group.Email, email, member).Do(); err != nil {
-			tools.Log.WithError(err).Errorf("Failed to update role for %s in %s", email, groupEmail)
-		} else {
-			tools.Log.Infof("Updated %s to role %s in %s", email, member.Role, groupEmail)
-		}
+func settingsFromMap(fields map[string]string) (*groupssettings.Groups, error) {
+	data, err := json.Marshal(fields)
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode group settings: %w", err)
+	}
+	var settings groupssettings.Groups
+	if err := json.Unmarshal(data, &settings); err != nil {
+		return nil, fmt.Errorf("failed to decode group settings: %w", err)
 	}
+	return &settings, nil
+}
 
-	// Remove obsolete
-	for _, email := range toRemove {
-		if err := svc.Members.Delete(group.Email, email).Do(); err != nil {
-			tools.Log.WithError(err).Errorf("Failed to remove %s from %s", email, groupEmail)
-		} else {
-			tools.Log.Infof("Removed %s from %s", email, groupEmail)
-		}
+func hashGoogleMembers(members map[string]string) string {
+	entries := make([]string, 0, len(members))
+	for email, role := range members {
+		entries = append(entries, email+"="+role)
 	}
+	return plan.HashMembers(entries)
+}
+
+func sortMembers(members []plan.Member) {
+	sort.Slice(members, func(i, j int) bool { return members[i].Email < members[j].Email })
+}
+
+func isNotFound(err error) bool {
+	var gErr *googleapi.Error
+	return errors.As(err, &gErr) && gErr.Code == http.StatusNotFound
+}
 
-	return len(toAdd), len(toRemove), nil
+// isPropagating reports whether err is the Groups Settings API not seeing a group yet that the Directory
+// API just created.
+func isPropagating(err error) bool {
+	var gErr *googleapi.Error
+	return isNotFound(err) || (errors.As(err, &gErr) && strings.Contains(gErr.Message, "Unable to lookup group"))
 }
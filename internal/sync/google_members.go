@@ -0,0 +1,150 @@
+package sync
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+
+	"github.com/matthewdavidson09/dynamic-distro-groups/internal/googleclient"
+	"github.com/matthewdavidson09/dynamic-distro-groups/internal/plan"
+	"github.com/matthewdavidson09/dynamic-distro-groups/tools"
+	admin "google.golang.org/api/admin/directory/v1"
+	"google.golang.org/api/googleapi"
+)
+
+// memberChange is one Google membership change, both as a batch call and as a call of its own.
+type memberChange struct {
+	member string
+	call   googleclient.BatchCall
+	single func() error
+	done   string // logged when the change is made
+	failed string // logged when it is not
+	// settled, if set, reports whether the error of a change means the member is already as wanted, made
+	// by an earlier attempt or by someone else since the plan.
+	settled func(err error) bool
+}
+
+// addGoogleMembers adds members to a group in batches and returns how many were added and the errors of
+// those that were not.
+func addGoogleMembers(ctx context.Context, gs *googleclient.Clients, groupKey, groupEmail string, members []plan.Member) (int, []error) {
+	changes := make([]memberChange, len(members))
+	for i, m := range members {
+		member := &admin.Member{Email: m.Email, Role: m.Role}
+		changes[i] = memberChange{
+			member: m.Email,
+			call:   googleclient.BatchCall{Method: http.MethodPost, Path: membersPath(groupKey, ""), Body: member},
+			single: func() error {
+				_, err := gs.Directory.Members.Insert(groupKey, member).Context(ctx).Do()
+				return err
+			},
+			done:    fmt.Sprintf("Added %s as %s to %s", m.Email, m.Role, groupEmail),
+			failed:  fmt.Sprintf("Failed to add %s to %s", m.Email, groupEmail),
+			settled: hasCode(http.StatusConflict),
+		}
+	}
+	return changeGoogleMembers(ctx, gs, groupEmail, changes)
+}
+
+// updateGoogleRoles changes members' roles in batches and returns how many were changed and the errors
+// of those that were not.
+func updateGoogleRoles(ctx context.Context, gs *googleclient.Clients, groupKey, groupEmail string, members []plan.Member) (int, []error) {
+	changes := make([]memberChange, len(members))
+	for i, m := range members {
+		member := &admin.Member{Role: m.Role}
+		changes[i] = memberChange{
+			member: m.Email,
+			call:   googleclient.BatchCall{Method: http.MethodPut, Path: membersPath(groupKey, m.Email), Body: member},
+			single: func() error {
+				_, err := gs.Directory.Members.Update(groupKey, m.Email, member).Context(ctx).Do()
+				return err
+			},
+			done:   fmt.Sprintf("Updated %s to role %s in %s", m.Email, m.Role, groupEmail),
+			failed: fmt.Sprintf("Failed to update role for %s in %s", m.Email, groupEmail),
+		}
+	}
+	return changeGoogleMembers(ctx, gs, groupEmail, changes)
+}
+
+// removeGoogleMembers removes members from a group in batches and returns how many were removed and the
+// errors of those that were not.
+func removeGoogleMembers(ctx context.Context, gs *googleclient.Clients, groupKey, groupEmail string, emails []string) (int, []error) {
+	changes := make([]memberChange, len(emails))
+	for i, email := range emails {
+		changes[i] = memberChange{
+			member: email,
+			call:   googleclient.BatchCall{Method: http.MethodDelete, Path: membersPath(groupKey, email)},
+			single: func() error {
+				return gs.Directory.Members.Delete(groupKey, email).Context(ctx).Do()
+			},
+			done:    fmt.Sprintf("Removed %s from %s", email, groupEmail),
+			failed:  fmt.Sprintf("Failed to remove %s from %s", email, groupEmail),
+			settled: hasCode(http.StatusNotFound),
+		}
+	}
+	return changeGoogleMembers(ctx, gs, groupEmail, changes)
+}
+
+// changeGoogleMembers sends changes as batch requests. A change the batch could not make because of
+// throttling or a server error, or because the whole batch failed, is resent on its own through the
+// retrying client, so one bad item never fails the others.
+func changeGoogleMembers(ctx context.Context, gs *googleclient.Clients, groupEmail string, changes []memberChange) (int, []error) {
+	if len(changes) == 0 {
+		return 0, nil
+	}
+
+	// 1. Everything in batches
+	calls := make([]googleclient.BatchCall, len(changes))
+	for i, c := range changes {
+		calls[i] = c.call
+	}
+	results := gs.Batch(ctx, calls)
+
+	// 2. Resend what may pass on its own
+	done := 0
+	var errs []error
+	resent := 0
+	for i, c := range changes {
+		err := results[i]
+		if googleclient.Retryable(err) {
+			resent++
+			err = c.single()
+		}
+		if err != nil && c.settled != nil && c.settled(err) {
+			err = nil
+		}
+		if err != nil {
+			tools.Log.WithError(err).Error(c.failed)
+			errs = append(errs, fmt.Errorf("%s: %w", c.member, err))
+			continue
+		}
+		tools.Log.Info(c.done)
+		done++
+	}
+
+	if resent > 0 {
+		tools.Log.WithFields(map[string]interface{}{
+			"group":  groupEmail,
+			"resent": resent,
+		}).Warn("Resent Google member changes a batch could not make")
+	}
+	return done, errs
+}
+
+// membersPath returns the Directory API path of a group's members, or of one member when email is set.
+func membersPath(groupKey, email string) string {
+	path := "admin/directory/v1/groups/" + url.PathEscape(groupKey) + "/members"
+	if email != "" {
+		path += "/" + url.PathEscape(email)
+	}
+	return path
+}
+
+// hasCode returns a check for a googleapi.Error with the given status code.
+func hasCode(code int) func(error) bool {
+	return func(err error) bool {
+		var gErr *googleapi.Error
+		return errors.As(err, &gErr) && gErr.Code == code
+	}
+}
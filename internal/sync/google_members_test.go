@@ -0,0 +1,50 @@
+package sync
+
+import (
+	"context"
+	"reflect"
+	"testing"
+
+	"github.com/matthewdavidson09/dynamic-distro-groups/internal/plan"
+)
+
+func TestChangeGoogleMembersSettled(t *testing.T) {
+	fake, gs := newFakeGoogle(t)
+	fake.addGroup("1", "list-dept-sales@example.com", "ann@example.com=MEMBER", "bob@example.com=MEMBER")
+	ctx := context.Background()
+
+	// ann was added and cid removed by someone else since the plan.
+	done, errs := addGoogleMembers(ctx, gs, "1", "list-dept-sales@example.com", []plan.Member{
+		{Email: "ann@example.com", Role: "MEMBER"},
+		{Email: "dan@example.com", Role: "MEMBER"},
+	})
+	if done != 2 || len(errs) != 0 {
+		t.Errorf("addGoogleMembers() = %d, %v; want 2 added", done, errs)
+	}
+	done, errs = removeGoogleMembers(ctx, gs, "1", "list-dept-sales@example.com", []string{"bob@example.com", "cid@example.com"})
+	if done != 2 || len(errs) != 0 {
+		t.Errorf("removeGoogleMembers() = %d, %v; want 2 removed", done, errs)
+	}
+
+	want := map[string]string{"ann@example.com": "MEMBER", "dan@example.com": "MEMBER"}
+	if got := fake.members["list-dept-sales@example.com"]; !reflect.DeepEqual(got, want) {
+		t.Errorf("members = %v, want %v", got, want)
+	}
+	// A settled change is not resent on its own.
+	for _, w := range fake.writes {
+		if w == "POST /admin/directory/v1/groups/1/members" || w == "DELETE /admin/directory/v1/groups/1/members/cid@example.com" {
+			t.Errorf("settled change was resent: %s", w)
+		}
+	}
+}
+
+func TestChangeGoogleMembersFailures(t *testing.T) {
+	fake, gs := newFakeGoogle(t)
+	fake.addGroup("1", "list-dept-sales@example.com")
+
+	// Role changes have no settled check, and the fake does not support them.
+	done, errs := updateGoogleRoles(context.Background(), gs, "1", "list-dept-sales@example.com", []plan.Member{{Email: "ann@example.com", Role: "MANAGER"}})
+	if done != 0 || len(errs) != 1 {
+		t.Errorf("updateGoogleRoles() = %d, %v; want 1 error", done, errs)
+	}
+}
@@ -0,0 +1,198 @@
+package sync
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"mime"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"net/textproto"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/matthewdavidson09/dynamic-distro-groups/internal/googleclient"
+	admin "google.golang.org/api/admin/directory/v1"
+)
+
+// fakeGoogle serves the parts of the Directory API the sync reads and writes, from memory.
+type fakeGoogle struct {
+	mu      sync.Mutex
+	groups  []*admin.Group
+	members map[string]map[string]string // group email -> member email -> role
+	users   []*admin.User                // listed fakeUsersPerPage at a time
+	writes  []string                     // "METHOD path" of every request that is not a read, a batch as one
+}
+
+// fakeUsersPerPage is small so listing users takes several pages.
+const fakeUsersPerPage = 2
+
+// newFakeGoogle starts a fake and returns clients for it.
+func newFakeGoogle(t *testing.T) (*fakeGoogle, *googleclient.Clients) {
+	t.Helper()
+	f := &fakeGoogle{members: map[string]map[string]string{}}
+	srv := httptest.NewServer(f)
+	t.Cleanup(srv.Close)
+
+	t.Setenv("GOOGLE_API_ENDPOINT", srv.URL)
+	t.Setenv("GOOGLE_RATE_LIMIT", "0")
+	t.Setenv("GOOGLE_MAX_RETRIES", "0")
+	gs, err := googleclient.New(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	return f, gs
+}
+
+// addGroup adds a group with members given as "email=ROLE".
+func (f *fakeGoogle) addGroup(id, email string, members ...string) *admin.Group {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	g := &admin.Group{Id: id, Email: email}
+	f.groups = append(f.groups, g)
+	f.members[email] = map[string]string{}
+	for _, m := range members {
+		addr, role, _ := strings.Cut(m, "=")
+		f.members[email][addr] = role
+	}
+	return g
+}
+
+func (f *fakeGoogle) group(key string) *admin.Group {
+	for _, g := range f.groups {
+		if strings.EqualFold(g.Id, key) || strings.EqualFold(g.Email, key) {
+			return g
+		}
+	}
+	return nil
+}
+
+func (f *fakeGoogle) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if r.Method != http.MethodGet {
+		f.writes = append(f.writes, r.Method+" "+r.URL.Path)
+	}
+	if r.URL.Path == "/batch/admin/directory_v1" {
+		f.serveBatch(w, r)
+		return
+	}
+	f.serve(w, r)
+}
+
+func (f *fakeGoogle) serve(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Path == "/admin/directory/v1/groups" && r.Method == http.MethodGet {
+		writeJSON(w, &admin.Groups{Groups: f.groups})
+		return
+	}
+	if r.URL.Path == "/admin/directory/v1/users" && r.Method == http.MethodGet {
+		start, _ := strconv.Atoi(r.URL.Query().Get("pageToken"))
+		end := min(start+fakeUsersPerPage, len(f.users))
+		page := &admin.Users{Users: f.users[start:end]}
+		if end < len(f.users) {
+			page.NextPageToken = strconv.Itoa(end)
+		}
+		writeJSON(w, page)
+		return
+	}
+
+	// admin/directory/v1/groups/<key>[/members[/<email>]]
+	parts := strings.Split(strings.TrimPrefix(r.URL.Path, "/admin/directory/v1/groups/"), "/")
+	g := f.group(parts[0])
+	if g == nil {
+		writeGoogleError(w, http.StatusNotFound, "notFound")
+		return
+	}
+	members := f.members[g.Email]
+
+	switch {
+	case len(parts) == 1 && r.Method == http.MethodGet:
+		writeJSON(w, g)
+
+	case len(parts) == 2 && r.Method == http.MethodGet:
+		var list admin.Members
+		for email, role := range members {
+			list.Members = append(list.Members, &admin.Member{Email: email, Role: role})
+		}
+		writeJSON(w, &list)
+
+	case len(parts) == 2 && r.Method == http.MethodPost:
+		var m admin.Member
+		if err := json.NewDecoder(r.Body).Decode(&m); err != nil {
+			writeGoogleError(w, http.StatusBadRequest, "invalid")
+			return
+		}
+		if _, ok := members[m.Email]; ok {
+			writeGoogleError(w, http.StatusConflict, "duplicate")
+			return
+		}
+		members[m.Email] = m.Role
+		writeJSON(w, &m)
+
+	case len(parts) == 3 && r.Method == http.MethodDelete:
+		if _, ok := members[parts[2]]; !ok {
+			writeGoogleError(w, http.StatusNotFound, "notFound")
+			return
+		}
+		delete(members, parts[2])
+		w.WriteHeader(http.StatusNoContent)
+
+	default:
+		writeGoogleError(w, http.StatusNotImplemented, "notImplemented")
+	}
+}
+
+// serveBatch answers each call of a multipart batch request as serve would on its own.
+func (f *fakeGoogle) serveBatch(w http.ResponseWriter, r *http.Request) {
+	_, params, err := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	if err != nil {
+		writeGoogleError(w, http.StatusBadRequest, "invalid")
+		return
+	}
+
+	var out bytes.Buffer
+	mw := multipart.NewWriter(&out)
+	mr := multipart.NewReader(r.Body, params["boundary"])
+	for {
+		part, err := mr.NextPart()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			writeGoogleError(w, http.StatusBadRequest, "invalid")
+			return
+		}
+		inner, err := http.ReadRequest(bufio.NewReader(part))
+		if err != nil {
+			writeGoogleError(w, http.StatusBadRequest, "invalid")
+			return
+		}
+		rec := httptest.NewRecorder()
+		f.serve(rec, inner)
+
+		id := strings.Replace(part.Header.Get("Content-ID"), "<item-", "<response-item-", 1)
+		pw, _ := mw.CreatePart(textproto.MIMEHeader{"Content-Type": {"application/http"}, "Content-ID": {id}})
+		rec.Result().Write(pw)
+	}
+	mw.Close()
+
+	w.Header().Set("Content-Type", "multipart/mixed; boundary="+mw.Boundary())
+	w.Write(out.Bytes())
+}
+
+func writeJSON(w http.ResponseWriter, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(v)
+}
+
+func writeGoogleError(w http.ResponseWriter, code int, reason string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	fmt.Fprintf(w, `{"error": {"code": %d, "message": %q, "errors": [{"reason": %q}]}}`, code, reason, reason)
+}
@@ -0,0 +1,59 @@
+package sync
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/matthewdavidson09/dynamic-distro-groups/internal/googleclient"
+	"github.com/matthewdavidson09/dynamic-distro-groups/tools"
+	admin "google.golang.org/api/admin/directory/v1"
+)
+
+// googleUser is what planning needs to know about a Workspace user.
+type googleUser struct {
+	Primary   string // normalized primary address
+	Suspended bool
+	Mailbox   bool // Gmail is set up
+}
+
+// googleUsers indexes every Workspace user of the customer by primary address and alias. It is loaded
+// once per run and only read afterwards, so group plans share it without locking.
+type googleUsers struct {
+	byEmail map[string]*googleUser
+}
+
+// loadGoogleUsers lists every user of the customer, 500 per page.
+func loadGoogleUsers(ctx context.Context, gs *googleclient.Clients) (*googleUsers, error) {
+	start := time.Now()
+	index := &googleUsers{byEmail: map[string]*googleUser{}}
+
+	err := gs.Directory.Users.List().
+		Customer("my_customer").
+		MaxResults(500).
+		Fields("nextPageToken", "users(primaryEmail,aliases,nonEditableAliases,suspended,isMailboxSetup)").
+		Pages(ctx, func(page *admin.Users) error {
+			for _, u := range page.Users {
+				user := &googleUser{Primary: normalizeEmail(u.PrimaryEmail), Suspended: u.Suspended, Mailbox: u.IsMailboxSetup}
+				index.byEmail[user.Primary] = user
+				for _, alias := range append(u.Aliases, u.NonEditableAliases...) {
+					index.byEmail[normalizeEmail(alias)] = user
+				}
+			}
+			return nil
+		})
+	if err != nil {
+		return nil, fmt.Errorf("failed to list Google users: %w", err)
+	}
+
+	tools.Log.WithFields(map[string]interface{}{
+		"addresses": len(index.byEmail),
+		"took":      time.Since(start).Round(time.Millisecond).String(),
+	}).Info("Loaded Google users")
+	return index, nil
+}
+
+// lookup returns the user with the primary address or alias email, or nil.
+func (u *googleUsers) lookup(email string) *googleUser {
+	return u.byEmail[normalizeEmail(email)]
+}
@@ -0,0 +1,55 @@
+package sync
+
+import (
+	"context"
+	"testing"
+
+	admin "google.golang.org/api/admin/directory/v1"
+)
+
+func TestLoadGoogleUsers(t *testing.T) {
+	f, gs := newFakeGoogle(t)
+	f.users = []*admin.User{
+		{PrimaryEmail: "Ana@example.com", Aliases: []string{"ana.lopez@example.com"}, IsMailboxSetup: true},
+		{PrimaryEmail: "bo@example.com", NonEditableAliases: []string{"bo@example.test.google.com"}, IsMailboxSetup: true, Suspended: true},
+		{PrimaryEmail: "cy@example.com"},
+	}
+
+	users, err := loadGoogleUsers(context.Background(), gs)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		email     string
+		primary   string
+		suspended bool
+		mailbox   bool
+	}{
+		{email: "ana@example.com", primary: "ana@example.com", mailbox: true},
+		{email: " ANA.Lopez@Example.com", primary: "ana@example.com", mailbox: true},
+		{email: "bo@example.test.google.com", primary: "bo@example.com", suspended: true, mailbox: true},
+		{email: "cy@example.com", primary: "cy@example.com"},
+		{email: "dee@example.com"},
+	}
+	for _, tt := range tests {
+		u := users.lookup(tt.email)
+		if tt.primary == "" {
+			if u != nil {
+				t.Errorf("lookup(%q) = %+v, want no user", tt.email, u)
+			}
+			continue
+		}
+		if u == nil {
+			t.Errorf("lookup(%q) = nil, want %s", tt.email, tt.primary)
+			continue
+		}
+		if u.Primary != tt.primary || u.Suspended != tt.suspended || u.Mailbox != tt.mailbox {
+			t.Errorf("lookup(%q) = %+v, want primary %s suspended %v mailbox %v", tt.email, u, tt.primary, tt.suspended, tt.mailbox)
+		}
+	}
+	// An alias and its primary address share one record.
+	if users.lookup("ana.lopez@example.com") != users.lookup("ana@example.com") {
+		t.Errorf("lookup() returned different records for an alias and its primary address")
+	}
+}
@@ -1,42 +1,53 @@
 package sync
 
 import (
-	"os"
-	"strings"
+	"errors"
 
 	"github.com/matthewdavidson09/dynamic-distro-groups/internal/active_directory"
+	"github.com/matthewdavidson09/dynamic-distro-groups/internal/config"
+	"github.com/matthewdavidson09/dynamic-distro-groups/internal/googleclient"
 	"github.com/matthewdavidson09/dynamic-distro-groups/internal/ldapclient"
+	"github.com/matthewdavidson09/dynamic-distro-groups/internal/plan"
+	"github.com/matthewdavidson09/dynamic-distro-groups/internal/state"
 	"github.com/matthewdavidson09/dynamic-distro-groups/tools"
 )
 
-func RunAllGroupSyncs(client *ldapclient.LDAPClient, users []active_directory.ADUser, dryRun bool) error {
-	targets := strings.Split(strings.ToLower(os.Getenv("SYNC_TARGETS")), ",")
+// RunAllGroupSyncs plans every rule in the config and applies the result straight away, recording the
+// groups and the run in st, or only logs it on a dry run. Groups that failed to plan are reported but do
+// not stop the others. A plan that exceeds the safety limits is not applied at all unless opts.Force is
+// set.
+func RunAllGroupSyncs(client *ldapclient.LDAPClient, gs *googleclient.Clients, cfg *config.Config, st *state.Store, users []active_directory.ADUser, opts Options) error {
+	run := StartRun("sync")
+	run.Forced = opts.Force
 
-	shouldRun := func(name string) bool {
-		for _, t := range targets {
-			if t == name || t == "all" {
-				return true
-			}
-		}
-		return false
+	p, planErr := BuildPlan(client, gs, cfg, st, users, opts)
+	if p == nil {
+		return finish(st, run, opts, nil, planErr)
 	}
 
-	if shouldRun("departments") {
-		tools.Log.Info("Running department group sync...")
-		SyncDepartments(client, users, dryRun)
-	}
-	if shouldRun("states") {
-		tools.Log.Info("Running state group sync...")
-		SyncStates(client, users, dryRun)
+	if opts.DryRun {
+		LogPlan(p)
 	}
-	if shouldRun("managers") {
-		tools.Log.Info("Running manager group sync...")
-		SyncManagers(client, users, dryRun)
+
+	if err := CheckSafety(p, cfg.Safety); err != nil {
+		if !opts.Force {
+			return finish(st, run, opts, p, errors.Join(planErr, err))
+		}
+		tools.Log.Warnf("Continuing despite safety limits (--force): %v", err)
 	}
-	if shouldRun("all") || shouldRun("all-employees") {
-		tools.Log.Info("Running all employees group sync...")
-		SyncAllEmployees(client, users, dryRun)
+
+	if opts.DryRun {
+		return planErr
 	}
 
-	return nil
+	// The plan was made moments ago, so there is nothing to verify it against.
+	return finish(st, run, opts, p, errors.Join(planErr, ApplyPlan(client, gs, p, st, run, false)))
+}
+
+// finish records a run that was not a dry run and returns its result.
+func finish(st *state.Store, run *state.Run, opts Options, p *plan.Plan, err error) error {
+	if opts.DryRun {
+		return err
+	}
+	return errors.Join(err, FinishRun(st, run, p, err))
 }
@@ -0,0 +1,41 @@
+package sync
+
+import (
+	"errors"
+	"time"
+
+	"github.com/matthewdavidson09/dynamic-distro-groups/internal/plan"
+	"github.com/matthewdavidson09/dynamic-distro-groups/internal/state"
+)
+
+// StartRun begins the history entry of a sync or apply.
+func StartRun(command string) *state.Run {
+	return &state.Run{Command: command, StartedAt: time.Now().UTC()}
+}
+
+// FinishRun completes run with the plan it applied (nil if planning failed outright) and its result,
+// adds it to the history and saves st. The changes themselves are counted by ApplyPlan as it makes them,
+// so a run that failed part way records only what it did.
+func FinishRun(st *state.Store, run *state.Run, p *plan.Plan, err error) error {
+	run.FinishedAt = time.Now().UTC()
+
+	switch {
+	case err == nil:
+		run.Outcome = state.OutcomeSuccess
+	case errors.Is(err, ErrUnsafe) || errors.Is(err, plan.ErrDrift):
+		run.Outcome = state.OutcomeAborted
+	default:
+		run.Outcome = state.OutcomeFailed
+	}
+	if err != nil {
+		run.Error = err.Error()
+	}
+
+	if p != nil {
+		run.SourceUsers = p.SourceUsers
+		run.Groups = len(p.Groups)
+	}
+
+	st.AddRun(*run)
+	return st.Save()
+}
@@ -0,0 +1,63 @@
+package sync
+
+import (
+	"errors"
+	"fmt"
+	"path/filepath"
+	"testing"
+
+	"github.com/matthewdavidson09/dynamic-distro-groups/internal/plan"
+	"github.com/matthewdavidson09/dynamic-distro-groups/internal/state"
+)
+
+func TestFinishRun(t *testing.T) {
+	p := &plan.Plan{SourceUsers: 10, Groups: []plan.GroupPlan{
+		{Key: "a", Google: &plan.GooglePlan{AddMembers: []plan.Member{{Email: "ann@example.com"}}}},
+		{Key: "b"},
+	}}
+
+	tests := []struct {
+		name        string
+		err         error
+		wantOutcome string
+	}{
+		{name: "success", wantOutcome: state.OutcomeSuccess},
+		{name: "failure", err: errors.New("1 of 2 groups failed"), wantOutcome: state.OutcomeFailed},
+		{name: "unsafe", err: fmt.Errorf("%w: too many removals", ErrUnsafe), wantOutcome: state.OutcomeAborted},
+		{name: "drift", err: fmt.Errorf("re-run plan: %w", plan.ErrDrift), wantOutcome: state.OutcomeAborted},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "state.json")
+			st, err := state.Open(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			// The counts are ApplyPlan's, so the plan's addition is not recorded.
+			run := StartRun("apply")
+			if err := FinishRun(st, run, p, tt.err); err != nil {
+				t.Fatal(err)
+			}
+
+			saved, err := state.Open(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			got := saved.LastRun()
+			if got == nil {
+				t.Fatal("FinishRun() saved no run")
+			}
+			if got.Outcome != tt.wantOutcome || got.SourceUsers != 10 || got.Groups != 2 || got.Added != 0 {
+				t.Errorf("run = %+v, want outcome %s with 10 users, 2 groups and nothing added", got, tt.wantOutcome)
+			}
+			if (got.Error != "") != (tt.err != nil) {
+				t.Errorf("run error = %q, want %v", got.Error, tt.err)
+			}
+			if got.FinishedAt.Before(got.StartedAt) {
+				t.Errorf("run finished at %v before it started at %v", got.FinishedAt, got.StartedAt)
+			}
+		})
+	}
+}
@@ -0,0 +1,222 @@
+package sync
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"sort"
+	"time"
+
+	"github.com/matthewdavidson09/dynamic-distro-groups/internal/active_directory"
+	"github.com/matthewdavidson09/dynamic-distro-groups/internal/config"
+	"github.com/matthewdavidson09/dynamic-distro-groups/internal/googleclient"
+	"github.com/matthewdavidson09/dynamic-distro-groups/internal/ldapclient"
+	"github.com/matthewdavidson09/dynamic-distro-groups/internal/plan"
+	"github.com/matthewdavidson09/dynamic-distro-groups/tools"
+	admin "google.golang.org/api/admin/directory/v1"
+	"google.golang.org/api/groupssettings/v1"
+)
+
+// hiddenSettings make a retired Google group invisible and stop mail to it.
+var hiddenSettings = &groupssettings.Groups{
+	IncludeInGlobalAddressList: "false",
+	ShowInGroupDirectory:       "false",
+	WhoCanPostMessage:          "NONE_CAN_POST",
+}
+
+// planOrphans finds the managed groups in AD and Google that p does not produce and adds their
+// lifecycle actions to it. Only groups carrying an ownership marker are considered: in AD, those in
+// GROUP_OU and the archive OU; in Google, those in GROUP_EMAIL_DOMAIN.
+func planOrphans(client *ldapclient.LDAPClient, gs *googleclient.Clients, orphans config.Orphans, p *plan.Plan, opts Options) error {
+	ctx := context.Background()
+	now := time.Now()
+
+	// 1. Active Directory
+	if opts.targetSelected(config.TargetAD) {
+		desired := make(map[string]bool)
+		for _, g := range p.Groups {
+			if g.AD != nil {
+				desired[active_directory.NormalizeDN(g.AD.DN)] = true
+			}
+		}
+
+		policy := active_directory.OrphanPolicy{
+			ArchiveOU:       orphans.ArchiveOU,
+			DeleteAfterDays: orphans.DeleteAfterDays,
+			Hide:            orphans.HideInAD,
+		}
+		ous := []string{os.Getenv("GROUP_OU")}
+		if orphans.ArchiveOU != "" {
+			ous = append(ous, orphans.ArchiveOU)
+		}
+		for i, ou := range ous {
+			groups, err := active_directory.ListGroups(client, ou)
+			if err != nil {
+				return err
+			}
+			for _, group := range groups {
+				if _, owned := plan.ParseOwnerMarker(group.AdminDescription); !owned || desired[active_directory.NormalizeDN(group.DN)] {
+					continue
+				}
+				if o := active_directory.PlanOrphan(group, i > 0, policy, now); o != nil {
+					p.Orphans = append(p.Orphans, *o)
+				}
+			}
+		}
+	}
+
+	// 2. Google Workspace
+	if opts.targetSelected(config.TargetGoogle) {
+		// Existing groups are matched on their ID, which survives renames; a group still listed under the
+		// address it is being renamed from is matched on that too.
+		desiredIDs := make(map[string]bool)
+		desiredMails := make(map[string]bool)
+		for _, g := range p.Groups {
+			if g.Google == nil {
+				continue
+			}
+			if g.Google.ID != "" {
+				desiredIDs[g.Google.ID] = true
+			}
+			desiredMails[normalizeEmail(g.Mail)] = true
+			if g.Google.RenameFrom != "" {
+				desiredMails[normalizeEmail(g.Google.RenameFrom)] = true
+			}
+		}
+
+		domain := os.Getenv("GROUP_EMAIL_DOMAIN")
+		err := gs.Directory.Groups.List().Domain(domain).Pages(ctx, func(page *admin.Groups) error {
+			for _, group := range page.Groups {
+				if _, owned := plan.ParseOwnerMarker(group.Description); !owned || desiredIDs[group.Id] || desiredMails[normalizeEmail(group.Email)] {
+					continue
+				}
+				o, err := planGoogleOrphan(ctx, gs, group, orphans, now)
+				if err != nil {
+					return err
+				}
+				if o != nil {
+					p.Orphans = append(p.Orphans, *o)
+				}
+			}
+			return nil
+		})
+		if err != nil {
+			return fmt.Errorf("failed to list Google groups in %s: %w", domain, err)
+		}
+	}
+
+	tools.Log.Debugf("Found %d orphaned groups needing action", len(p.Orphans))
+	return nil
+}
+
+// planGoogleOrphan decides what to do with a managed Google group no rule produces. It returns nil when
+// the group is already retired and not yet due for deletion.
+func planGoogleOrphan(ctx context.Context, gs *googleclient.Clients, group *admin.Group, orphans config.Orphans, now time.Time) (*plan.OrphanPlan, error) {
+	members, err := ListGoogleGroupMembers(ctx, gs.Directory, group.Email)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch members of %s: %w", group.Email, err)
+	}
+
+	o := &plan.OrphanPlan{
+		Target:      config.TargetGoogle,
+		Mail:        normalizeEmail(group.Email),
+		MemberCount: len(members),
+		MembersHash: hashGoogleMembers(members),
+	}
+
+	since, stamped := plan.ParseOrphanStamp(group.Description)
+	if !stamped {
+		since = now.UTC().Format(plan.DateLayout)
+		o.Stamp = true
+		o.Hide = true
+	}
+	o.OrphanedSince = since
+
+	if plan.DeleteDue(since, orphans.DeleteAfterDays, now) {
+		o.Action = plan.OrphanDelete
+		return o, nil
+	}
+
+	o.Action = plan.OrphanRetire
+	for email := range members {
+		o.RemoveMembers = append(o.RemoveMembers, email)
+	}
+	sort.Strings(o.RemoveMembers)
+	if !o.Stamp && len(o.RemoveMembers) == 0 {
+		return nil, nil
+	}
+	return o, nil
+}
+
+// verifyGoogleOrphan checks that the group is still in the state o was planned against.
+func verifyGoogleOrphan(ctx context.Context, gs *googleclient.Clients, o *plan.OrphanPlan) error {
+	members, err := ListGoogleGroupMembers(ctx, gs.Directory, o.Mail)
+	if isNotFound(err) {
+		return fmt.Errorf("Google group %s no longer exists: %w", o.Mail, plan.ErrDrift)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to fetch members of %s: %w", o.Mail, err)
+	}
+	if hashGoogleMembers(members) != o.MembersHash {
+		return fmt.Errorf("members of Google group %s changed since the plan was made: %w", o.Mail, plan.ErrDrift)
+	}
+	return nil
+}
+
+// applyGoogleOrphan retires or deletes an orphaned Google group and returns how many members were removed.
+func applyGoogleOrphan(ctx context.Context, gs *googleclient.Clients, o *plan.OrphanPlan) (int, error) {
+	if o.Action == plan.OrphanDelete {
+		if err := gs.Directory.Groups.Delete(o.Mail).Do(); err != nil {
+			return 0, fmt.Errorf("failed to delete Google group %s: %w", o.Mail, err)
+		}
+		tools.Log.Infof("Deleted Google group %s", o.Mail)
+		return o.MemberCount, nil
+	}
+
+	// 1. Empty the group
+	removed, errs := removeGoogleMembers(ctx, gs, o.Mail, o.Mail, o.RemoveMembers)
+	if len(errs) > 0 {
+		return removed, fmt.Errorf("failed to remove %d members from %s: %w", len(errs), o.Mail, errors.Join(errs...))
+	}
+
+	// 2. Record when it was orphaned and hide it
+	if o.Stamp {
+		group, err := gs.Directory.Groups.Get(o.Mail).Do()
+		if err != nil {
+			return removed, fmt.Errorf("failed to get group %s: %w", o.Mail, err)
+		}
+		description := plan.AddOrphanStamp(group.Description, o.OrphanedSince)
+		if _, err := gs.Directory.Groups.Patch(o.Mail, &admin.Group{Description: description}).Do(); err != nil {
+			return removed, fmt.Errorf("failed to stamp %s: %w", o.Mail, err)
+		}
+	}
+	if o.Hide {
+		if err := ApplyGoogleGroupSettings(ctx, gs.Settings, o.Mail, hiddenSettings); err != nil {
+			return removed, err
+		}
+	}
+
+	tools.Log.WithFields(map[string]interface{}{
+		"group":   o.Mail,
+		"removed": removed,
+		"since":   o.OrphanedSince,
+	}).Info("Retired orphaned Google group")
+	return removed, nil
+}
+
+// verifyOrphan dispatches to the target's verification.
+func verifyOrphan(ctx context.Context, client *ldapclient.LDAPClient, gs *googleclient.Clients, o *plan.OrphanPlan) error {
+	if o.Target == config.TargetGoogle {
+		return verifyGoogleOrphan(ctx, gs, o)
+	}
+	return active_directory.VerifyOrphanPlan(client, o)
+}
+
+// applyOrphan dispatches to the target's lifecycle action.
+func applyOrphan(ctx context.Context, client *ldapclient.LDAPClient, gs *googleclient.Clients, o *plan.OrphanPlan) (int, error) {
+	if o.Target == config.TargetGoogle {
+		return applyGoogleOrphan(ctx, gs, o)
+	}
+	return active_directory.ApplyOrphanPlan(client, o)
+}
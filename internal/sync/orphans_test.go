@@ -0,0 +1,37 @@
+package sync
+
+import (
+	"testing"
+
+	"github.com/matthewdavidson09/dynamic-distro-groups/internal/config"
+	"github.com/matthewdavidson09/dynamic-distro-groups/internal/plan"
+)
+
+func TestPlanOrphansGoogle(t *testing.T) {
+	fake, gs := newFakeGoogle(t)
+	managed := googleDescription("departments")
+	fake.addGroup("1", "list-dept-sales@example.com", "ann@example.com=MEMBER").Description = managed
+	// Renamed by this plan, so Google still lists it under its old address.
+	fake.addGroup("2", "list-dept-people@example.com", "bob@example.com=MEMBER").Description = managed
+	fake.addGroup("3", "list-dept-gone@example.com", "cid@example.com=MEMBER").Description = managed
+	fake.addGroup("4", "hand-made@example.com", "dee@example.com=MEMBER").Description = "Made by hand"
+
+	p := &plan.Plan{Groups: []plan.GroupPlan{
+		{Mail: "list-dept-sales@example.com", Google: &plan.GooglePlan{ID: "1"}},
+		{Mail: "list-dept-hr@example.com", Google: &plan.GooglePlan{ID: "2", RenameFrom: "list-dept-people@example.com"}},
+		{Mail: "list-dept-new@example.com", Google: &plan.GooglePlan{Create: &plan.GoogleGroup{Name: "New"}}},
+	}}
+
+	err := planOrphans(nil, gs, config.Orphans{Enabled: true}, p, Options{Targets: []string{config.TargetGoogle}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(p.Orphans) != 1 || p.Orphans[0].Mail != "list-dept-gone@example.com" {
+		t.Fatalf("orphans = %+v, want only list-dept-gone@example.com", p.Orphans)
+	}
+
+	o := p.Orphans[0]
+	if o.Action != plan.OrphanRetire || !o.Stamp || len(o.RemoveMembers) != 1 || o.RemoveMembers[0] != "cid@example.com" {
+		t.Errorf("orphan = %+v, want it stamped and emptied", o)
+	}
+}
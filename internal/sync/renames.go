@@ -0,0 +1,226 @@
+package sync
+
+import (
+	"context"
+	"errors"
+	"os"
+	"sort"
+	"time"
+
+	"github.com/matthewdavidson09/dynamic-distro-groups/internal/active_directory"
+	"github.com/matthewdavidson09/dynamic-distro-groups/internal/config"
+	"github.com/matthewdavidson09/dynamic-distro-groups/internal/googleclient"
+	"github.com/matthewdavidson09/dynamic-distro-groups/internal/ldapclient"
+	"github.com/matthewdavidson09/dynamic-distro-groups/internal/plan"
+	"github.com/matthewdavidson09/dynamic-distro-groups/internal/state"
+	"github.com/matthewdavidson09/dynamic-distro-groups/tools"
+)
+
+// renameOverlap is the share of members a new group must have in common with a group that disappeared,
+// both ways, to be treated as that group renamed.
+const renameOverlap = 0.5
+
+// resolveKnownGroups attaches the IDs recorded in the state store to each group. A group with no record
+// that shares most of its members with a recorded group of the same rule that is no longer produced, say
+// after a department rename, takes over that group's record and so is renamed rather than recreated.
+// Records of groups that are no longer produced and no longer exist in any target are dropped.
+func resolveKnownGroups(client *ldapclient.LDAPClient, gs *googleclient.Clients, st *state.Store, rule config.Rule, groups []GroupTarget, archiveOU string) {
+	if st == nil {
+		return
+	}
+
+	// 1. Attach existing records and collect the ones no group claims any more
+	produced := make(map[string]bool)
+	var unknown []int
+	for i := range groups {
+		key := groups[i].Key()
+		produced[key] = true
+		if known, ok := st.Groups[key]; ok {
+			groups[i].Known = known
+		} else {
+			unknown = append(unknown, i)
+		}
+	}
+
+	var stale []string
+	for _, key := range st.RuleGroups(rule.ID) {
+		if !produced[key] {
+			stale = append(stale, key)
+		}
+	}
+	if len(stale) == 0 {
+		return
+	}
+
+	// 2. Forget groups deleted everywhere and collect the members of the rest
+	oldMembers := make(map[string]map[string]bool)
+	for _, key := range stale {
+		members, gone := knownMembers(client, gs, st.Groups[key], archiveOU)
+		if gone {
+			tools.Log.WithFields(map[string]interface{}{
+				"rule":  rule.ID,
+				"value": st.Groups[key].Value,
+				"mail":  st.Groups[key].Mail,
+			}).Info("Forgetting group that no longer exists")
+			delete(st.Groups, key)
+			continue
+		}
+		if len(members) > 0 {
+			oldMembers[key] = members
+		}
+	}
+	if len(unknown) == 0 {
+		return
+	}
+
+	// 3. Match new groups to vanished ones by membership
+
+	taken := make(map[string]bool)
+	for _, i := range unknown {
+		g := &groups[i]
+		bestKey, bestScore := "", 0.0
+		for key, old := range oldMembers {
+			if taken[key] {
+				continue
+			}
+			if score := overlap(old, g.Members); score >= renameOverlap && score > bestScore {
+				bestKey, bestScore = key, score
+			}
+		}
+		if bestKey == "" {
+			continue
+		}
+
+		taken[bestKey] = true
+		g.Known = st.Groups[bestKey]
+		g.RenamedKey = bestKey
+		tools.Log.WithFields(map[string]interface{}{
+			"rule":    rule.ID,
+			"from":    st.Groups[bestKey].Value,
+			"to":      g.Value,
+			"overlap": bestScore,
+		}).Info("Detected renamed group")
+	}
+}
+
+// knownMembers returns the live members of a recorded group, as normalized DNs and mail addresses. gone
+// reports that the group was not found under any of the IDs recorded for it.
+func knownMembers(client *ldapclient.LDAPClient, gs *googleclient.Clients, known *state.Group, archiveOU string) (members map[string]bool, gone bool) {
+	members = make(map[string]bool)
+	lookups, missing := 0, 0
+
+	if known.ADObjectGUID != "" {
+		for _, ou := range []string{os.Getenv("GROUP_OU"), archiveOU} {
+			if ou == "" {
+				continue
+			}
+			group, err := active_directory.GetGroupByGUID(client, known.ADObjectGUID, ou)
+			if err == nil {
+				for _, dn := range group.Members {
+					members[active_directory.NormalizeDN(dn)] = true
+				}
+				return members, false
+			}
+			lookups++
+			if errors.Is(err, active_directory.ErrGroupNotFound) {
+				missing++
+			}
+		}
+	}
+
+	if known.GoogleID != "" && gs != nil {
+		current, err := ListGoogleGroupMembers(context.Background(), gs.Directory, known.GoogleID)
+		lookups++
+		switch {
+		case err == nil:
+			for email := range current {
+				members[email] = true
+			}
+		case isNotFound(err):
+			missing++
+		}
+	}
+	return members, lookups > 0 && missing == lookups
+}
+
+// overlap is the smaller of the shares of old in users and of users in old.
+func overlap(old map[string]bool, users []active_directory.ADUser) float64 {
+	if len(old) == 0 || len(users) == 0 {
+		return 0
+	}
+
+	common := 0
+	for _, u := range users {
+		if old[active_directory.NormalizeDN(u.DN)] || old[normalizeEmail(u.Email)] {
+			common++
+		}
+	}
+	return min(float64(common)/float64(len(old)), float64(common)/float64(len(users)))
+}
+
+// RecordPlan stores the IDs of every group in an applied plan, moves renamed groups to their new key and
+// forgets targets whose orphaned group was deleted. Groups without an error in failed also get their
+// membership hashes and last sync time updated.
+func RecordPlan(st *state.Store, p *plan.Plan, failed map[string]error) {
+	now := time.Now().UTC()
+
+	for _, g := range p.Groups {
+		if g.Key == "" {
+			continue
+		}
+		if g.RenamedKey != "" {
+			delete(st.Groups, g.RenamedKey)
+		}
+
+		known, ok := st.Groups[g.Key]
+		if !ok {
+			known = &state.Group{}
+			st.Groups[g.Key] = known
+		}
+		known.RuleID, known.Value, known.Mail, known.UpdatedAt = g.RuleID, g.Value, g.Mail, now
+		if g.AD != nil && g.AD.ObjectGUID != "" {
+			known.ADObjectGUID = g.AD.ObjectGUID
+		}
+		if g.Google != nil && g.Google.ID != "" {
+			known.GoogleID = g.Google.ID
+		}
+
+		if err := failed[g.Key]; err != nil {
+			known.LastError = err.Error()
+			continue
+		}
+		known.LastError, known.LastSyncAt = "", now
+		if g.AD != nil && g.AD.DesiredHash != "" {
+			known.ADMembersHash = g.AD.DesiredHash
+		}
+		if g.Google != nil && g.Google.DesiredHash != "" {
+			known.GoogleMembersHash = g.Google.DesiredHash
+		}
+	}
+
+	for _, o := range p.Orphans {
+		if o.Action != plan.OrphanDelete {
+			continue
+		}
+		for _, key := range sortedKeys(st.Groups) {
+			known := st.Groups[key]
+			if known.Mail != o.Mail {
+				continue
+			}
+			if o.Target == config.TargetGoogle {
+				known.GoogleID = ""
+			} else {
+				known.ADObjectGUID = ""
+			}
+			if known.ADObjectGUID == "" && known.GoogleID == "" {
+				delete(st.Groups, key)
+			}
+		}
+	}
+}
+
+func sortedKeys(m map[string]*state.Group) []string {
+	keys := tools.MapKeys(m)
+	sort.Strings(keys)
+	return keys
+}
@@ -0,0 +1,52 @@
+package sync
+
+import (
+	"testing"
+
+	"github.com/matthewdavidson09/dynamic-distro-groups/internal/active_directory"
+	"github.com/matthewdavidson09/dynamic-distro-groups/internal/state"
+)
+
+func TestResolveKnownGroups(t *testing.T) {
+	fake, gs := newFakeGoogle(t)
+	fake.addGroup("g-people", "list-dept-people@example.com", "ann@example.com=MEMBER", "bob@example.com=MEMBER")
+	fake.addGroup("g-retired", "list-dept-retired@example.com")
+
+	st := &state.Store{Groups: map[string]*state.Group{
+		"departments/Sales":   {RuleID: "departments", Value: "Sales", GoogleID: "g-sales"},
+		"departments/People":  {RuleID: "departments", Value: "People", GoogleID: "g-people"},
+		"departments/Retired": {RuleID: "departments", Value: "Retired", GoogleID: "g-retired"},
+		"departments/Deleted": {RuleID: "departments", Value: "Deleted", GoogleID: "g-deleted"},
+		"states/TX":           {RuleID: "states", Value: "TX", GoogleID: "g-deleted-too"},
+	}}
+
+	rule := loadRule(t, "rules:\n  - id: departments\n    group_by: department\n")
+	groups := expand(t, rule, []active_directory.ADUser{
+		{SAMAccountName: "ann", Email: "ann@example.com", Department: "Finance"},
+		{SAMAccountName: "bob", Email: "bob@example.com", Department: "Finance"},
+		{SAMAccountName: "cid", Email: "cid@example.com", Department: "Sales"},
+	})
+	targets := []GroupTarget{groups["Finance"], groups["Sales"]}
+
+	resolveKnownGroups(nil, gs, st, rule, targets, "")
+
+	if finance := targets[0]; finance.RenamedKey != "departments/People" || finance.Known == nil || finance.Known.GoogleID != "g-people" {
+		t.Errorf("Finance took over %q, want the People record", finance.RenamedKey)
+	}
+	if sales := targets[1]; sales.Known == nil || sales.Known.GoogleID != "g-sales" || sales.RenamedKey != "" {
+		t.Errorf("Sales = %+v, want its own record", sales.Known)
+	}
+
+	// Deleted is gone from Google and forgotten; Retired still exists; other rules are left alone.
+	for key, want := range map[string]bool{
+		"departments/Sales":   true,
+		"departments/People":  true,
+		"departments/Retired": true,
+		"departments/Deleted": false,
+		"states/TX":           true,
+	} {
+		if _, ok := st.Groups[key]; ok != want {
+			t.Errorf("record %s kept = %v, want %v", key, ok, want)
+		}
+	}
+}
@@ -0,0 +1,73 @@
+package sync
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/matthewdavidson09/dynamic-distro-groups/internal/config"
+	"github.com/matthewdavidson09/dynamic-distro-groups/internal/plan"
+)
+
+// ErrUnsafe is returned when a plan exceeds the configured safety limits.
+var ErrUnsafe = errors.New("safety limits exceeded")
+
+// CheckSafety compares a plan against the safety limits and returns an error wrapping ErrUnsafe that
+// lists every limit it exceeds. A nil limits value disables every check.
+func CheckSafety(p *plan.Plan, s *config.Safety) error {
+	if s == nil {
+		return nil
+	}
+
+	var violations []string
+	if s.MinSourceUsers > 0 && p.SourceUsers < s.MinSourceUsers {
+		violations = append(violations, fmt.Sprintf("LDAP returned %d users, fewer than min_source_users %d", p.SourceUsers, s.MinSourceUsers))
+	}
+
+	// 1. Per group and target
+	runRemovals, runMembers := 0, 0
+	checkGroup := func(target, group string, removals, members int) {
+		runRemovals += removals
+		runMembers += members
+		if s.MaxGroupRemovals > 0 && removals > s.MaxGroupRemovals {
+			violations = append(violations, fmt.Sprintf("%s %s: %d removals, more than max_group_removals %d", target, group, removals, s.MaxGroupRemovals))
+		}
+		if pct := percent(removals, members); s.MaxGroupRemovalPercent > 0 && pct > s.MaxGroupRemovalPercent {
+			violations = append(violations, fmt.Sprintf("%s %s: removes %.0f%% of %d members, more than max_group_removal_percent %g", target, group, pct, members, s.MaxGroupRemovalPercent))
+		}
+	}
+	for _, g := range p.Groups {
+		if g.AD != nil {
+			checkGroup("AD", g.Mail, len(g.AD.RemoveMembers), g.AD.MemberCount)
+		}
+		if g.Google != nil {
+			checkGroup("Google", g.Mail, len(g.Google.RemoveMembers), g.Google.MemberCount)
+		}
+	}
+
+	// Retiring an orphan always empties it, so orphans only count towards the run limits.
+	for _, o := range p.Orphans {
+		runRemovals += o.Removals()
+		runMembers += o.MemberCount
+	}
+
+	// 2. Whole run
+	if s.MaxRunRemovals > 0 && runRemovals > s.MaxRunRemovals {
+		violations = append(violations, fmt.Sprintf("%d removals in total, more than max_run_removals %d", runRemovals, s.MaxRunRemovals))
+	}
+	if pct := percent(runRemovals, runMembers); s.MaxRunRemovalPercent > 0 && pct > s.MaxRunRemovalPercent {
+		violations = append(violations, fmt.Sprintf("removes %.0f%% of %d current members in total, more than max_run_removal_percent %g", pct, runMembers, s.MaxRunRemovalPercent))
+	}
+
+	if len(violations) == 0 {
+		return nil
+	}
+	return fmt.Errorf("%w:\n  %s", ErrUnsafe, strings.Join(violations, "\n  "))
+}
+
+func percent(part, whole int) float64 {
+	if whole == 0 {
+		return 0
+	}
+	return float64(part) * 100 / float64(whole)
+}
@@ -0,0 +1,176 @@
+package sync
+
+import (
+	"errors"
+	"fmt"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/matthewdavidson09/dynamic-distro-groups/internal/active_directory"
+	"github.com/matthewdavidson09/dynamic-distro-groups/internal/config"
+	"github.com/matthewdavidson09/dynamic-distro-groups/internal/plan"
+	"github.com/matthewdavidson09/dynamic-distro-groups/internal/state"
+)
+
+// removals returns n member addresses to remove.
+func removals(n int) []string {
+	out := make([]string, n)
+	for i := range out {
+		out[i] = fmt.Sprintf("user%d@example.com", i)
+	}
+	return out
+}
+
+// safetyPlan has an AD group removing 3 of 10 members and a Google group removing 1 of 10.
+func safetyPlan() *plan.Plan {
+	return &plan.Plan{
+		SourceUsers: 100,
+		Groups: []plan.GroupPlan{
+			{Mail: "sales@example.com", AD: &plan.ADPlan{RemoveMembers: removals(3), MemberCount: 10}},
+			{Mail: "hr@example.com", Google: &plan.GooglePlan{RemoveMembers: removals(1), MemberCount: 10}},
+		},
+	}
+}
+
+func TestCheckSafety(t *testing.T) {
+	tests := []struct {
+		name   string
+		limits *config.Safety
+		edit   func(*plan.Plan)
+		want   []string // violations, empty when safe
+	}{
+		{
+			name:   "no limits",
+			limits: nil,
+			edit:   func(p *plan.Plan) { p.SourceUsers = 0 },
+		},
+		{
+			name:   "within every limit",
+			limits: &config.Safety{MinSourceUsers: 100, MaxGroupRemovals: 3, MaxGroupRemovalPercent: 30, MaxRunRemovals: 4, MaxRunRemovalPercent: 20},
+		},
+		{
+			name:   "defaults refuse an empty directory",
+			limits: config.DefaultSafety(),
+			edit:   func(p *plan.Plan) { p.SourceUsers = 0 },
+			want:   []string{"LDAP returned 0 users, fewer than min_source_users 1"},
+		},
+		{
+			name:   "too few source users",
+			limits: &config.Safety{MinSourceUsers: 101},
+			want:   []string{"LDAP returned 100 users, fewer than min_source_users 101"},
+		},
+		{
+			name:   "group removal count",
+			limits: &config.Safety{MaxGroupRemovals: 2},
+			want:   []string{"AD sales@example.com: 3 removals, more than max_group_removals 2"},
+		},
+		{
+			name:   "group removal percent",
+			limits: &config.Safety{MaxGroupRemovalPercent: 25},
+			want:   []string{"AD sales@example.com: removes 30% of 10 members, more than max_group_removal_percent 25"},
+		},
+		{
+			name:   "group limits apply per target",
+			limits: &config.Safety{MaxGroupRemovals: 2},
+			edit: func(p *plan.Plan) {
+				p.Groups[1].AD = &plan.ADPlan{RemoveMembers: removals(2), MemberCount: 2}
+			},
+			want: []string{"AD sales@example.com: 3 removals"},
+		},
+		{
+			name:   "run removal count",
+			limits: &config.Safety{MaxRunRemovals: 3},
+			want:   []string{"4 removals in total, more than max_run_removals 3"},
+		},
+		{
+			name:   "run removal percent",
+			limits: &config.Safety{MaxRunRemovalPercent: 15},
+			want:   []string{"removes 20% of 20 current members in total, more than max_run_removal_percent 15"},
+		},
+		{
+			name:   "orphans count towards the run only",
+			limits: &config.Safety{MaxGroupRemovals: 5, MaxRunRemovals: 10},
+			edit: func(p *plan.Plan) {
+				p.Orphans = []plan.OrphanPlan{{Target: "google", Mail: "old@example.com", Action: plan.OrphanRetire, RemoveMembers: removals(8), MemberCount: 8}}
+			},
+			want: []string{"12 removals in total, more than max_run_removals 10"},
+		},
+		{
+			name:   "every violation is listed",
+			limits: &config.Safety{MinSourceUsers: 500, MaxGroupRemovals: 1, MaxRunRemovals: 1},
+			want: []string{
+				"fewer than min_source_users 500",
+				"AD sales@example.com: 3 removals",
+				"4 removals in total",
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p := safetyPlan()
+			if tt.edit != nil {
+				tt.edit(p)
+			}
+
+			err := CheckSafety(p, tt.limits)
+			if len(tt.want) == 0 {
+				if err != nil {
+					t.Fatalf("CheckSafety() = %v, want nil", err)
+				}
+				return
+			}
+			if !errors.Is(err, ErrUnsafe) {
+				t.Fatalf("CheckSafety() = %v, want ErrUnsafe", err)
+			}
+			lines := strings.Split(err.Error(), "\n")[1:]
+			if len(lines) != len(tt.want) {
+				t.Fatalf("CheckSafety() = %v, want %d violations", err, len(tt.want))
+			}
+			for i, want := range tt.want {
+				if !strings.Contains(lines[i], want) {
+					t.Errorf("violation %d = %q, want it to contain %q", i, lines[i], want)
+				}
+			}
+		})
+	}
+}
+
+func TestRunAllGroupSyncsForce(t *testing.T) {
+	// The rule syncs to AD only and the run to Google only, so the plan holds groups without changes and
+	// nothing touches a directory.
+	rule := loadRule(t, "rules:\n  - id: everyone\n    value: employees\n    targets: [ad]\n")
+	cfg := &config.Config{Rules: []config.Rule{rule}, Safety: &config.Safety{MinSourceUsers: 10}}
+	users := []active_directory.ADUser{{SAMAccountName: "ann", Email: "ann@example.com"}}
+	t.Setenv("GROUP_EMAIL_DOMAIN", "example.com")
+
+	for _, force := range []bool{false, true} {
+		t.Run(fmt.Sprintf("force=%v", force), func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "state.json")
+			st, err := state.Open(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			err = RunAllGroupSyncs(nil, nil, cfg, st, users, Options{Force: force, Targets: []string{config.TargetGoogle}})
+
+			run := st.LastRun()
+			if run == nil {
+				t.Fatal("RunAllGroupSyncs() recorded no run")
+			}
+			if force {
+				if err != nil || run.Outcome != state.OutcomeSuccess || !run.Forced {
+					t.Errorf("forced run: err %v, outcome %s, forced %v; want success recorded as forced", err, run.Outcome, run.Forced)
+				}
+				return
+			}
+			if !errors.Is(err, ErrUnsafe) || run.Outcome != state.OutcomeAborted {
+				t.Errorf("unforced run: err %v, outcome %s; want ErrUnsafe and aborted", err, run.Outcome)
+			}
+			if saved, err := state.Open(path); err != nil || saved.LastRun() == nil {
+				t.Errorf("aborted run was not saved: %v", err)
+			}
+		})
+	}
+}
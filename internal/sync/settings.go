@@ -0,0 +1,52 @@
+package sync
+
+import (
+	"fmt"
+
+	"github.com/matthewdavidson09/dynamic-distro-groups/internal/config"
+	"google.golang.org/api/groupssettings/v1"
+)
+
+// DefaultGroupSettings returns the announcement-style settings applied to every list by default:
+// members receive mail, only managers can post.
+func DefaultGroupSettings() *groupssettings.Groups {
+	return &groupssettings.Groups{
+		AllowExternalMembers:       "false",
+		AllowWebPosting:            "false",
+		AllowGoogleCommunication:   "false",
+		IncludeInGlobalAddressList: "false",
+		IsArchived:                 "true",
+		MembersCanPostAsTheGroup:   "false",
+		ShowInGroupDirectory:       "false",
+		MessageModerationLevel:     "MODERATE_NONE",
+		WhoCanContactOwner:         "ALL_IN_DOMAIN_CAN_CONTACT",
+		WhoCanAdd:                  "NONE_CAN_ADD",
+		WhoCanDiscoverGroup:        "ALL_MEMBERS_CAN_DISCOVER",
+		WhoCanJoin:                 "INVITED_CAN_JOIN",
+		WhoCanLeaveGroup:           "NONE_CAN_LEAVE",
+		WhoCanPostMessage:          "ALL_MANAGERS_CAN_POST",
+		WhoCanViewGroup:            "ALL_MEMBERS_CAN_VIEW",
+		WhoCanViewMembership:       "ALL_MANAGERS_CAN_VIEW",
+		WhoCanInvite:               "NONE_CAN_INVITE",
+		ReplyTo:                    "REPLY_TO_MANAGERS",
+	}
+}
+
+// GroupSettingsForProfile overlays a named settings profile from the rules file on top of the defaults.
+// Profile keys use the Groups Settings API field names, e.g. "whoCanPostMessage".
+func GroupSettingsForProfile(cfg *config.Config, name string) (*groupssettings.Groups, error) {
+	settings := DefaultGroupSettings()
+	if name == "" || name == config.DefaultSettingsProfile {
+		return settings, nil
+	}
+
+	profile, ok := cfg.SettingsProfiles[name]
+	if !ok {
+		return nil, fmt.Errorf("unknown settings profile %q", name)
+	}
+
+	if err := config.ApplySettingsProfile(settings, profile); err != nil {
+		return nil, fmt.Errorf("failed to apply settings profile %q: %w", name, err)
+	}
+	return settings, nil
+}
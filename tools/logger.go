@@ -18,13 +18,17 @@ type SyncMetrics struct {
 
 var Log = logrus.New()
 
+func init() {
+	Log.SetFormatter(redactingFormatter{Log.Formatter})
+}
+
 func InitLogger() {
-	Log.SetFormatter(&logrus.TextFormatter{
+	Log.SetFormatter(redactingFormatter{&logrus.TextFormatter{
 		FullTimestamp:   true,
 		TimestampFormat: "2006-01-02 15:04:05",
 		DisableColors:   false,
 		PadLevelText:    true,
-	})
+	}})
 	Log.SetLevel(logrus.InfoLevel)
 }
 
@@ -0,0 +1,72 @@
+package tools
+
+import (
+	"slices"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/sirupsen/logrus"
+)
+
+// redactedText replaces secret values in log output.
+const redactedText = "[REDACTED]"
+
+var redactions struct {
+	sync.RWMutex
+	values   []string
+	replacer *strings.Replacer
+}
+
+// RedactSecret hides value, and each line of a multi-line value, wherever it appears in log output
+// from now on.
+func RedactSecret(value string) {
+	parts := []string{value}
+	if strings.Contains(value, "\n") {
+		parts = append(parts, strings.Split(value, "\n")...)
+	}
+
+	redactions.Lock()
+	defer redactions.Unlock()
+
+	for _, part := range parts {
+		// Short fragments such as PEM line endings would mangle unrelated text.
+		if part = strings.TrimSpace(part); len(part) < 4 || slices.Contains(redactions.values, part) {
+			continue
+		}
+		redactions.values = append(redactions.values, part)
+	}
+
+	// The replacer tries patterns in order, so longer values win over values they contain.
+	sort.Slice(redactions.values, func(i, j int) bool { return len(redactions.values[i]) > len(redactions.values[j]) })
+	pairs := make([]string, 0, 2*len(redactions.values))
+	for _, v := range redactions.values {
+		pairs = append(pairs, v, redactedText)
+	}
+	redactions.replacer = strings.NewReplacer(pairs...)
+}
+
+// Redact returns s with every registered secret replaced.
+func Redact(s string) string {
+	redactions.RLock()
+	defer redactions.RUnlock()
+
+	if redactions.replacer == nil {
+		return s
+	}
+	return redactions.replacer.Replace(s)
+}
+
+// redactingFormatter removes registered secrets from formatted log entries, whichever message or
+// field they ended up in.
+type redactingFormatter struct {
+	logrus.Formatter
+}
+
+func (f redactingFormatter) Format(entry *logrus.Entry) ([]byte, error) {
+	out, err := f.Formatter.Format(entry)
+	if err != nil {
+		return nil, err
+	}
+	return []byte(Redact(string(out))), nil
+}
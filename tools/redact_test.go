@@ -0,0 +1,42 @@
+package tools
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"github.com/sirupsen/logrus"
+)
+
+func TestRedact(t *testing.T) {
+	RedactSecret("hunter2-long")
+	RedactSecret("hunter2-long-and-longer")
+	RedactSecret("-----BEGIN KEY-----\nMIIEvQIBADANBg\n-----END KEY-----")
+	RedactSecret("abc")
+
+	tests := map[string]string{
+		"password=hunter2-long":            "password=[REDACTED]",
+		"token hunter2-long-and-longer ok": "token [REDACTED] ok",
+		"line MIIEvQIBADANBg of a key":     "line [REDACTED] of a key",
+		"abc is too short to redact":       "abc is too short to redact",
+	}
+	for in, want := range tests {
+		if got := Redact(in); got != want {
+			t.Errorf("Redact(%q) = %q, want %q", in, got, want)
+		}
+	}
+}
+
+func TestRedactingFormatter(t *testing.T) {
+	RedactSecret("s3cr3t-formatter-value")
+
+	var out bytes.Buffer
+	logger := logrus.New()
+	logger.SetOutput(&out)
+	logger.SetFormatter(redactingFormatter{&logrus.TextFormatter{DisableTimestamp: true}})
+
+	logger.WithField("token", "s3cr3t-formatter-value").Errorf("bind failed for s3cr3t-formatter-value")
+	if got := out.String(); strings.Contains(got, "s3cr3t") || strings.Count(got, "[REDACTED]") != 2 {
+		t.Errorf("formatted entry = %q, want the secret redacted in the message and the field", got)
+	}
+}
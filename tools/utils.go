@@ -106,3 +106,26 @@ func MapKeys[T any](m map[string]T) []string {
 	}
 	return keys
 }
+
+// ParseGUID converts a GUID string formatted by FormatGUID back into the raw objectGUID bytes.
+func ParseGUID(s string) ([]byte, error) {
+	hex := strings.ReplaceAll(s, "-", "")
+	if len(hex) != 32 {
+		return nil, fmt.Errorf("invalid GUID %q", s)
+	}
+
+	raw := make([]byte, 16)
+	for i := range raw {
+		v, err := strconv.ParseUint(hex[i*2:i*2+2], 16, 8)
+		if err != nil {
+			return nil, fmt.Errorf("invalid GUID %q", s)
+		}
+		raw[i] = byte(v)
+	}
+
+	// The first three groups are stored little-endian
+	raw[0], raw[1], raw[2], raw[3] = raw[3], raw[2], raw[1], raw[0]
+	raw[4], raw[5] = raw[5], raw[4]
+	raw[6], raw[7] = raw[7], raw[6]
+	return raw, nil
+}